@@ -36,7 +36,6 @@ require (
 	github.com/montanaflynn/stats v0.12.3
 	github.com/redis/go-redis/v9 v9.22.0
 	github.com/stretchr/testify v1.12.0
-	github.com/tomasen/fcgi_client v0.0.0-20180423082037-2bb3d819fd19
 	github.com/urfave/cli v1.22.17
 	github.com/yusufpapurcu/wmi v1.2.4
 	golang.org/x/text v0.41.0
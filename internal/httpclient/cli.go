@@ -0,0 +1,46 @@
+package httpclient
+
+import (
+	"github.com/urfave/cli"
+)
+
+// CLIFlags returns the flags of the options for plugins built on urfave/cli. Values of o are used as default values.
+func (o Options) CLIFlags() []cli.Flag {
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return []cli.Flag{
+		cli.StringFlag{Name: "timeout", Value: timeout.String(), Usage: "HTTP request timeout (e.g. 5s, or number of seconds)"},
+		cli.StringSliceFlag{Name: "header, H", Value: &cli.StringSlice{}, Usage: "Set http header. (e.g. \"Host: servername\")", EnvVar: "ENVVAR_HEADER"},
+		cli.StringFlag{Name: "user", Value: o.User, Usage: "Basic auth user"},
+		cli.StringFlag{Name: "password", Value: o.Password, Usage: "Basic auth password"},
+		cli.StringFlag{Name: "bearer-token", Value: o.BearerToken, Usage: "Bearer token for Authorization header"},
+		cli.StringFlag{Name: "tls-ca-cert", Value: o.TLSCACert, Usage: "CA certificate file to verify the server"},
+		cli.StringFlag{Name: "tls-client-cert", Value: o.TLSClientCert, Usage: "Client certificate file"},
+		cli.StringFlag{Name: "tls-client-key", Value: o.TLSClientKey, Usage: "Client private key file"},
+		cli.BoolFlag{Name: "tls-skip-verify", Usage: "Skip TLS certificate verification"},
+		cli.StringFlag{Name: "unix-socket", Value: o.UnixSocket, Usage: "Connect to the unix domain socket instead of the host of the URL"},
+		cli.StringFlag{Name: "proxy", Value: o.Proxy, Usage: "Proxy URL (default: HTTP_PROXY/HTTPS_PROXY environment variables)"},
+	}
+}
+
+// SetCLIContext sets the options from the flags defined by CLIFlags.
+func (o *Options) SetCLIContext(c *cli.Context) error {
+	timeout, err := parseTimeout(c.String("timeout"))
+	if err != nil {
+		return err
+	}
+	o.Timeout = timeout
+	o.Header = c.StringSlice("header")
+	o.User = c.String("user")
+	o.Password = c.String("password")
+	o.BearerToken = c.String("bearer-token")
+	o.TLSCACert = c.String("tls-ca-cert")
+	o.TLSClientCert = c.String("tls-client-cert")
+	o.TLSClientKey = c.String("tls-client-key")
+	o.TLSSkipVerify = c.Bool("tls-skip-verify")
+	o.UnixSocket = c.String("unix-socket")
+	o.Proxy = c.String("proxy")
+	return nil
+}
@@ -0,0 +1,224 @@
+// Package httpclient provides the HTTP client shared among the plugins which fetch status pages over HTTP.
+//
+// All of those plugins accept the same flags: -timeout, -header, -user, -password, -bearer-token,
+// -tls-ca-cert, -tls-client-cert, -tls-client-key, -tls-skip-verify, -unix-socket and -proxy.
+package httpclient
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/tlsutil"
+)
+
+// DefaultTimeout is used when Options.Timeout is zero.
+const DefaultTimeout = 10 * time.Second
+
+// Options represents the options of the HTTP client.
+// The zero value is ready to use; it behaves like http.DefaultClient with DefaultTimeout.
+type Options struct {
+	// Timeout of the whole request
+	Timeout time.Duration
+	// Header is a list of "Name: value". "Host" header overrides the host of the request.
+	Header []string
+	// User and Password for basic authentication
+	User     string
+	Password string
+	// BearerToken for "Authorization: Bearer" authentication
+	BearerToken string
+	// TLSCACert is a path to PEM encoded CA certificates to verify the server
+	TLSCACert string
+	// TLSClientCert and TLSClientKey are paths to PEM encoded client certificate and key (mTLS)
+	TLSClientCert string
+	TLSClientKey  string
+	TLSSkipVerify bool
+	// UnixSocket is a path to the unix domain socket to connect instead of the host of the URL
+	UnixSocket string
+	// Proxy is a proxy URL. Environment variables (HTTP_PROXY, HTTPS_PROXY and NO_PROXY) are used if empty.
+	Proxy string
+	// UserAgent is sent unless Header has "User-Agent"
+	UserAgent string
+	// Transport overrides the transport built from the options above, e.g. FastCGI
+	Transport http.RoundTripper
+}
+
+// durationValue accepts either a duration ("500ms", "5s") or an integer as seconds for backward compatibility.
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		*d = durationValue(time.Duration(n) * time.Second)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+type stringSlice []string
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (s *stringSlice) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
+// Register defines the flags of the options in fs. Current values of o are used as default values.
+func (o *Options) Register(fs *flag.FlagSet) {
+	if o.Timeout == 0 {
+		o.Timeout = DefaultTimeout
+	}
+	fs.Var((*durationValue)(&o.Timeout), "timeout", "HTTP request timeout (e.g. 5s, or number of seconds)")
+	fs.Var((*stringSlice)(&o.Header), "header", "Set http header (e.g. \"Host: servername\")")
+	fs.StringVar(&o.User, "user", o.User, "Basic auth user")
+	fs.StringVar(&o.Password, "password", o.Password, "Basic auth password")
+	fs.StringVar(&o.BearerToken, "bearer-token", o.BearerToken, "Bearer token for Authorization header")
+	fs.StringVar(&o.TLSCACert, "tls-ca-cert", o.TLSCACert, "CA certificate file to verify the server")
+	fs.StringVar(&o.TLSClientCert, "tls-client-cert", o.TLSClientCert, "Client certificate file")
+	fs.StringVar(&o.TLSClientKey, "tls-client-key", o.TLSClientKey, "Client private key file")
+	fs.BoolVar(&o.TLSSkipVerify, "tls-skip-verify", o.TLSSkipVerify, "Skip TLS certificate verification")
+	fs.StringVar(&o.UnixSocket, "unix-socket", o.UnixSocket, "Connect to the unix domain socket instead of the host of the URL")
+	fs.StringVar(&o.Proxy, "proxy", o.Proxy, "Proxy URL (default: HTTP_PROXY/HTTPS_PROXY environment variables)")
+}
+
+// parseTimeout parses the value of -timeout flag, which is either a duration or a number of seconds.
+func parseTimeout(s string) (time.Duration, error) {
+	var d durationValue
+	if err := d.Set(s); err != nil {
+		return 0, err
+	}
+	return time.Duration(d), nil
+}
+
+// Client is an HTTP client built from Options.
+type Client struct {
+	client  *http.Client
+	options Options
+}
+
+// NewClient returns a new Client.
+func (o Options) NewClient() (*Client, error) {
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	transport, err := o.transport()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		options: o,
+	}, nil
+}
+
+// transport returns nil, which means http.DefaultTransport, unless any option requires its own transport.
+func (o Options) transport() (http.RoundTripper, error) {
+	if o.Transport != nil {
+		return o.Transport, nil
+	}
+	if o.TLSCACert == "" && o.TLSClientCert == "" && o.TLSClientKey == "" && !o.TLSSkipVerify && o.UnixSocket == "" && o.Proxy == "" {
+		return nil, nil
+	}
+
+	var tr *http.Transport
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		tr = t.Clone()
+	} else {
+		tr = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+
+	tlsConfig, err := tlsutil.TLSConfig(o.TLSCACert, o.TLSClientCert, o.TLSClientKey, o.TLSSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	tr.TLSClientConfig = tlsConfig
+
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+
+	if o.UnixSocket != "" {
+		socket := o.UnixSocket
+		tr.Proxy = nil
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+	return tr, nil
+}
+
+// NewRequest returns a new request with the headers and credentials of the options.
+func (c *Client) NewRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	o := c.options
+	if o.User != "" {
+		req.SetBasicAuth(o.User, o.Password)
+	}
+	if o.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+o.BearerToken)
+	}
+	for _, h := range o.Header {
+		kv := strings.SplitN(h, ":", 2)
+		var k, v string
+		k = strings.TrimSpace(kv[0])
+		if len(kv) == 2 {
+			v = strings.TrimSpace(kv[1])
+		}
+		if http.CanonicalHeaderKey(k) == "Host" {
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+
+	// set default User-Agent unless specified by o.Header
+	if _, ok := req.Header["User-Agent"]; !ok && o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
+	return req, nil
+}
+
+// Do sends the request.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req)
+}
+
+// Get sends a GET request to url.
+func (c *Client) Get(url string) (*http.Response, error) {
+	req, err := c.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
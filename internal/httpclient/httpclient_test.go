@@ -0,0 +1,150 @@
+package httpclient
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	opts := Options{Timeout: 5 * time.Second, Password: "env"}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.Register(fs)
+
+	assert.Equal(t, "5s", fs.Lookup("timeout").DefValue)
+	assert.Equal(t, "env", fs.Lookup("password").DefValue)
+
+	err := fs.Parse([]string{"-timeout", "3", "-header", "Host: example.com", "-header", "X-Foo: bar", "-user", "admin", "-tls-skip-verify"})
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, opts.Timeout)
+	assert.Equal(t, []string{"Host: example.com", "X-Foo: bar"}, opts.Header)
+	assert.Equal(t, "admin", opts.User)
+	assert.Equal(t, "env", opts.Password)
+	assert.True(t, opts.TLSSkipVerify)
+
+	require.NoError(t, fs.Parse([]string{"-timeout", "1500ms"}))
+	assert.Equal(t, 1500*time.Millisecond, opts.Timeout)
+	assert.Error(t, fs.Parse([]string{"-timeout", "abc"}))
+}
+
+func TestNewRequest(t *testing.T) {
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		opts   Options
+		assert func(t *testing.T, r *http.Request)
+	}{
+		{
+			name: "headers",
+			opts: Options{UserAgent: "mackerel-plugin-test", Header: []string{"Host: example.com", "X-Foo: bar"}},
+			assert: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "example.com", r.Host)
+				assert.Equal(t, "bar", r.Header.Get("X-Foo"))
+				assert.Equal(t, "mackerel-plugin-test", r.UserAgent())
+			},
+		},
+		{
+			name: "user agent in headers",
+			opts: Options{UserAgent: "mackerel-plugin-test", Header: []string{"User-Agent: custom"}},
+			assert: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "custom", r.UserAgent())
+			},
+		},
+		{
+			name: "basic auth",
+			opts: Options{User: "admin", Password: "secret"},
+			assert: func(t *testing.T, r *http.Request) {
+				user, password, ok := r.BasicAuth()
+				assert.True(t, ok)
+				assert.Equal(t, "admin", user)
+				assert.Equal(t, "secret", password)
+			},
+		},
+		{
+			name: "bearer token",
+			opts: Options{BearerToken: "token"},
+			assert: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := tc.opts.NewClient()
+			require.NoError(t, err)
+			resp, err := client.Get(ts.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+			tc.assert(t, got)
+		})
+	}
+}
+
+func TestTLSSkipVerify(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client, err := Options{}.NewClient()
+	require.NoError(t, err)
+	_, err = client.Get(ts.URL)
+	assert.Error(t, err)
+
+	client, err = Options{TLSSkipVerify: true}.NewClient()
+	require.NoError(t, err)
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	client, err := Options{UnixSocket: sock}.NewClient()
+	require.NoError(t, err)
+	resp, err := client.Get("http://localhost/status")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	client, err := Options{Timeout: 50 * time.Millisecond}.NewClient()
+	require.NoError(t, err)
+	_, err = client.Get(ts.URL)
+	assert.Error(t, err)
+}
+
+func TestInvalidTLSFiles(t *testing.T) {
+	_, err := Options{TLSCACert: "/nonexistent/ca.pem"}.NewClient()
+	assert.Error(t, err)
+	_, err = Options{TLSClientCert: "/nonexistent/cert.pem"}.NewClient()
+	assert.Error(t, err)
+	_, err = Options{TLSClientKey: "/nonexistent/key.pem"}.NewClient()
+	assert.ErrorContains(t, err, "both client certificate and key are required")
+}
@@ -0,0 +1,55 @@
+// Package promtext parses the samples of the Prometheus text exposition format,
+// which is shared among the plugins fetching the metrics of Prometheus exporters.
+//
+// See also. https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+package promtext
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseLine parses a sample line: name{label="value",...} value [timestamp]
+//
+// The value is returned as is, such as "12", "1e+06" or "NaN". Comment lines are not accepted.
+func ParseLine(line string) (name string, labels map[string]string, value string, err error) {
+	labels = make(map[string]string)
+	i := strings.IndexAny(line, "{ \t")
+	if i < 0 {
+		return "", nil, "", fmt.Errorf("no value: %q", line)
+	}
+	name, rest := line[:i], line[i:]
+	if name == "" {
+		return "", nil, "", fmt.Errorf("no metric name: %q", line)
+	}
+
+	if strings.HasPrefix(rest, "{") {
+		rest = strings.TrimLeft(rest[1:], " ")
+		for !strings.HasPrefix(rest, "}") {
+			key, v, ok := strings.Cut(rest, "=")
+			if !ok {
+				return "", nil, "", fmt.Errorf("invalid labels: %q", line)
+			}
+			// the escapes of label values (\\, \" and \n) are those of Go
+			quoted, err := strconv.QuotedPrefix(strings.TrimLeft(v, " "))
+			if err != nil || !strings.HasPrefix(quoted, `"`) {
+				return "", nil, "", fmt.Errorf("invalid labels: %q", line)
+			}
+			labels[strings.TrimSpace(key)], _ = strconv.Unquote(quoted)
+			rest = strings.TrimLeft(strings.TrimLeft(v, " ")[len(quoted):], " ")
+			// a trailing comma is allowed
+			rest = strings.TrimLeft(strings.TrimPrefix(rest, ","), " ")
+			if rest == "" {
+				return "", nil, "", fmt.Errorf("invalid labels: %q", line)
+			}
+		}
+		rest = rest[1:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, "", fmt.Errorf("no value: %q", line)
+	}
+	return name, labels, fields[0], nil
+}
@@ -0,0 +1,39 @@
+package promtext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	name, labels, value, err := ParseLine(`fluentbit_input_records_total{name="a \"quoted\", alias",hostname="localhost"} 12 1700000000000`)
+	require.NoError(t, err)
+	assert.Equal(t, "fluentbit_input_records_total", name)
+	assert.Equal(t, map[string]string{"name": `a "quoted", alias`, "hostname": "localhost"}, labels)
+	assert.Equal(t, "12", value)
+
+	name, labels, value, err = ParseLine(`haproxy_server_status{proxy="a\\b",server="s,1",} 1`)
+	require.NoError(t, err)
+	assert.Equal(t, "haproxy_server_status", name)
+	assert.Equal(t, map[string]string{"proxy": `a\b`, "server": "s,1"}, labels)
+	assert.Equal(t, "1", value)
+
+	name, labels, value, err = ParseLine(`fluentbit_build_info 1`)
+	require.NoError(t, err)
+	assert.Equal(t, "fluentbit_build_info", name)
+	assert.Empty(t, labels)
+	assert.Equal(t, "1", value)
+
+	for _, line := range []string{
+		`fluentbit_input_records_total{name="cpu.0"`,
+		`haproxy_server_status{proxy="a`,
+		`haproxy_server_status{proxy=a} 1`,
+		`haproxy_server_status{proxy="a"}`,
+		`fluentbit_build_info`,
+	} {
+		_, _, _, err := ParseLine(line)
+		assert.Error(t, err, line)
+	}
+}
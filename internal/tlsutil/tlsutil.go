@@ -0,0 +1,39 @@
+// Package tlsutil builds the TLS configurations shared among the plugins which connect to servers over TLS,
+// from their -tls-ca-cert, -tls-client-cert, -tls-client-key and -tls-skip-verify flags.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig returns the TLS configuration verifying the server by the CA certificates in caCert,
+// or by the system ones if empty, and presenting the client certificate of clientCert and clientKey if not empty.
+func TLSConfig(caCert, clientCert, clientKey string, skipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: skipVerify}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, errors.New("both client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
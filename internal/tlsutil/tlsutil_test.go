@@ -0,0 +1,23 @@
+package tlsutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig(t *testing.T) {
+	c, err := TLSConfig("", "", "", true)
+	require.NoError(t, err)
+	assert.True(t, c.InsecureSkipVerify)
+	assert.Nil(t, c.RootCAs)
+	assert.Empty(t, c.Certificates)
+
+	_, err = TLSConfig("/nonexistent/ca.pem", "", "", false)
+	assert.Error(t, err)
+	_, err = TLSConfig("", "/nonexistent/cert.pem", "/nonexistent/key.pem", false)
+	assert.Error(t, err)
+	_, err = TLSConfig("", "/nonexistent/cert.pem", "", false)
+	assert.ErrorContains(t, err, "both client certificate and key are required")
+}
@@ -12,18 +12,20 @@ import (
 	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 	"github.com/urfave/cli"
 )
 
 // Apache2Plugin for fetching metrics
 type Apache2Plugin struct {
+	Scheme      string
 	Host        string
 	Port        uint16
 	Path        string
-	Header      []string
 	Tempfile    string
 	Prefix      string
 	LabelPrefix string
+	httpclient.Options
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -70,20 +72,80 @@ func (c Apache2Plugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 		"scoreboard": {
-			Label: (labelPrefix + " Scoreboard"),
+			Label:   (labelPrefix + " Scoreboard"),
+			Unit:    "integer",
+			Metrics: scoreboardMetrics(),
+		},
+		"worker_saturation": {
+			Label: (labelPrefix + " Worker Saturation"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "worker_saturation", Label: "Busy / (Busy + Idle + Open slots)", Diff: false},
+			},
+		},
+		"req_per_sec": {
+			Label: (labelPrefix + " Requests per Second"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "req_per_sec", Label: "Requests per second", Diff: false},
+			},
+		},
+		"bytes_per": {
+			Label: (labelPrefix + " Average Bytes"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "bytes_per_sec", Label: "Bytes per second", Diff: false},
+				{Name: "bytes_per_req", Label: "Bytes per request", Diff: false},
+			},
+		},
+		"duration": {
+			Label: (labelPrefix + " Request Duration (ms)"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "duration_per_req", Label: "Duration per request", Diff: false},
+			},
+		},
+		"total_duration": {
+			Label: (labelPrefix + " Total Request Duration (ms)"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "total_duration", Label: "Total duration", Diff: true, Type: "uint64"},
+			},
+		},
+		"connections": {
+			Label: (labelPrefix + " Connections"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "conns_total", Label: "Total", Diff: false},
+				{Name: "conns_async_writing", Label: "Async writing", Diff: false},
+				{Name: "conns_async_keep_alive", Label: "Async keep-alive", Diff: false},
+				{Name: "conns_async_closing", Label: "Async closing", Diff: false},
+			},
+		},
+		"uptime": {
+			Label: (labelPrefix + " Uptime"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "uptime", Label: "Uptime (sec)", Diff: false},
+			},
+		},
+		"load": {
+			Label: (labelPrefix + " Load Average"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "load1", Label: "Load 1", Diff: false},
+				{Name: "load5", Label: "Load 5", Diff: false},
+				{Name: "load15", Label: "Load 15", Diff: false},
+			},
+		},
+		"mpm": {
+			Label: (labelPrefix + " MPM"),
 			Unit:  "integer",
 			Metrics: []mp.Metrics{
-				{Name: "score-_", Label: "Waiting for connection", Diff: false, Stacked: true},
-				{Name: "score-S", Label: "Starting up", Diff: false, Stacked: true},
-				{Name: "score-R", Label: "Reading request", Diff: false, Stacked: true},
-				{Name: "score-W", Label: "Sending reply", Diff: false, Stacked: true},
-				{Name: "score-K", Label: "Keepalive", Diff: false, Stacked: true},
-				{Name: "score-D", Label: "DNS lookup", Diff: false, Stacked: true},
-				{Name: "score-C", Label: "Closing connection", Diff: false, Stacked: true},
-				{Name: "score-L", Label: "Logging", Diff: false, Stacked: true},
-				{Name: "score-G", Label: "Gracefully finishing", Diff: false, Stacked: true},
-				{Name: "score-I", Label: "Idle cleanup", Diff: false, Stacked: true},
-				{Name: "score-", Label: "Open slot", Diff: false, Stacked: true},
+				{Name: "mpm_prefork", Label: "prefork", Diff: false},
+				{Name: "mpm_worker", Label: "worker", Diff: false},
+				{Name: "mpm_event", Label: "event", Diff: false},
+				{Name: "mpm_winnt", Label: "WinNT", Diff: false},
 			},
 		},
 	}
@@ -95,12 +157,18 @@ func doMain(c *cli.Context) error {
 
 	var apache2 Apache2Plugin
 
+	apache2.Scheme = c.String("scheme")
+	if apache2.Scheme != "http" && apache2.Scheme != "https" {
+		return fmt.Errorf("invalid scheme: %s", apache2.Scheme)
+	}
 	apache2.Host = c.String("http_host")
 	apache2.Port = uint16(c.Int("http_port"))
 	apache2.Path = c.String("status_page")
-	apache2.Header = c.StringSlice("header")
 	apache2.Prefix = c.String("metric-key-prefix")
 	apache2.LabelPrefix = c.String("metric-label-prefix")
+	if err := apache2.Options.SetCLIContext(c); err != nil {
+		return err
+	}
 
 	helper := mp.NewMackerelPlugin(apache2)
 	helper.Tempfile = c.String("tempfile")
@@ -111,7 +179,7 @@ func doMain(c *cli.Context) error {
 
 // FetchMetrics fetch the metrics
 func (c Apache2Plugin) FetchMetrics() (map[string]any, error) {
-	data, err := getApache2Metrics(c.Host, c.Port, c.Path, c.Header)
+	data, err := getApache2Metrics(c.Scheme, c.Host, c.Port, c.Path, c.Options)
 	if err != nil {
 		return nil, err
 	}
@@ -125,10 +193,51 @@ func (c Apache2Plugin) FetchMetrics() (map[string]any, error) {
 	if errScore != nil {
 		return nil, errScore
 	}
+	setWorkerSaturation(stat)
 
 	return stat, nil
 }
 
+// scoreboardStates maps the characters of the scoreboard to metric names.
+var scoreboardStates = []struct {
+	char  string
+	name  string
+	label string
+}{
+	{"_", "score_waiting", "Waiting for connection"},
+	{"S", "score_starting", "Starting up"},
+	{"R", "score_reading", "Reading request"},
+	{"W", "score_sending", "Sending reply"},
+	{"K", "score_keepalive", "Keepalive"},
+	{"D", "score_dns_lookup", "DNS lookup"},
+	{"C", "score_closing", "Closing connection"},
+	{"L", "score_logging", "Logging"},
+	{"G", "score_graceful", "Gracefully finishing"},
+	{"I", "score_idle_cleanup", "Idle cleanup"},
+	{".", "score_open_slot", "Open slot"},
+}
+
+func scoreboardMetrics() []mp.Metrics {
+	metrics := make([]mp.Metrics, 0, len(scoreboardStates))
+	for _, s := range scoreboardStates {
+		metrics = append(metrics, mp.Metrics{Name: s.name, Label: s.label, Diff: false, Stacked: true})
+	}
+	return metrics
+}
+
+// setWorkerSaturation sets the ratio of busy workers to the worker limit, i.e. busy / (busy + idle + open slots).
+func setWorkerSaturation(stat map[string]any) {
+	busy, ok := stat["busy_workers"].(float64)
+	if !ok {
+		return
+	}
+	idle, _ := stat["idle_workers"].(float64)
+	open, _ := stat["score_open_slot"].(float64)
+	if total := busy + idle + open; total > 0 {
+		stat["worker_saturation"] = busy * 100 / total
+	}
+}
+
 var scoreboardLine = regexp.MustCompile("Scoreboard(.*)")
 
 // parsing scoreboard from server-status?auto
@@ -137,17 +246,20 @@ func parseApache2Scoreboard(str string, p *map[string]any) error {
 		if !scoreboardLine.MatchString(line) {
 			continue
 		}
-		record := strings.Split(line, ":")
-		for sb := range strings.SplitSeq(strings.Trim(record[1], " "), "") {
-			if sb == "." {
-				sb = ""
-			}
-			name := fmt.Sprintf("score-%s", sb)
-			c, assert := (*p)[name].(float64)
-			if !assert {
-				c = 0.0
+		for _, state := range scoreboardStates {
+			(*p)[state.name] = 0.0
+		}
+		record := strings.SplitN(line, ":", 2)
+		if len(record) != 2 {
+			return errors.New("scoreboard data is not found")
+		}
+		for sb := range strings.SplitSeq(strings.TrimSpace(record[1]), "") {
+			for _, state := range scoreboardStates {
+				if state.char == sb {
+					(*p)[state.name] = (*p)[state.name].(float64) + 1.0
+					break
+				}
 			}
-			(*p)[name] = c + 1.0
 		}
 		return nil
 	}
@@ -158,20 +270,42 @@ func parseApache2Scoreboard(str string, p *map[string]any) error {
 // parsing metrics from server-status?auto
 func parseApache2Status(str string, p *map[string]any) error {
 	Params := map[string]string{
-		"Total Accesses": "requests",
-		"Total kBytes":   "bytes_sent",
-		"CPULoad":        "cpu_load",
-		"BusyWorkers":    "busy_workers",
-		"IdleWorkers":    "idle_workers"}
+		"Total Accesses":      "requests",
+		"Total kBytes":        "bytes_sent",
+		"Total Duration":      "total_duration",
+		"CPULoad":             "cpu_load",
+		"Uptime":              "uptime",
+		"ReqPerSec":           "req_per_sec",
+		"BytesPerSec":         "bytes_per_sec",
+		"BytesPerReq":         "bytes_per_req",
+		"DurationPerReq":      "duration_per_req",
+		"BusyWorkers":         "busy_workers",
+		"IdleWorkers":         "idle_workers",
+		"ConnsTotal":          "conns_total",
+		"ConnsAsyncWriting":   "conns_async_writing",
+		"ConnsAsyncKeepAlive": "conns_async_keep_alive",
+		"ConnsAsyncClosing":   "conns_async_closing",
+		"Load1":               "load1",
+		"Load5":               "load5",
+		"Load15":              "load15"}
 
 	for line := range strings.SplitSeq(str, "\n") {
-		record := strings.Split(line, ":")
-		_, assert := Params[record[0]]
+		record := strings.SplitN(line, ":", 2)
+		if len(record) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if key == "ServerMPM" {
+			// e.g. "ServerMPM: event"
+			(*p)["mpm_"+strings.ToLower(value)] = 1.0
+			continue
+		}
+		name, assert := Params[key]
 		if !assert {
 			continue
 		}
 		var errParse error
-		(*p)[Params[record[0]]], errParse = strconv.ParseFloat(strings.Trim(record[1], " "), 64)
+		(*p)[name], errParse = strconv.ParseFloat(value, 64)
 		if errParse != nil {
 			return errParse
 		}
@@ -185,32 +319,14 @@ func parseApache2Status(str string, p *map[string]any) error {
 }
 
 // Getting apache2 status from server-status module data.
-func getApache2Metrics(host string, port uint16, path string, header []string) (string, error) {
-	uri := "http://" + host + ":" + strconv.FormatUint(uint64(port), 10) + path
-	req, err := http.NewRequest("GET", uri, nil)
+func getApache2Metrics(scheme, host string, port uint16, path string, options httpclient.Options) (string, error) {
+	uri := scheme + "://" + host + ":" + strconv.FormatUint(uint64(port), 10) + path
+	options.UserAgent = "mackerel-plugin-apache2"
+	client, err := options.NewClient()
 	if err != nil {
 		return "", err
 	}
-	for _, h := range header {
-		kv := strings.SplitN(h, ":", 2)
-		var k, v string
-		k = strings.TrimSpace(kv[0])
-		if len(kv) == 2 {
-			v = strings.TrimSpace(kv[1])
-		}
-		if http.CanonicalHeaderKey(k) == "Host" {
-			req.Host = v
-		} else {
-			req.Header.Set(k, v)
-		}
-	}
-
-	// set default User-Agent unless specified by header
-	if _, ok := req.Header["User-Agent"]; !ok {
-		req.Header.Set("User-Agent", "mackerel-plugin-apache2")
-	}
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Get(uri)
 	if err != nil {
 		return "", err
 	}
@@ -232,7 +348,7 @@ func Do() {
 	app.Usage = "Get metrics from apache2."
 	app.Author = "Yuichiro Saito"
 	app.Email = "saito@heartbeats.jp"
-	app.Flags = flags
+	app.Flags = append(flags, httpclient.Options{}.CLIFlags()...)
 	app.Action = doMain
 
 	err := app.Run(os.Args)
@@ -2,12 +2,14 @@ package mpapache2
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"strconv"
 	"testing"
 
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,17 +19,28 @@ func TestParseApache2Scoreboard(t *testing.T) {
 
 	err := parseApache2Scoreboard(stub, &stat)
 	assert.Nil(t, err)
-	assert.EqualValues(t, stat["score-_"], 1)
-	assert.EqualValues(t, stat["score-S"], 1)
-	assert.EqualValues(t, stat["score-R"], 1)
-	assert.EqualValues(t, stat["score-W"], 2)
-	assert.EqualValues(t, stat["score-K"], 1)
-	assert.EqualValues(t, stat["score-D"], 1)
-	assert.EqualValues(t, stat["score-C"], 1)
-	assert.EqualValues(t, stat["score-L"], 1)
-	assert.EqualValues(t, stat["score-G"], 1)
-	assert.EqualValues(t, stat["score-I"], 1)
-	assert.EqualValues(t, stat["score-"], 5)
+	assert.EqualValues(t, stat["score_waiting"], 1)
+	assert.EqualValues(t, stat["score_starting"], 1)
+	assert.EqualValues(t, stat["score_reading"], 1)
+	assert.EqualValues(t, stat["score_sending"], 2)
+	assert.EqualValues(t, stat["score_keepalive"], 1)
+	assert.EqualValues(t, stat["score_dns_lookup"], 1)
+	assert.EqualValues(t, stat["score_closing"], 1)
+	assert.EqualValues(t, stat["score_logging"], 1)
+	assert.EqualValues(t, stat["score_graceful"], 1)
+	assert.EqualValues(t, stat["score_idle_cleanup"], 1)
+	assert.EqualValues(t, stat["score_open_slot"], 5)
+}
+
+func TestParseApache2ScoreboardWithoutSomeStates(t *testing.T) {
+	stub := "Scoreboard: __W..."
+	stat := make(map[string]any)
+
+	err := parseApache2Scoreboard(stub, &stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["score_waiting"], 2)
+	assert.EqualValues(t, stat["score_keepalive"], 0)
+	assert.EqualValues(t, stat["score_open_slot"], 3)
 }
 
 func TestParseApache2Status(t *testing.T) {
@@ -52,6 +65,62 @@ IdleWorkers: 4
 	assert.EqualValues(t, stat["idle_workers"], 4)
 }
 
+func TestParseApache2ExtendedStatus(t *testing.T) {
+	stub := `localhost
+ServerVersion: Apache/2.4.57 (Unix)
+ServerMPM: event
+Server Built: Apr  4 2023 12:00:00
+CurrentTime: Monday, 03-Jul-2023 10:00:00 UTC
+RestartTime: Monday, 03-Jul-2023 09:00:00 UTC
+ParentServerConfigGeneration: 1
+ParentServerMPMGeneration: 0
+ServerUptimeSeconds: 3600
+ServerUptime: 1 hour
+Load1: 0.10
+Load5: 0.20
+Load15: 0.30
+Total Accesses: 1200
+Total kBytes: 3000
+Total Duration: 6000
+CPUUser: .5
+CPUSystem: .3
+CPUChildrenUser: 0
+CPUChildrenSystem: 0
+CPULoad: .0222222
+Uptime: 3600
+ReqPerSec: .333333
+BytesPerSec: 853.333
+BytesPerReq: 2560
+DurationPerReq: 5
+BusyWorkers: 2
+IdleWorkers: 48
+Processes: 2
+Stopping: 0
+ConnsTotal: 3
+ConnsAsyncWriting: 0
+ConnsAsyncKeepAlive: 1
+ConnsAsyncClosing: 0
+Scoreboard: __W_____________________________________________K_______________________________________________........................................................................
+`
+	stat := make(map[string]any)
+	assert.Nil(t, parseApache2Status(stub, &stat))
+	assert.Nil(t, parseApache2Scoreboard(stub, &stat))
+	setWorkerSaturation(stat)
+
+	assert.EqualValues(t, 1, stat["mpm_event"])
+	assert.EqualValues(t, 0.2, stat["load5"])
+	assert.EqualValues(t, 6000, stat["total_duration"])
+	assert.EqualValues(t, 3600, stat["uptime"])
+	assert.EqualValues(t, 0.333333, stat["req_per_sec"])
+	assert.EqualValues(t, 853.333, stat["bytes_per_sec"])
+	assert.EqualValues(t, 2560, stat["bytes_per_req"])
+	assert.EqualValues(t, 5, stat["duration_per_req"])
+	assert.EqualValues(t, 3, stat["conns_total"])
+	assert.EqualValues(t, 1, stat["conns_async_keep_alive"])
+	assert.EqualValues(t, 72, stat["score_open_slot"])
+	assert.EqualValues(t, 2*100.0/(2+48+72), stat["worker_saturation"])
+}
+
 func TestGetApache2Metrics_1(t *testing.T) {
 	stub := `Total Accesses: 668
 Total kBytes: 2789
@@ -79,7 +148,7 @@ Scoreboard: W_.__...........................`
 	path := found[4]
 	header := []string{fmt.Sprintf("Host: %s", found[2]), "X-Text-Header: test"}
 
-	ret, err := getApache2Metrics(host, uint16(port), path, header)
+	ret, err := getApache2Metrics("http", host, uint16(port), path, httpclient.Options{Header: header})
 	assert.Nil(t, err)
 	assert.NotNil(t, ret)
 	assert.NotEmpty(t, ret)
@@ -90,3 +159,19 @@ Scoreboard: W_.__...........................`
 	assert.Contains(t, ret, "IdleWorkers")
 	assert.Contains(t, ret, "Scoreboard")
 }
+
+func TestGetApache2Metrics_HTTPS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Total Accesses: 1")
+	}))
+	defer ts.Close()
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	_, err := getApache2Metrics("https", host, uint16(p), "/server-status?auto", httpclient.Options{})
+	assert.Error(t, err, "the certificate is not trusted")
+
+	ret, err := getApache2Metrics("https", host, uint16(p), "/server-status?auto", httpclient.Options{TLSSkipVerify: true})
+	assert.Nil(t, err)
+	assert.Contains(t, ret, "Total Accesses")
+}
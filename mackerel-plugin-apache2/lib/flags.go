@@ -5,15 +5,22 @@ import (
 )
 
 var flags = []cli.Flag{
+	cliScheme,
 	cliHTTPHost,
 	cliHTTPPort,
-	cliHeader,
 	cliStatusPage,
 	cliTempFile,
 	cliMetricKerPrefix,
 	cliLabelPrefix,
 }
 
+var cliScheme = cli.StringFlag{
+	Name:   "scheme",
+	Value:  "http",
+	Usage:  "Set scheme of server-status, http or https.",
+	EnvVar: "ENVVAR_SCHEME",
+}
+
 var cliHTTPHost = cli.StringFlag{
 	Name:   "http_host, o",
 	Value:  "127.0.0.1",
@@ -28,13 +35,6 @@ var cliHTTPPort = cli.IntFlag{
 	EnvVar: "ENVVAR_HTTP_PORT",
 }
 
-var cliHeader = cli.StringSliceFlag{
-	Name:   "header, H",
-	Value:  &cli.StringSlice{},
-	Usage:  "Set http header. (e.g. \"Host: servername\")",
-	EnvVar: "ENVVAR_HEADER",
-}
-
 var cliStatusPage = cli.StringFlag{
 	Name:   "status_page, s",
 	Value:  "/server-status?auto",
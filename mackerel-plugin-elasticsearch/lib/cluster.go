@@ -0,0 +1,311 @@
+package mpelasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
+)
+
+// clusterStatuses maps the status of the cluster health to the value of the status metric.
+var clusterStatuses = map[string]float64{
+	"green":  0,
+	"yellow": 1,
+	"red":    2,
+}
+
+type clusterHealth struct {
+	Status                      string  `json:"status"`
+	NumberOfNodes               float64 `json:"number_of_nodes"`
+	NumberOfDataNodes           float64 `json:"number_of_data_nodes"`
+	ActivePrimaryShards         float64 `json:"active_primary_shards"`
+	ActiveShards                float64 `json:"active_shards"`
+	RelocatingShards            float64 `json:"relocating_shards"`
+	InitializingShards          float64 `json:"initializing_shards"`
+	UnassignedShards            float64 `json:"unassigned_shards"`
+	DelayedUnassignedShards     float64 `json:"delayed_unassigned_shards"`
+	NumberOfPendingTasks        float64 `json:"number_of_pending_tasks"`
+	NumberOfInFlightFetch       float64 `json:"number_of_in_flight_fetch"`
+	ActiveShardsPercentAsNumber float64 `json:"active_shards_percent_as_number"`
+}
+
+type indexStats struct {
+	Primaries struct {
+		Docs struct {
+			Count float64 `json:"count"`
+		} `json:"docs"`
+	} `json:"primaries"`
+	Total struct {
+		Store struct {
+			SizeInBytes float64 `json:"size_in_bytes"`
+		} `json:"store"`
+		Indexing struct {
+			IndexTotal float64 `json:"index_total"`
+		} `json:"indexing"`
+		Search struct {
+			QueryTotal float64 `json:"query_total"`
+			FetchTotal float64 `json:"fetch_total"`
+		} `json:"search"`
+	} `json:"total"`
+}
+
+// allocation is a row of `_cat/allocation`. The values are strings, and are null for unassigned shards.
+type allocation struct {
+	Node        string  `json:"node"`
+	Shards      *string `json:"shards"`
+	DiskUsed    *string `json:"disk.used"`
+	DiskAvail   *string `json:"disk.avail"`
+	DiskPercent *string `json:"disk.percent"`
+}
+
+var watermarkSettings = map[string]string{
+	"cluster.routing.allocation.disk.watermark.low":         "watermark_low",
+	"cluster.routing.allocation.disk.watermark.high":        "watermark_high",
+	"cluster.routing.allocation.disk.watermark.flood_stage": "watermark_flood_stage",
+}
+
+var invalidNameChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// normalizeName converts an index or node name to be usable in a metric name.
+func normalizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+func getJSON(client *httpclient.Client, uri string, v any) error {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status error: %d, URI: %s", resp.StatusCode, uri)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p ElasticsearchPlugin) fetchClusterMetrics(client *httpclient.Client) (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	var health clusterHealth
+	if err := getJSON(client, p.URI+"/_cluster/health", &health); err != nil {
+		return nil, err
+	}
+	if v, ok := clusterStatuses[health.Status]; ok {
+		stat["status"] = v
+	}
+	stat["number_of_nodes"] = health.NumberOfNodes
+	stat["number_of_data_nodes"] = health.NumberOfDataNodes
+	stat["active_primary_shards"] = health.ActivePrimaryShards
+	stat["active_shards"] = health.ActiveShards
+	stat["relocating_shards"] = health.RelocatingShards
+	stat["initializing_shards"] = health.InitializingShards
+	stat["unassigned_shards"] = health.UnassignedShards
+	stat["delayed_unassigned_shards"] = health.DelayedUnassignedShards
+	stat["number_of_pending_tasks"] = health.NumberOfPendingTasks
+	stat["number_of_in_flight_fetch"] = health.NumberOfInFlightFetch
+	stat["active_shards_percent"] = health.ActiveShardsPercentAsNumber
+
+	// the others are optional; a user may not have the privileges for them
+	var s struct {
+		Indices map[string]indexStats `json:"indices"`
+	}
+	if err := getJSON(client, p.URI+"/_stats/docs,store,indexing,search", &s); err != nil {
+		logger.Warningf("Failed to fetch index stats: %s", err)
+	} else {
+		for name, idx := range s.Indices {
+			if !p.includeIndex(name) {
+				continue
+			}
+			name = normalizeName(name)
+			stat[p.Prefix+".index.docs."+name+".docs_count"] = idx.Primaries.Docs.Count
+			stat[p.Prefix+".index.store."+name+".store_size"] = idx.Total.Store.SizeInBytes
+			stat[p.Prefix+".index.indexing."+name+".indexing_index"] = idx.Total.Indexing.IndexTotal
+			stat[p.Prefix+".index.search."+name+".search_query"] = idx.Total.Search.QueryTotal
+			stat[p.Prefix+".index.search."+name+".search_fetch"] = idx.Total.Search.FetchTotal
+		}
+	}
+
+	var allocations []allocation
+	if err := getJSON(client, p.URI+"/_cat/allocation?format=json&bytes=b", &allocations); err != nil {
+		logger.Warningf("Failed to fetch shard allocation: %s", err)
+	} else {
+		for _, a := range allocations {
+			// unassigned shards are listed as a node named "UNASSIGNED" without disk usages
+			if a.DiskPercent == nil {
+				continue
+			}
+			name := normalizeName(a.Node)
+			setParsedValue(stat, p.Prefix+".allocation.shards."+name+".shards", a.Shards)
+			setParsedValue(stat, p.Prefix+".allocation.disk."+name+".disk_used", a.DiskUsed)
+			setParsedValue(stat, p.Prefix+".allocation.disk."+name+".disk_avail", a.DiskAvail)
+			setParsedValue(stat, p.Prefix+".allocation.disk_percent."+name+".disk_percent", a.DiskPercent)
+		}
+	}
+
+	var settings struct {
+		Persistent map[string]any `json:"persistent"`
+		Transient  map[string]any `json:"transient"`
+		Defaults   map[string]any `json:"defaults"`
+	}
+	if err := getJSON(client, p.URI+"/_cluster/settings?include_defaults=true&flat_settings=true", &settings); err != nil {
+		logger.Warningf("Failed to fetch cluster settings: %s", err)
+	} else {
+		for key, name := range watermarkSettings {
+			// transient settings take precedence over persistent settings, and then the defaults
+			for _, m := range []map[string]any{settings.Transient, settings.Persistent, settings.Defaults} {
+				if v, ok := m[key].(string); ok {
+					if percent, ok := parseWatermark(v); ok {
+						stat[name] = percent
+					}
+					break
+				}
+			}
+		}
+	}
+	return stat, nil
+}
+
+func (p ElasticsearchPlugin) includeIndex(name string) bool {
+	if p.IndexInclude != nil && !p.IndexInclude.MatchString(name) {
+		return false
+	}
+	if p.IndexExclude != nil && p.IndexExclude.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+func setParsedValue(stat map[string]float64, key string, s *string) {
+	if s == nil {
+		return
+	}
+	if v, err := strconv.ParseFloat(*s, 64); err == nil {
+		stat[key] = v
+	}
+}
+
+// parseWatermark parses a disk watermark setting such as "85%" or "0.85" into the percentage of used disk.
+// The watermarks given as absolute free space such as "500mb" can't be compared with the percentages, so they are ignored.
+func parseWatermark(s string) (float64, bool) {
+	if v, ok := strings.CutSuffix(s, "%"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f > 1 {
+		return 0, false
+	}
+	return f * 100, true
+}
+
+func (p ElasticsearchPlugin) clusterGraphDefinition() map[string]mp.Graphs {
+	return map[string]mp.Graphs{
+		p.Prefix + ".cluster.status": {
+			Label: (p.LabelPrefix + " Cluster Status"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "status", Label: "Status (0:green 1:yellow 2:red)"},
+			},
+		},
+		p.Prefix + ".cluster.nodes": {
+			Label: (p.LabelPrefix + " Cluster Nodes"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "number_of_nodes", Label: "Nodes"},
+				{Name: "number_of_data_nodes", Label: "Data Nodes"},
+			},
+		},
+		p.Prefix + ".cluster.shards": {
+			Label: (p.LabelPrefix + " Cluster Shards"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "active_primary_shards", Label: "Active Primary"},
+				{Name: "active_shards", Label: "Active"},
+				{Name: "relocating_shards", Label: "Relocating"},
+				{Name: "initializing_shards", Label: "Initializing"},
+				{Name: "unassigned_shards", Label: "Unassigned"},
+				{Name: "delayed_unassigned_shards", Label: "Delayed Unassigned"},
+			},
+		},
+		p.Prefix + ".cluster.active_shards_percent": {
+			Label: (p.LabelPrefix + " Cluster Active Shards"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "active_shards_percent", Label: "Active Shards"},
+			},
+		},
+		p.Prefix + ".cluster.pending_tasks": {
+			Label: (p.LabelPrefix + " Cluster Pending Tasks"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "number_of_pending_tasks", Label: "Pending Tasks"},
+				{Name: "number_of_in_flight_fetch", Label: "In-flight Fetches"},
+			},
+		},
+		p.Prefix + ".cluster.disk_watermark": {
+			Label: (p.LabelPrefix + " Cluster Disk Watermark"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "watermark_low", Label: "Low"},
+				{Name: "watermark_high", Label: "High"},
+				{Name: "watermark_flood_stage", Label: "Flood Stage"},
+			},
+		},
+		p.Prefix + ".index.docs.#": {
+			Label: (p.LabelPrefix + " Index Docs"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "docs_count", Label: "Count"},
+			},
+		},
+		p.Prefix + ".index.store.#": {
+			Label: (p.LabelPrefix + " Index Store Size"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "store_size", Label: "Size"},
+			},
+		},
+		p.Prefix + ".index.indexing.#": {
+			Label: (p.LabelPrefix + " Index Indexing"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "indexing_index", Label: "Index", Diff: true},
+			},
+		},
+		p.Prefix + ".index.search.#": {
+			Label: (p.LabelPrefix + " Index Search"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "search_query", Label: "Query", Diff: true},
+				{Name: "search_fetch", Label: "Fetch", Diff: true},
+			},
+		},
+		p.Prefix + ".allocation.shards.#": {
+			Label: (p.LabelPrefix + " Allocation Shards"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "shards", Label: "Shards"},
+			},
+		},
+		p.Prefix + ".allocation.disk.#": {
+			Label: (p.LabelPrefix + " Allocation Disk"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "disk_used", Label: "Used", Stacked: true},
+				{Name: "disk_avail", Label: "Available", Stacked: true},
+			},
+		},
+		p.Prefix + ".allocation.disk_percent.#": {
+			Label: (p.LabelPrefix + " Allocation Disk Usage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "disk_percent", Label: "Used"},
+			},
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package mpelasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClusterTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_cluster/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+  "cluster_name": "docker-cluster",
+  "status": "yellow",
+  "timed_out": false,
+  "number_of_nodes": 3,
+  "number_of_data_nodes": 2,
+  "active_primary_shards": 10,
+  "active_shards": 18,
+  "relocating_shards": 1,
+  "initializing_shards": 0,
+  "unassigned_shards": 2,
+  "delayed_unassigned_shards": 0,
+  "number_of_pending_tasks": 4,
+  "number_of_in_flight_fetch": 0,
+  "task_max_waiting_in_queue_millis": 0,
+  "active_shards_percent_as_number": 90.0
+}`)
+	})
+	mux.HandleFunc("/_stats/docs,store,indexing,search", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+  "_all": {},
+  "indices": {
+    "logs-2024.01.01": {
+      "primaries": {"docs": {"count": 100}},
+      "total": {"store": {"size_in_bytes": 2048}, "indexing": {"index_total": 200}, "search": {"query_total": 30, "fetch_total": 10}}
+    },
+    "users": {
+      "primaries": {"docs": {"count": 5}},
+      "total": {"store": {"size_in_bytes": 512}, "indexing": {"index_total": 10}, "search": {"query_total": 3, "fetch_total": 1}}
+    },
+    ".kibana_1": {
+      "primaries": {"docs": {"count": 1}},
+      "total": {"store": {"size_in_bytes": 64}}
+    }
+  }
+}`)
+	})
+	mux.HandleFunc("/_cat/allocation", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+  {"shards": "9", "disk.indices": "2560", "disk.used": "8000", "disk.avail": "2000", "disk.total": "10000", "disk.percent": "80", "host": "10.0.0.1", "ip": "10.0.0.1", "node": "es-data-1"},
+  {"shards": "2", "disk.indices": null, "disk.used": null, "disk.avail": null, "disk.total": null, "disk.percent": null, "host": null, "ip": null, "node": "UNASSIGNED"}
+]`)
+	})
+	mux.HandleFunc("/_cluster/settings", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+  "persistent": {"cluster.routing.allocation.disk.watermark.high": "0.88"},
+  "transient": {},
+  "defaults": {
+    "cluster.routing.allocation.disk.watermark.low": "85%",
+    "cluster.routing.allocation.disk.watermark.high": "90%",
+    "cluster.routing.allocation.disk.watermark.flood_stage": "500mb"
+  }
+}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestFetchClusterMetrics(t *testing.T) {
+	ts := newClusterTestServer()
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{
+		URI:          ts.URL,
+		Prefix:       "elasticsearch",
+		Mode:         "cluster",
+		IndexExclude: regexp.MustCompile(`^\.`),
+	}
+	stat, err := elasticsearch.FetchMetrics()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1, stat["status"])
+	assert.EqualValues(t, 3, stat["number_of_nodes"])
+	assert.EqualValues(t, 2, stat["unassigned_shards"])
+	assert.EqualValues(t, 4, stat["number_of_pending_tasks"])
+	assert.EqualValues(t, 90, stat["active_shards_percent"])
+
+	assert.EqualValues(t, 100, stat["elasticsearch.index.docs.logs-2024_01_01.docs_count"])
+	assert.EqualValues(t, 2048, stat["elasticsearch.index.store.logs-2024_01_01.store_size"])
+	assert.EqualValues(t, 200, stat["elasticsearch.index.indexing.logs-2024_01_01.indexing_index"])
+	assert.EqualValues(t, 3, stat["elasticsearch.index.search.users.search_query"])
+	assert.NotContains(t, stat, "elasticsearch.index.docs._kibana_1.docs_count")
+
+	assert.EqualValues(t, 9, stat["elasticsearch.allocation.shards.es-data-1.shards"])
+	assert.EqualValues(t, 8000, stat["elasticsearch.allocation.disk.es-data-1.disk_used"])
+	assert.EqualValues(t, 80, stat["elasticsearch.allocation.disk_percent.es-data-1.disk_percent"])
+	assert.NotContains(t, stat, "elasticsearch.allocation.shards.UNASSIGNED.shards")
+
+	assert.EqualValues(t, 85, stat["watermark_low"])
+	assert.EqualValues(t, 88, stat["watermark_high"])
+	assert.NotContains(t, stat, "watermark_flood_stage")
+
+	elasticsearch.IndexInclude = regexp.MustCompile(`^logs-`)
+	stat, err = elasticsearch.FetchMetrics()
+	require.NoError(t, err)
+	assert.Contains(t, stat, "elasticsearch.index.docs.logs-2024_01_01.docs_count")
+	assert.NotContains(t, stat, "elasticsearch.index.docs.users.docs_count")
+}
+
+func TestFetchClusterMetrics_HealthError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, Prefix: "elasticsearch", Mode: "cluster"}
+	_, err := elasticsearch.FetchMetrics()
+	assert.Error(t, err)
+}
+
+func TestClusterGraphDefinition(t *testing.T) {
+	elasticsearch := ElasticsearchPlugin{Prefix: "elasticsearch", LabelPrefix: "Elasticsearch", Mode: "cluster"}
+	graphdef := elasticsearch.GraphDefinition()
+	assert.EqualValues(t, "Elasticsearch Cluster Status", graphdef["elasticsearch.cluster.status"].Label)
+	assert.Contains(t, graphdef, "elasticsearch.index.docs.#")
+	assert.NotContains(t, graphdef, "elasticsearch.http")
+}
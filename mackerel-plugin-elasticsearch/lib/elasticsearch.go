@@ -1,15 +1,21 @@
 package mpelasticsearch
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"net/http"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/golib/pluginutil"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -97,28 +103,38 @@ type ElasticsearchPlugin struct {
 	URI                  string
 	Prefix               string
 	LabelPrefix          string
-	Insecure             bool
-	User                 string
-	Password             string
 	SuppressMissingError bool
+	// Mode is "node" to monitor the local node, or "cluster" to monitor the whole cluster.
+	Mode         string
+	IndexInclude *regexp.Regexp
+	IndexExclude *regexp.Regexp
+	// StateFile keeps the counters of operations to calculate the latencies.
+	StateFile string
+	// APIKey is sent as "Authorization: ApiKey" header.
+	APIKey string
+	httpclient.Options
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p ElasticsearchPlugin) FetchMetrics() (map[string]float64, error) {
-	req, err := http.NewRequest(http.MethodGet, p.URI+"/_nodes/_local/stats", nil)
+	p.UserAgent = "mackerel-plugin-elasticsearch"
+	if p.APIKey != "" {
+		p.Header = slices.Concat(p.Header, []string{apiKeyHeader(p.APIKey)})
+	}
+	client, err := p.Options.NewClient()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "mackerel-plugin-elasticsearch")
-	if p.User != "" && p.Password != "" {
-		req.SetBasicAuth(p.User, p.Password)
+	if p.Mode == "cluster" {
+		return p.fetchClusterMetrics(client)
 	}
-	client := http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: p.Insecure},
-		},
+
+	dist, err := fetchDistribution(client, p.URI)
+	if err != nil {
+		// the version is not necessary to fetch the stats; all metrics are expected to exist
+		logger.Warningf("Failed to detect the version: %s", err)
 	}
-	resp, err := client.Do(req)
+	resp, err := client.Get(p.URI + "/_nodes/_local/stats")
 	if err != nil {
 		return nil, err
 	}
@@ -144,6 +160,9 @@ func (p ElasticsearchPlugin) FetchMetrics() (map[string]float64, error) {
 	node := nodes[n].(map[string]any)
 
 	for k, v := range metricPlace {
+		if !dist.hasMetric(k) {
+			continue
+		}
 		val, err := getFloatValue(node, v)
 		if err != nil {
 			if !p.SuppressMissingError {
@@ -154,12 +173,21 @@ func (p ElasticsearchPlugin) FetchMetrics() (map[string]float64, error) {
 
 		stat[k] = val
 	}
+	p.setThreadPoolMetrics(stat, node)
+	if p.StateFile != "" {
+		if err := setLatencyMetrics(stat, node, p.StateFile, time.Now()); err != nil {
+			logger.Warningf("Failed to calculate latencies: %s", err)
+		}
+	}
 
 	return stat, nil
 }
 
 // GraphDefinition interface for mackerelplugin
 func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
+	if p.Mode == "cluster" {
+		return p.clusterGraphDefinition()
+	}
 	var graphdef = map[string]mp.Graphs{
 		p.Prefix + ".http": {
 			Label: (p.LabelPrefix + " HTTP"),
@@ -271,6 +299,7 @@ func (p ElasticsearchPlugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 	}
+	maps.Copy(graphdef, p.latencyGraphDefinition())
 
 	return graphdef
 }
@@ -283,13 +312,16 @@ func Do() {
 	optPrefix := flag.String("metric-key-prefix", "elasticsearch", "Metric key prefix")
 	optLabelPrefix := flag.String("metric-label-prefix", "", "Metric Label prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
-	optInsecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
-	optUser := flag.String("user", "", "Basic auth user")
-	optPassword := flag.String("password", "", "Basic auth password")
+	optInsecure := flag.Bool("insecure", false, "Skip TLS certificate verification (alias of -tls-skip-verify)")
 	optSuppressMissingError := flag.Bool("suppress-missing-error", false, "Suppress ERROR for missing values")
+	optAPIKey := flag.String("api-key", os.Getenv("ELASTICSEARCH_API_KEY"), "API key, either encoded or in the form of id:api_key")
+	optMode := flag.String("mode", "node", "Monitoring mode: node (stats of the local node) or cluster (cluster health, indices and shard allocation)")
+	optIndexInclude := flag.String("index-include", "", "Regexp of index names to collect in the cluster mode")
+	optIndexExclude := flag.String("index-exclude", `^\.`, "Regexp of index names not to collect in the cluster mode")
+	var elasticsearch ElasticsearchPlugin
+	elasticsearch.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	var elasticsearch ElasticsearchPlugin
 	elasticsearch.URI = fmt.Sprintf("%s://%s:%s", *optScheme, *optHost, *optPort)
 	elasticsearch.Prefix = *optPrefix
 	if *optLabelPrefix == "" {
@@ -297,17 +329,53 @@ func Do() {
 	} else {
 		elasticsearch.LabelPrefix = *optLabelPrefix
 	}
-	elasticsearch.Insecure = *optInsecure
-	elasticsearch.User = *optUser
-	elasticsearch.Password = *optPassword
+	if *optInsecure {
+		elasticsearch.TLSSkipVerify = true
+	}
+	// basic authentication is sent only with both of the user and the password, as before the common HTTP options
+	if elasticsearch.User == "" || elasticsearch.Password == "" {
+		elasticsearch.User, elasticsearch.Password = "", ""
+	}
 	elasticsearch.SuppressMissingError = *optSuppressMissingError
+	elasticsearch.APIKey = *optAPIKey
+	switch *optMode {
+	case "node", "cluster":
+		elasticsearch.Mode = *optMode
+	default:
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-elasticsearch: invalid mode: %s\n", *optMode)
+		os.Exit(1)
+	}
+	elasticsearch.IndexInclude = compileIndexPattern(*optIndexInclude)
+	elasticsearch.IndexExclude = compileIndexPattern(*optIndexExclude)
+
+	if *optTempfile != "" {
+		elasticsearch.StateFile = *optTempfile + ".latency"
+	} else {
+		elasticsearch.StateFile = filepath.Join(pluginutil.PluginWorkDir(), fmt.Sprintf("mackerel-plugin-elasticsearch-latency-%s-%s", *optHost, *optPort))
+	}
 
 	helper := mp.NewMackerelPlugin(elasticsearch)
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-elasticsearch-%s-%s", *optHost, *optPort))
+		name := "mackerel-plugin-elasticsearch"
+		if elasticsearch.Mode == "cluster" {
+			name += "-cluster"
+		}
+		helper.SetTempfileByBasename(fmt.Sprintf("%s-%s-%s", name, *optHost, *optPort))
 	}
 
 	helper.Run()
 }
+
+func compileIndexPattern(s string) *regexp.Regexp {
+	if s == "" {
+		return nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-elasticsearch: invalid index pattern: %s\n", err)
+		os.Exit(1)
+	}
+	return re
+}
@@ -0,0 +1,157 @@
+package mpelasticsearch
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+// latencyPlace maps the latency metrics to the places of the total count and the total time of each operation.
+var latencyPlace = map[string]struct {
+	count []string
+	time  []string
+}{
+	"latency_search_query":    {[]string{"indices", "search", "query_total"}, []string{"indices", "search", "query_time_in_millis"}},
+	"latency_search_fetch":    {[]string{"indices", "search", "fetch_total"}, []string{"indices", "search", "fetch_time_in_millis"}},
+	"latency_indexing_index":  {[]string{"indices", "indexing", "index_total"}, []string{"indices", "indexing", "index_time_in_millis"}},
+	"latency_indexing_delete": {[]string{"indices", "indexing", "delete_total"}, []string{"indices", "indexing", "delete_time_in_millis"}},
+	"latency_get":             {[]string{"indices", "get", "total"}, []string{"indices", "get", "time_in_millis"}},
+	"latency_merges":          {[]string{"indices", "merges", "total"}, []string{"indices", "merges", "total_time_in_millis"}},
+	"latency_refresh":         {[]string{"indices", "refresh", "total"}, []string{"indices", "refresh", "total_time_in_millis"}},
+	"latency_flush":           {[]string{"indices", "flush", "total"}, []string{"indices", "flush", "total_time_in_millis"}},
+}
+
+func (p ElasticsearchPlugin) latencyGraphDefinition() map[string]mp.Graphs {
+	return map[string]mp.Graphs{
+		p.Prefix + ".latency": {
+			Label: (p.LabelPrefix + " Latency (ms)"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "latency_search_query", Label: "Search-Query"},
+				{Name: "latency_search_fetch", Label: "Search-Fetch"},
+				{Name: "latency_indexing_index", Label: "Indexing-Index"},
+				{Name: "latency_indexing_delete", Label: "Indexing-Delete"},
+				{Name: "latency_get", Label: "Get"},
+				{Name: "latency_merges", Label: "Merges"},
+				{Name: "latency_refresh", Label: "Refresh"},
+				{Name: "latency_flush", Label: "Flush"},
+			},
+		},
+		p.Prefix + ".thread_pool.queue.#": {
+			Label: (p.LabelPrefix + " Thread-Pool Queue"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "queue", Label: "Queue"},
+			},
+		},
+		p.Prefix + ".thread_pool.rejected.#": {
+			Label: (p.LabelPrefix + " Thread-Pool Rejected"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "rejected", Label: "Rejected", Diff: true},
+			},
+		},
+	}
+}
+
+// setThreadPoolMetrics adds the queue and the rejected count of every thread pool in the node stats.
+func (p ElasticsearchPlugin) setThreadPoolMetrics(stat map[string]float64, node map[string]any) {
+	pools, ok := node["thread_pool"].(map[string]any)
+	if !ok {
+		return
+	}
+	for name, v := range pools {
+		pool, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		name = normalizeName(name)
+		if queue, ok := pool["queue"].(float64); ok {
+			stat[p.Prefix+".thread_pool.queue."+name+".queue"] = queue
+		}
+		if rejected, ok := pool["rejected"].(float64); ok {
+			stat[p.Prefix+".thread_pool.rejected."+name+".rejected"] = rejected
+		}
+	}
+}
+
+type operationCounter struct {
+	Count float64 `json:"count"`
+	Time  float64 `json:"time"`
+}
+
+type latencyState struct {
+	LastTime time.Time                   `json:"last_time"`
+	Counters map[string]operationCounter `json:"counters"`
+}
+
+func loadLatencyState(path string) (*latencyState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var s latencyState
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func saveLatencyState(path string, s latencyState) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(s)
+}
+
+// setLatencyMetrics adds the average latency of each operation since the previous run,
+// which is the difference of the total time divided by the difference of the total count.
+// Operations which have not been performed since the previous run are not posted.
+func setLatencyMetrics(stat map[string]float64, node map[string]any, stateFile string, now time.Time) error {
+	counters := make(map[string]operationCounter, len(latencyPlace))
+	for k, v := range latencyPlace {
+		count, err := getFloatValue(node, v.count)
+		if err != nil {
+			continue
+		}
+		t, err := getFloatValue(node, v.time)
+		if err != nil {
+			continue
+		}
+		counters[k] = operationCounter{Count: count, Time: t}
+	}
+
+	last, err := loadLatencyState(stateFile)
+	if err != nil {
+		// the state file is broken; overwrite it with the current counters
+		logger.Warningf("loadLatencyState (ignore): %s", err)
+	}
+	if err := saveLatencyState(stateFile, latencyState{LastTime: now, Counters: counters}); err != nil {
+		return err
+	}
+	if last == nil || now.Sub(last.LastTime) > 10*time.Minute {
+		return nil
+	}
+
+	for k, c := range counters {
+		lc, ok := last.Counters[k]
+		if !ok {
+			continue
+		}
+		// the counters are reset when the node is restarted
+		if c.Count <= lc.Count || c.Time < lc.Time {
+			continue
+		}
+		stat[k] = (c.Time - lc.Time) / (c.Count - lc.Count)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package mpelasticsearch
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchMetrics_ThreadPool(t *testing.T) {
+	ts := httptest.NewServer(testHandler)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, Prefix: "elasticsearch"}
+	stat, err := elasticsearch.FetchMetrics()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 0, stat["elasticsearch.thread_pool.queue.search.queue"])
+	assert.EqualValues(t, 0, stat["elasticsearch.thread_pool.rejected.write.rejected"])
+	assert.Contains(t, stat, "elasticsearch.thread_pool.queue.security-crypto.queue")
+	assert.Contains(t, stat, "elasticsearch.thread_pool.rejected.searchable_snapshots_cache_fetch_async.rejected")
+}
+
+func TestSetLatencyMetrics(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "latency")
+	now := time.Now()
+	node := func(queryTotal, queryTime, getTotal, getTime float64) map[string]any {
+		return map[string]any{
+			"indices": map[string]any{
+				"search": map[string]any{"query_total": queryTotal, "query_time_in_millis": queryTime},
+				"get":    map[string]any{"total": getTotal, "time_in_millis": getTime},
+			},
+		}
+	}
+
+	// the first run only saves the counters
+	stat := make(map[string]float64)
+	require.NoError(t, setLatencyMetrics(stat, node(100, 2000, 10, 10), stateFile, now.Add(-time.Minute)))
+	assert.Empty(t, stat)
+
+	stat = make(map[string]float64)
+	require.NoError(t, setLatencyMetrics(stat, node(150, 3500, 10, 10), stateFile, now))
+	assert.EqualValues(t, 30, stat["latency_search_query"])
+	assert.NotContains(t, stat, "latency_get", "no get operations since the previous run")
+
+	// the node is restarted
+	stat = make(map[string]float64)
+	require.NoError(t, setLatencyMetrics(stat, node(5, 100, 1, 1), stateFile, now.Add(time.Minute)))
+	assert.Empty(t, stat)
+}
+
+func TestGraphDefinition_Latency(t *testing.T) {
+	elasticsearch := ElasticsearchPlugin{Prefix: "elasticsearch", LabelPrefix: "Elasticsearch"}
+	graphdef := elasticsearch.GraphDefinition()
+	assert.EqualValues(t, "Elasticsearch Latency (ms)", graphdef["elasticsearch.latency"].Label)
+	assert.Contains(t, graphdef, "elasticsearch.thread_pool.queue.#")
+	assert.Contains(t, graphdef, "elasticsearch.thread_pool.rejected.#")
+}
@@ -0,0 +1,85 @@
+package mpelasticsearch
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
+)
+
+// removedMetrics maps the metrics to the major version of Elasticsearch which removed them.
+var removedMetrics = map[string]int{
+	"total_percolate":        7,
+	"total_suggest":          7,
+	"filter_cache_size":      7,
+	"evictions_filter_cache": 7,
+	"threads_index":          7,
+	"threads_snapshot_data":  7,
+	"threads_bench":          7,
+	"threads_merge":          7,
+	"threads_suggest":        7,
+	"threads_bulk":           7,
+	"threads_optimize":       7,
+	"threads_percolate":      7,
+	"threads_listener":       8,
+}
+
+// distribution is the distribution and the version of the server, detected from `/`.
+type distribution struct {
+	Name    string // "elasticsearch" or "opensearch"
+	Version string
+	Major   int
+}
+
+func fetchDistribution(client *httpclient.Client, uri string) (*distribution, error) {
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := getJSON(client, uri+"/", &info); err != nil {
+		return nil, err
+	}
+	major, _, _ := strings.Cut(info.Version.Number, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return nil, fmt.Errorf("unknown version: %q", info.Version.Number)
+	}
+	d := &distribution{Name: "elasticsearch", Version: info.Version.Number, Major: n}
+	// only OpenSearch has the distribution field
+	if info.Version.Distribution == "opensearch" {
+		d.Name = "opensearch"
+	}
+	return d, nil
+}
+
+// compatibleMajor returns the major version of Elasticsearch whose node stats the distribution is compatible with.
+// OpenSearch is forked from Elasticsearch 7.10.
+func (d *distribution) compatibleMajor() int {
+	if d.Name == "opensearch" {
+		return 7
+	}
+	return d.Major
+}
+
+// hasMetric reports whether the node stats of the distribution contain the metric.
+// It reports true for any metric if the distribution is unknown.
+func (d *distribution) hasMetric(metric string) bool {
+	if d == nil {
+		return true
+	}
+	v, ok := removedMetrics[metric]
+	return !ok || d.compatibleMajor() < v
+}
+
+// apiKeyHeader returns the Authorization header of the API key.
+// The key is either the encoded key, or "id:api_key" which is encoded here.
+func apiKeyHeader(key string) string {
+	if strings.Contains(key, ":") {
+		key = base64.StdEncoding.EncodeToString([]byte(key))
+	}
+	return "Authorization: ApiKey " + key
+}
@@ -0,0 +1,94 @@
+package mpelasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchDistribution(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *distribution
+	}{
+		{
+			name: "elasticsearch",
+			body: `{"name":"es01","cluster_name":"docker-cluster","version":{"number":"8.5.0","build_flavor":"default","lucene_version":"9.4.1"},"tagline":"You Know, for Search"}`,
+			want: &distribution{Name: "elasticsearch", Version: "8.5.0", Major: 8},
+		},
+		{
+			name: "opensearch",
+			body: `{"name":"os01","cluster_name":"opensearch-cluster","version":{"distribution":"opensearch","number":"2.11.0","lucene_version":"9.7.0"},"tagline":"The OpenSearch Project: https://opensearch.org/"}`,
+			want: &distribution{Name: "opensearch", Version: "2.11.0", Major: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			}))
+			defer ts.Close()
+
+			client, err := httpclient.Options{}.NewClient()
+			require.NoError(t, err)
+			d, err := fetchDistribution(client, ts.URL)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, d)
+		})
+	}
+}
+
+func TestDistribution_HasMetric(t *testing.T) {
+	var unknown *distribution
+	assert.True(t, unknown.hasMetric("total_percolate"))
+
+	es6 := &distribution{Name: "elasticsearch", Major: 6}
+	assert.True(t, es6.hasMetric("total_percolate"))
+	assert.True(t, es6.hasMetric("threads_listener"))
+
+	es7 := &distribution{Name: "elasticsearch", Major: 7}
+	assert.False(t, es7.hasMetric("threads_bulk"))
+	assert.True(t, es7.hasMetric("threads_listener"))
+	assert.True(t, es7.hasMetric("heap_used"))
+
+	es8 := &distribution{Name: "elasticsearch", Major: 8}
+	assert.False(t, es8.hasMetric("threads_listener"))
+
+	opensearch := &distribution{Name: "opensearch", Major: 2}
+	assert.False(t, opensearch.hasMetric("threads_bulk"))
+	assert.True(t, opensearch.hasMetric("threads_listener"))
+}
+
+func TestFetchMetrics_APIKey(t *testing.T) {
+	stats, err := os.ReadFile("./stat.json")
+	require.NoError(t, err)
+	var auth string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"version":{"number":"8.5.0"}}`)
+	})
+	mux.HandleFunc("/_nodes/_local/stats", func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write(stats)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	elasticsearch := ElasticsearchPlugin{URI: ts.URL, Prefix: "elasticsearch", APIKey: "VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw=="}
+	stat, err := elasticsearch.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "ApiKey VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw==", auth)
+	assert.EqualValues(t, 37, stat["http_opened"])
+
+	elasticsearch.APIKey = "VuaCfGcBCdbkQm-e5aOx:ui2lp2axTNmsyakw9tvNnw"
+	_, err = elasticsearch.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "ApiKey VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw==", auth)
+}
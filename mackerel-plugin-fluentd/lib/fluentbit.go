@@ -0,0 +1,138 @@
+package mpfluentd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/promtext"
+)
+
+// fluentBitMetrics are the metrics of each category of fluent-bit's plugins,
+// which are the keys of /api/v1/metrics and the names of /api/v2/metrics without "fluentbit_<category>_" and "_total".
+var fluentBitMetrics = map[string][]string{
+	"input":  {"records", "bytes"},
+	"filter": {"drop_records", "add_records"},
+	"output": {"proc_records", "proc_bytes", "errors", "retries", "retries_failed", "dropped_records", "retried_records"},
+}
+
+var fluentBitLabels = map[string]string{
+	"input.records":          "input records",
+	"input.bytes":            "input bytes",
+	"filter.drop_records":    "filter dropped records",
+	"filter.add_records":     "filter added records",
+	"output.proc_records":    "output processed records",
+	"output.proc_bytes":      "output processed bytes",
+	"output.errors":          "output errors",
+	"output.retries":         "output retries",
+	"output.retries_failed":  "output failed retries",
+	"output.dropped_records": "output dropped records",
+	"output.retried_records": "output retried records",
+}
+
+func (f FluentdPlugin) fluentBitGraphDefinition(labelPrefix string) map[string]mp.Graphs {
+	graphs := make(map[string]mp.Graphs)
+	for _, category := range f.categories() {
+		for _, name := range fluentBitMetrics[category] {
+			key := metricName(category, name)
+			unit := "integer"
+			if strings.HasSuffix(name, "bytes") {
+				unit = "bytes"
+			}
+			graphs[key] = mp.Graphs{
+				Label: (labelPrefix + " " + fluentBitLabels[key]),
+				Unit:  unit,
+				Metrics: []mp.Metrics{
+					{Name: "*", Label: "%1", Diff: true},
+				},
+			}
+		}
+	}
+	return graphs
+}
+
+func (f *FluentdPlugin) fetchFluentBitMetrics(host string, port int) (map[string]any, error) {
+	if f.FluentBitAPI == "v2" {
+		body, err := f.fetchBody(fmt.Sprintf("http://%s:%d/api/v2/metrics/prometheus", host, port))
+		if err != nil {
+			return nil, err
+		}
+		return f.parseFluentBitPrometheus(body)
+	}
+	body, err := f.fetchBody(fmt.Sprintf("http://%s:%d/api/v1/metrics", host, port))
+	if err != nil {
+		return nil, err
+	}
+	return f.parseFluentBitStats(body)
+}
+
+// setFluentBitMetric adds the metric of the plugin named by its alias, or the plugin name and the index such as "cpu.0".
+func (f *FluentdPlugin) setFluentBitMetric(metrics map[string]any, category, plugin, name string, value float64) {
+	if !slices.Contains(fluentBitMetrics[category], name) {
+		return
+	}
+	p := FluentdPluginMetrics{PluginCategory: category, PluginID: plugin}
+	if f.nonTargetPlugin(p) {
+		return
+	}
+	metrics[metricName(category, name, p.getNormalizedPluginID())] = value
+}
+
+// parseFluentBitStats parses the response of /api/v1/metrics, such as
+// {"input": {"cpu.0": {"records": 8, "bytes": 2536}}, "output": {"stdout.0": {"proc_records": 5, ...}}}
+func (f *FluentdPlugin) parseFluentBitStats(body []byte) (map[string]any, error) {
+	var j map[string]map[string]map[string]float64
+	if err := json.Unmarshal(body, &j); err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]any)
+	for category, plugins := range j {
+		for plugin, values := range plugins {
+			for name, v := range values {
+				f.setFluentBitMetric(metrics, category, plugin, name, v)
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// parseFluentBitPrometheus parses the response of /api/v2/metrics/prometheus, such as
+// fluentbit_input_records_total{name="cpu.0"} 8
+func (f *FluentdPlugin) parseFluentBitPrometheus(body []byte) (map[string]any, error) {
+	metrics := make(map[string]any)
+	s := bufio.NewScanner(bytes.NewReader(body))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, v, err := promtext.ParseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := strings.CutPrefix(name, "fluentbit_")
+		if !ok {
+			continue
+		}
+		category, name, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+		name, ok = strings.CutSuffix(name, "_total")
+		if !ok || labels["name"] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		f.setFluentBitMetric(metrics, category, labels["name"], name, value)
+	}
+	return metrics, s.Err()
+}
@@ -0,0 +1,114 @@
+package mpfluentd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fluentBitV1Stub = `{
+  "input": {
+    "cpu.0": {"records": 8, "bytes": 2536},
+    "tail_app": {"records": 120, "bytes": 40960}
+  },
+  "filter": {
+    "grep.0": {"drop_records": 3, "add_records": 0}
+  },
+  "output": {
+    "stdout.0": {"proc_records": 5, "proc_bytes": 1590, "errors": 0, "retries": 1, "retries_failed": 0, "dropped_records": 0, "retried_records": 2}
+  }
+}`
+
+const fluentBitV2Stub = `# HELP fluentbit_uptime Number of seconds that Fluent Bit has been running.
+# TYPE fluentbit_uptime counter
+fluentbit_uptime{hostname="localhost"} 10
+# HELP fluentbit_input_records_total Number of input records.
+# TYPE fluentbit_input_records_total counter
+fluentbit_input_records_total{name="cpu.0"} 8 1700000000000
+fluentbit_input_records_total{name="tail_app"} 120 1700000000000
+fluentbit_input_bytes_total{name="cpu.0"} 2536 1700000000000
+fluentbit_input_bytes_total{name="tail_app"} 40960 1700000000000
+fluentbit_filter_drop_records_total{name="grep.0"} 3
+fluentbit_filter_add_records_total{name="grep.0"} 0
+fluentbit_output_proc_records_total{name="stdout.0"} 5
+fluentbit_output_proc_bytes_total{name="stdout.0"} 1590
+fluentbit_output_errors_total{name="stdout.0"} 0
+fluentbit_output_retries_total{name="stdout.0"} 1
+fluentbit_output_retries_failed_total{name="stdout.0"} 0
+fluentbit_output_dropped_records_total{name="stdout.0"} 0
+fluentbit_output_retried_records_total{name="stdout.0"} 2
+fluentbit_output_upstream_total_connections{name="stdout.0"} 0
+fluentbit_input_storage_chunks{name="cpu.0"} 1
+`
+
+func TestParseFluentBit(t *testing.T) {
+	all := []string{"input", "filter", "output"}
+	want := map[string]any{
+		"input.records.cpu_0":             float64(8),
+		"input.bytes.cpu_0":               float64(2536),
+		"input.records.tail_app":          float64(120),
+		"input.bytes.tail_app":            float64(40960),
+		"filter.drop_records.grep_0":      float64(3),
+		"filter.add_records.grep_0":       float64(0),
+		"output.proc_records.stdout_0":    float64(5),
+		"output.proc_bytes.stdout_0":      float64(1590),
+		"output.errors.stdout_0":          float64(0),
+		"output.retries.stdout_0":         float64(1),
+		"output.retries_failed.stdout_0":  float64(0),
+		"output.dropped_records.stdout_0": float64(0),
+		"output.retried_records.stdout_0": float64(2),
+	}
+
+	fluentd := FluentdPlugin{FluentBit: true, pluginCategories: all}
+	stat, err := fluentd.parseFluentBitStats([]byte(fluentBitV1Stub))
+	require.NoError(t, err)
+	assert.Equal(t, want, stat)
+
+	stat, err = fluentd.parseFluentBitPrometheus([]byte(fluentBitV2Stub))
+	require.NoError(t, err)
+	assert.Equal(t, want, stat)
+
+	// only output plugins by default
+	fluentd = FluentdPlugin{FluentBit: true}
+	stat, err = fluentd.parseFluentBitStats([]byte(fluentBitV1Stub))
+	require.NoError(t, err)
+	assert.Len(t, stat, 7)
+
+	fluentd = FluentdPlugin{FluentBit: true, pluginCategories: all, pluginIDPattern: regexp.MustCompile(`^tail`)}
+	stat, err = fluentd.parseFluentBitPrometheus([]byte(fluentBitV2Stub))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"input.records.tail_app": float64(120),
+		"input.bytes.tail_app":   float64(40960),
+	}, stat)
+}
+
+func TestFetchMetricsFluentBit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fluentBitV1Stub)
+	})
+	mux.HandleFunc("/api/v2/metrics/prometheus", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fluentBitV2Stub)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	for _, api := range []string{"v1", "v2"} {
+		fluentd := FluentdPlugin{Host: u.Hostname(), Port: u.Port(), FluentBit: true, FluentBitAPI: api}
+		stat, err := fluentd.FetchMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, float64(5), stat["output.proc_records.stdout_0"], api)
+	}
+	graphdef := FluentdPlugin{FluentBit: true, pluginCategories: []string{"input", "output"}}.GraphDefinition()
+	assert.Len(t, graphdef, 9)
+	assert.Equal(t, "bytes", graphdef["input.bytes"].Unit)
+}
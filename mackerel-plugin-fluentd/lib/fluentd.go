@@ -6,11 +6,14 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"golang.org/x/text/cases"
@@ -23,14 +26,27 @@ func metricName(names ...string) string {
 
 // FluentdPlugin mackerel plugin for Fluentd
 type FluentdPlugin struct {
-	Host            string
-	Port            string
-	Prefix          string
-	Tempfile        string
-	pluginType      string
-	pluginIDPattern *regexp.Regexp
-	extendedMetrics []string
-	Workers         uint
+	Host       string
+	Port       string
+	Prefix     string
+	Tempfile   string
+	pluginType string
+	// pluginCategories are the categories of plugins to collect; only "output" if empty.
+	pluginCategories []string
+	pluginIDPattern  *regexp.Regexp
+	extendedMetrics  []string
+	Workers          uint
+	// AutoWorkers is true to detect the number of workers from ConfigFile, or by probing the consecutive ports.
+	AutoWorkers bool
+	// ConfigFile is the fluentd's config file to read the workers directive.
+	ConfigFile string
+	// StateFile keeps the workers found by probing the ports with AutoWorkers.
+	StateFile string
+	Timeout   time.Duration
+	// FluentBit is true to monitor fluent-bit instead of fluentd.
+	FluentBit bool
+	// FluentBitAPI is the version of the fluent-bit's monitoring API, "v1" or "v2".
+	FluentBitAPI string
 
 	plugins []FluentdPluginMetrics
 }
@@ -61,6 +77,7 @@ type FluentdPluginMetrics struct {
 	// https://www.fluentd.org/blog/fluentd-v1.6.0-has-been-released
 	EmitRecords                      uint64  `json:"emit_records"`
 	EmitCount                        uint64  `json:"emit_count"`
+	EmitSize                         uint64  `json:"emit_size"`
 	WriteCount                       uint64  `json:"write_count"`
 	RollbackCount                    uint64  `json:"rollback_count"`
 	SlowFlushCount                   uint64  `json:"slow_flush_count"`
@@ -69,6 +86,8 @@ type FluentdPluginMetrics struct {
 	BufferStageByteSize              uint64  `json:"buffer_stage_byte_size"`
 	BufferQueueByteSize              uint64  `json:"buffer_queue_byte_size"`
 	BufferAvailableBufferSpaceRatios float64 `json:"buffer_available_buffer_space_ratios"`
+
+	Config map[string]any `json:"config"`
 }
 
 func (fpm FluentdPluginMetrics) getExtended(name string) float64 {
@@ -126,6 +145,19 @@ func (f *FluentdPlugin) parseStats(body []byte) (map[string]any, error) {
 			continue
 		}
 		pid := p.getNormalizedPluginID()
+		switch p.PluginCategory {
+		case "input":
+			metrics[metricName("input", "emit_records", pid)] = float64(p.EmitRecords)
+			metrics[metricName("input", "emit_size", pid)] = float64(p.EmitSize)
+			if p.Type == "tail" {
+				setTailPositions(metrics, p)
+			}
+			continue
+		case "filter":
+			metrics[metricName("filter", "emit_records", pid)] = float64(p.EmitRecords)
+			metrics[metricName("filter", "emit_size", pid)] = float64(p.EmitSize)
+			continue
+		}
 		metrics[metricName("retry_count", pid)] = float64(p.RetryCount)
 		metrics[metricName("buffer_queue_length", pid)] = float64(p.BufferQueueLength)
 		metrics[metricName("buffer_total_queued_size", pid)] = float64(p.BufferTotalQueuedSize)
@@ -137,7 +169,7 @@ func (f *FluentdPlugin) parseStats(body []byte) (map[string]any, error) {
 }
 
 func (f *FluentdPlugin) nonTargetPlugin(plugin FluentdPluginMetrics) bool {
-	if plugin.PluginCategory != "output" {
+	if !slices.Contains(f.categories(), plugin.PluginCategory) {
 		return true
 	}
 	if f.pluginType != "" && f.pluginType != plugin.Type {
@@ -149,24 +181,37 @@ func (f *FluentdPlugin) nonTargetPlugin(plugin FluentdPluginMetrics) bool {
 	return false
 }
 
-func (f *FluentdPlugin) fetchFluentdMetrics(host string, port int) (map[string]any, error) {
-	target := fmt.Sprintf("http://%s:%d/api/plugins.json", host, port)
+func (f *FluentdPlugin) categories() []string {
+	if len(f.pluginCategories) == 0 {
+		return []string{"output"}
+	}
+	return f.pluginCategories
+}
+
+func (f *FluentdPlugin) fetchBody(target string) ([]byte, error) {
 	req, err := http.NewRequest(http.MethodGet, target, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "mackerel-plugin-fluentd")
 
-	resp, err := http.DefaultClient.Do(req)
+	client := &http.Client{Timeout: f.Timeout}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status error: %d, URL: %s", resp.StatusCode, target)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (f *FluentdPlugin) fetchFluentdMetrics(host string, port int) (map[string]any, error) {
+	body, err := f.fetchBody(fmt.Sprintf("http://%s:%d/api/plugins.json", host, port))
 	if err != nil {
 		return nil, err
 	}
-
 	return f.parseStats(body)
 }
 
@@ -176,30 +221,57 @@ func (f FluentdPlugin) FetchMetrics() (map[string]any, error) {
 	if err != nil {
 		return nil, err
 	}
-	if f.Workers > 1 {
-		metrics := make(map[string]any)
-		for workerNumber := 0; workerNumber < int(f.Workers); workerNumber++ {
-			m, e := f.fetchFluentdMetrics(f.Host, port+workerNumber)
-			if e != nil {
-				continue
-			}
+	if f.FluentBit {
+		return f.fetchFluentBitMetrics(f.Host, port)
+	}
+	if !f.multiWorkers() {
+		return f.fetchFluentdMetrics(f.Host, port)
+	}
 
-			workerName := fmt.Sprintf("worker%d", workerNumber)
-			for k, v := range m {
-				ks := strings.Split(k, ".")
-				ks, last := ks[:len(ks)-1], ks[len(ks)-1]
-				ks = append(ks, workerName)
-				ks = append(ks, last)
-				metrics[strings.Join(ks, ".")] = v
+	var results []map[string]any
+	if f.AutoWorkers && f.ConfigFile == "" {
+		results = f.probeWorkers(port, time.Now())
+	} else {
+		workers := f.Workers
+		if f.AutoWorkers {
+			workers, err = readWorkersConfig(f.ConfigFile)
+			if err != nil {
+				return nil, err
 			}
 		}
-		if len(metrics) == 0 {
-			err := fmt.Errorf("failed to connect to fluentd's monitor_agent")
-			return metrics, err
+		var errs []error
+		results, errs = f.fetchWorkers(port, workers)
+		for i, err := range errs {
+			if err != nil {
+				log.Printf("failed to fetch metrics of worker%d: %s", i, err)
+			}
 		}
-		return metrics, nil
 	}
-	return f.fetchFluentdMetrics(f.Host, port)
+
+	metrics := make(map[string]any)
+	var up, down float64
+	for workerNumber, m := range results {
+		if m == nil {
+			down++
+			continue
+		}
+		up++
+		workerName := fmt.Sprintf("worker%d", workerNumber)
+		for k, v := range m {
+			ks := strings.Split(k, ".")
+			ks, last := ks[:len(ks)-1], ks[len(ks)-1]
+			ks = append(ks, workerName)
+			ks = append(ks, last)
+			metrics[strings.Join(ks, ".")] = v
+		}
+	}
+	if up == 0 {
+		err := fmt.Errorf("failed to connect to fluentd's monitor_agent")
+		return metrics, err
+	}
+	metrics["workers_up"] = up
+	metrics["workers_down"] = down
+	return metrics, nil
 }
 
 var defaultGraphs = map[string]mp.Graphs{
@@ -299,12 +371,90 @@ var extendedGraphs = map[string]mp.Graphs{
 	},
 }
 
+// categoryGraphs are the graphs of the input and filter plugins.
+var categoryGraphs = map[string]map[string]mp.Graphs{
+	"input": {
+		"input.emit_records": {
+			Label: "input emitted records",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+		"input.emit_size": {
+			Label: "input emitted bytes",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+		"input.tail_position": {
+			Label: "in_tail read position",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: false},
+			},
+		},
+		"input.tail_unread_bytes": {
+			Label: "in_tail unread bytes",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: false},
+			},
+		},
+	},
+	"filter": {
+		"filter.emit_records": {
+			Label: "filter emitted records",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+		"filter.emit_size": {
+			Label: "filter emitted bytes",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+	},
+}
+
 // GraphDefinition interface for mackerelplugin
 func (f FluentdPlugin) GraphDefinition() map[string]mp.Graphs {
 	labelPrefix := cases.Title(language.Und, cases.NoLower).String(f.Prefix)
+	if f.FluentBit {
+		return f.fluentBitGraphDefinition(labelPrefix)
+	}
 	graphs := make(map[string]mp.Graphs, len(defaultGraphs))
+	if f.multiWorkers() {
+		graphs["workers"] = mp.Graphs{
+			Label: (labelPrefix + " workers"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "workers_up", Label: "Up", Stacked: true},
+				{Name: "workers_down", Label: "Down", Stacked: true},
+			},
+		}
+	}
+	for _, category := range f.categories() {
+		for key, g := range categoryGraphs[category] {
+			if f.multiWorkers() {
+				key = metricName(key, "#")
+			}
+			graphs[key] = mp.Graphs{
+				Label:   (labelPrefix + " " + g.Label),
+				Unit:    g.Unit,
+				Metrics: g.Metrics,
+			}
+		}
+	}
+	if !slices.Contains(f.categories(), "output") {
+		return graphs
+	}
 	for key, g := range defaultGraphs {
-		if f.Workers > 1 {
+		if f.multiWorkers() {
 			key = metricName(key, "#")
 		}
 		graphs[key] = mp.Graphs{
@@ -315,7 +465,7 @@ func (f FluentdPlugin) GraphDefinition() map[string]mp.Graphs {
 	}
 	for _, name := range f.extendedMetrics {
 		if g, ok := extendedGraphs[name]; ok {
-			if f.Workers > 1 {
+			if f.multiWorkers() {
 				name = metricName(name, "#")
 			}
 			graphs[name] = mp.Graphs{
@@ -333,13 +483,53 @@ func Do() {
 	host := flag.String("host", "localhost", "fluentd monitor_agent host")
 	port := flag.String("port", "24220", "fluentd monitor_agent port")
 	pluginType := flag.String("plugin-type", "", "Gets the metric that matches this plugin type")
+	pluginCategory := flag.String("plugin-category", "output", "Plugin categories to get the metrics of, joined with ',' (input, filter, output)")
 	pluginIDPatternString := flag.String("plugin-id-pattern", "", "Gets the metric that matches this plugin id pattern")
 	prefix := flag.String("metric-key-prefix", "fluentd", "Metric key prefix")
 	tempFile := flag.String("tempfile", "", "Temp file name")
 	extendedMetricNames := flag.String("extended_metrics", "", "extended metric names joind with ',' or 'all' (fluentd >= v1.6.0)")
-	workers := flag.Uint("workers", 1, "specifying the number of Fluentd's multi-process workers")
+	workers := flag.String("workers", "1", "specifying the number of Fluentd's multi-process workers, or 'auto' to detect it")
+	configFile := flag.String("fluentd-config", "", "Fluentd's config file to read the number of workers with -workers=auto")
+	timeout := flag.Duration("timeout", 5*time.Second, "Timeout of each request to monitor_agent")
+	fluentBit := flag.Bool("fluent-bit", false, "Monitor fluent-bit via its HTTP server instead of fluentd's monitor_agent")
+	fluentBitAPI := flag.String("fluent-bit-api", "v1", "fluent-bit's monitoring API version: v1 (/api/v1/metrics) or v2 (/api/v2/metrics/prometheus)")
 	flag.Parse()
 
+	var numWorkers uint64 = 1
+	autoWorkers := *workers == "auto"
+	if !autoWorkers {
+		var err error
+		numWorkers, err = strconv.ParseUint(*workers, 10, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-fluentd: invalid workers: %s\n", *workers)
+			os.Exit(1)
+		}
+	}
+
+	if *fluentBit {
+		if autoWorkers || numWorkers > 1 {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-fluentd: -workers is not supported with -fluent-bit\n")
+			os.Exit(1)
+		}
+		if *pluginType != "" {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-fluentd: -plugin-type is not supported with -fluent-bit\n")
+			os.Exit(1)
+		}
+		if *fluentBitAPI != "v1" && *fluentBitAPI != "v2" {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-fluentd: invalid fluent-bit-api: %s\n", *fluentBitAPI)
+			os.Exit(1)
+		}
+		// the defaults for fluent-bit's HTTP server unless specified
+		passed := make(map[string]bool)
+		flag.Visit(func(fl *flag.Flag) { passed[fl.Name] = true })
+		if !passed["port"] {
+			*port = "2020"
+		}
+		if !passed["metric-key-prefix"] {
+			*prefix = "fluentbit"
+		}
+	}
+
 	var pluginIDPattern *regexp.Regexp
 	var err error
 	if *pluginIDPatternString != "" {
@@ -350,6 +540,17 @@ func Do() {
 		}
 	}
 
+	var pluginCategories []string
+	for category := range strings.SplitSeq(*pluginCategory, ",") {
+		switch category {
+		case "input", "filter", "output":
+			pluginCategories = append(pluginCategories, category)
+		default:
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-fluentd: invalid plugin-category: %s\n", category)
+			os.Exit(1)
+		}
+	}
+
 	var extendedMetrics []string
 	switch *extendedMetricNames {
 	case "all":
@@ -368,14 +569,20 @@ func Do() {
 		}
 	}
 	f := FluentdPlugin{
-		Host:            *host,
-		Port:            *port,
-		Prefix:          *prefix,
-		Tempfile:        *tempFile,
-		pluginType:      *pluginType,
-		pluginIDPattern: pluginIDPattern,
-		extendedMetrics: extendedMetrics,
-		Workers:         *workers,
+		Host:             *host,
+		Port:             *port,
+		Prefix:           *prefix,
+		Tempfile:         *tempFile,
+		pluginType:       *pluginType,
+		pluginCategories: pluginCategories,
+		pluginIDPattern:  pluginIDPattern,
+		extendedMetrics:  extendedMetrics,
+		Workers:          uint(numWorkers),
+		AutoWorkers:      autoWorkers,
+		ConfigFile:       *configFile,
+		Timeout:          *timeout,
+		FluentBit:        *fluentBit,
+		FluentBitAPI:     *fluentBitAPI,
 	}
 
 	helper := mp.NewMackerelPlugin(f)
@@ -383,14 +590,24 @@ func Do() {
 	helper.Tempfile = *tempFile
 	if *tempFile == "" {
 		tempFileSuffix := []string{*host, *port}
+		if *fluentBit {
+			tempFileSuffix = append(tempFileSuffix, "fluent-bit")
+		}
 		if *pluginType != "" {
 			tempFileSuffix = append(tempFileSuffix, *pluginType)
 		}
+		if *pluginCategory != "output" {
+			tempFileSuffix = append(tempFileSuffix, strings.ReplaceAll(*pluginCategory, ",", "_"))
+		}
 		if *pluginIDPatternString != "" {
 			tempFileSuffix = append(tempFileSuffix, fmt.Sprintf("%x", md5.Sum([]byte(*pluginIDPatternString))))
 		}
 		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-fluentd-%s", strings.Join(tempFileSuffix, "-")))
 	}
+	if autoWorkers && *configFile == "" {
+		f.StateFile = helper.Tempfile + ".workers"
+		helper.Plugin = f
+	}
 
 	helper.Run()
 }
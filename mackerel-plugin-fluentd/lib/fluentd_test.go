@@ -32,7 +32,11 @@ func TestGraphDefinitionMultiWorkers(t *testing.T) {
 	fluentd.Workers = 2
 
 	graphdef := fluentd.GraphDefinition()
+	assert.Contains(t, graphdef, "workers")
 	for key := range graphdef {
+		if key == "workers" {
+			continue
+		}
 		last := key[len(key)-1]
 		assert.EqualValues(t, last, "#")
 	}
@@ -165,3 +169,75 @@ func TestPluginIDPatternOption(t *testing.T) {
 	assert.EqualValues(t, reflect.TypeOf(stat["buffer_total_queued_size.do_not_match_plugin_id"]).String(), "float64")
 	assert.EqualValues(t, stat["buffer_total_queued_size.do_not_match_plugin_id"].(float64), 53)
 }
+
+func TestGraphDefinitionCategories(t *testing.T) {
+	fluentd := FluentdPlugin{pluginCategories: []string{"input", "filter"}}
+
+	graphdef := fluentd.GraphDefinition()
+	assert.Len(t, graphdef, 6)
+	assert.Contains(t, graphdef, "input.emit_records")
+	assert.Contains(t, graphdef, "filter.emit_records")
+	assert.NotContains(t, graphdef, "retry_count")
+
+	fluentd = FluentdPlugin{pluginCategories: []string{"input", "output"}, Workers: 2}
+	graphdef = fluentd.GraphDefinition()
+	assert.Len(t, graphdef, 8) // including workers
+	assert.Contains(t, graphdef, "input.emit_records.#")
+	assert.Contains(t, graphdef, "retry_count.#")
+}
+
+func TestParseCategories(t *testing.T) {
+	stub := `{
+	  "plugins": [
+		{
+			"plugin_id": "in_forward",
+			"plugin_category": "input",
+			"type": "forward",
+			"config": {"@type":"forward","@id":"in_forward"},
+			"output_plugin": false,
+			"retry_count": null,
+			"emit_records": 120,
+			"emit_size": 4096
+		},
+		{
+			"plugin_id": "filter_record",
+			"plugin_category": "filter",
+			"type": "record_transformer",
+			"config": {"@type":"record_transformer","@id":"filter_record"},
+			"output_plugin": false,
+			"retry_count": null,
+			"emit_records": 100,
+			"emit_size": 3072
+		},
+		{
+			"plugin_id": "out_stdout",
+			"plugin_category": "output",
+			"type": "stdout",
+			"config": {"@type":"stdout","@id":"out_stdout"},
+			"output_plugin": true,
+			"buffer_queue_length": 0,
+			"buffer_total_queued_size": 0,
+			"retry_count": 0,
+			"emit_records": 100
+		}
+	]}`
+
+	fluentd := FluentdPlugin{pluginCategories: []string{"input", "filter"}}
+	stat, err := fluentd.parseStats([]byte(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"input.emit_records.in_forward":     float64(120),
+		"input.emit_size.in_forward":        float64(4096),
+		"filter.emit_records.filter_record": float64(100),
+		"filter.emit_size.filter_record":    float64(3072),
+	}, stat)
+
+	fluentd = FluentdPlugin{pluginCategories: []string{"input", "output"}, pluginType: "stdout"}
+	stat, err = fluentd.parseStats([]byte(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"retry_count.out_stdout":              float64(0),
+		"buffer_queue_length.out_stdout":      float64(0),
+		"buffer_total_queued_size.out_stdout": float64(0),
+	}, stat)
+}
@@ -0,0 +1,64 @@
+package mpfluentd
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unwatchedPosition is the position in_tail writes for the files no longer watched.
+const unwatchedPosition = 0xffffffffffffffff
+
+// setTailPositions adds the read positions of the files tailed by the in_tail plugin,
+// and how many bytes of them are not read yet, from its pos_file.
+// The pos_file is read directly, so they are available only on the host running fluentd.
+func setTailPositions(metrics map[string]any, p FluentdPluginMetrics) {
+	posFile, ok := p.Config["pos_file"].(string)
+	if !ok || posFile == "" {
+		return
+	}
+	positions, err := readPosFile(posFile)
+	if err != nil {
+		log.Printf("failed to read pos_file of %s: %s", p.PluginID, err)
+		return
+	}
+	for path, pos := range positions {
+		name := normalizePluginID(path)
+		metrics[metricName("input", "tail_position", name)] = float64(pos)
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// the file has been truncated if it is smaller than the position
+		if size := uint64(fi.Size()); size >= pos {
+			metrics[metricName("input", "tail_unread_bytes", name)] = float64(size - pos)
+		}
+	}
+}
+
+// readPosFile reads the positions of the watched files from pos_file of in_tail,
+// whose line is "<path>\t<position in hex>\t<inode in hex>".
+func readPosFile(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	positions := make(map[string]uint64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		pos, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil || pos == unwatchedPosition {
+			continue
+		}
+		positions[fields[0]] = pos
+	}
+	return positions, s.Err()
+}
@@ -0,0 +1,55 @@
+package mpfluentd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTailPositions(t *testing.T) {
+	dir := t.TempDir()
+	access := filepath.Join(dir, "access.log")
+	require.NoError(t, os.WriteFile(access, make([]byte, 1000), 0644))
+	truncated := filepath.Join(dir, "truncated.log")
+	require.NoError(t, os.WriteFile(truncated, make([]byte, 10), 0644))
+	rotated := filepath.Join(dir, "rotated.log")
+
+	posFile := filepath.Join(dir, "access.log.pos")
+	pos := fmt.Sprintf("%s\t%016x\t%016x\n%s\t%016x\t%016x\n%s\t%016x\t%016x\n",
+		access, 600, 1234,
+		truncated, 20, 1235,
+		rotated, uint64(unwatchedPosition), 1236,
+	)
+	require.NoError(t, os.WriteFile(posFile, []byte(pos), 0644))
+
+	p := FluentdPluginMetrics{
+		PluginID: "in_tail_access",
+		Type:     "tail",
+		Config:   map[string]any{"@type": "tail", "pos_file": posFile},
+	}
+	metrics := make(map[string]any)
+	setTailPositions(metrics, p)
+
+	accessName := normalizePluginID(access)
+	truncatedName := normalizePluginID(truncated)
+	assert.Equal(t, map[string]any{
+		"input.tail_position." + accessName:     float64(600),
+		"input.tail_unread_bytes." + accessName: float64(400),
+		"input.tail_position." + truncatedName:  float64(20),
+	}, metrics)
+}
+
+func TestSetTailPositionsWithoutPosFile(t *testing.T) {
+	p := FluentdPluginMetrics{
+		PluginID: "in_tail_access",
+		Type:     "tail",
+		Config:   map[string]any{"@type": "tail", "path": "/var/log/access.log"},
+	}
+	metrics := make(map[string]any)
+	setTailPositions(metrics, p)
+	assert.Empty(t, metrics)
+}
@@ -0,0 +1,220 @@
+package mpfluentd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxProbedWorkers is the maximum number of workers detected by probing the ports.
+const maxProbedWorkers = 128
+
+// workersForgetAfter is the period after which a worker not responding is regarded as removed, rather than down.
+const workersForgetAfter = 24 * time.Hour
+
+// foreignPortRecheckAfter is the period for which a port found not to be a monitor_agent is not probed again.
+// e.g. in_forward listens on 24224 by default, next to the monitor_agents of four workers from 24220,
+// and logs a warning on every request of the probe.
+const foreignPortRecheckAfter = 24 * time.Hour
+
+// errNotMonitorAgent is returned by probeWorker when the port responds but it isn't a monitor_agent.
+var errNotMonitorAgent = errors.New("not a monitor_agent")
+
+func (f FluentdPlugin) multiWorkers() bool {
+	return f.Workers > 1 || f.AutoWorkers
+}
+
+// fetchWorkers fetches the metrics of the workers listening on the consecutive ports from port concurrently.
+// The results of the workers which failed are nil, and their errors are in errs.
+func (f FluentdPlugin) fetchWorkers(port int, workers uint) (results []map[string]any, errs []error) {
+	results = make([]map[string]any, workers)
+	errs = make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// parseStats is not safe to call concurrently on the same FluentdPlugin
+			w := f
+			m, err := w.fetchFluentdMetrics(f.Host, port+i)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = m
+		}(i)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// workersState keeps the workers found by probing.
+type workersState struct {
+	// LastUp is the last time each worker responded
+	LastUp []time.Time `json:"last_up"`
+	// ForeignPort is the port after the workers which was found not to be a monitor_agent at ForeignAt
+	ForeignPort int       `json:"foreign_port,omitempty"`
+	ForeignAt   time.Time `json:"foreign_at,omitzero"`
+}
+
+func loadWorkersState(path string) (*workersState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var s workersState
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func saveWorkersState(path string, s workersState) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(s)
+}
+
+// workers returns the number of the workers which responded within workersForgetAfter.
+func (s *workersState) workers(now time.Time) int {
+	if s == nil {
+		return 0
+	}
+	for i := len(s.LastUp) - 1; i >= 0; i-- {
+		if t := s.LastUp[i]; !t.IsZero() && now.Sub(t) <= workersForgetAfter {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// probeWorkers fetches the metrics of the workers known by StateFile concurrently,
+// and probes the ports after them one by one to find the added workers.
+// The probe stops at the first port which refuses the connection or isn't a monitor_agent,
+// so that the other services listening on the ports nearby, such as in_forward, are not disturbed.
+// The known workers which don't respond are returned as nil, so that they are counted as down.
+func (f FluentdPlugin) probeWorkers(port int, now time.Time) []map[string]any {
+	var state *workersState
+	if f.StateFile != "" {
+		var err error
+		if state, err = loadWorkersState(f.StateFile); err != nil {
+			// the state file is broken; overwrite it with the current workers
+			log.Println("loadWorkersState (ignore):", err)
+		}
+	}
+	known := state.workers(now)
+	results, errs := f.fetchWorkers(port, uint(known))
+
+	next := workersState{LastUp: make([]time.Time, known)}
+	if state != nil {
+		copy(next.LastUp, state.LastUp)
+		if now.Sub(state.ForeignAt) <= foreignPortRecheckAfter {
+			next.ForeignPort, next.ForeignAt = state.ForeignPort, state.ForeignAt
+		}
+	}
+	for i := known; i < maxProbedWorkers && port+i != next.ForeignPort; i++ {
+		m, err := f.probeWorker(port + i)
+		if err != nil {
+			switch {
+			case !errors.Is(err, syscall.ECONNREFUSED):
+				log.Printf("stop probing workers at port %d: %s", port+i, err)
+				next.ForeignPort, next.ForeignAt = port+i, now
+			case i == 0:
+				// worker0 is always expected, so it is counted as down
+				results = append(results, nil)
+				errs = append(errs, err)
+				next.LastUp = append(next.LastUp, time.Time{})
+			}
+			break
+		}
+		results = append(results, m)
+		errs = append(errs, nil)
+		next.LastUp = append(next.LastUp, time.Time{})
+	}
+
+	for i, m := range results {
+		if m != nil {
+			next.LastUp[i] = now
+		}
+	}
+	if f.StateFile != "" {
+		if err := saveWorkersState(f.StateFile, next); err != nil {
+			log.Printf("failed to save the workers: %s", err)
+		}
+	}
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("failed to fetch metrics of worker%d: %s", i, err)
+		}
+	}
+	return results
+}
+
+// probeWorker fetches the metrics of the worker on port,
+// which must respond the JSON of monitor_agent containing "plugins".
+func (f FluentdPlugin) probeWorker(port int) (map[string]any, error) {
+	body, err := f.fetchBody(fmt.Sprintf("http://%s:%d/api/plugins.json", f.Host, port))
+	if err != nil {
+		return nil, err
+	}
+	var j map[string]json.RawMessage
+	if err := json.Unmarshal(body, &j); err != nil {
+		return nil, fmt.Errorf("%w: %s", errNotMonitorAgent, err)
+	}
+	if _, ok := j["plugins"]; !ok {
+		return nil, fmt.Errorf("%w: no plugins in the response", errNotMonitorAgent)
+	}
+	return f.parseStats(body)
+}
+
+// readWorkersConfig reads the workers directive in the <system> section of fluentd's config file.
+// It returns 1 if the directive is not found as fluentd does.
+func readWorkersConfig(file string) (uint, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	inSystem := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "<system>":
+			inSystem = true
+		case fields[0] == "</system>":
+			inSystem = false
+		case inSystem && fields[0] == "workers" && len(fields) > 1:
+			n, err := strconv.ParseUint(fields[1], 10, 0)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("invalid workers in %s: %s", file, fields[1])
+			}
+			return uint(n), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
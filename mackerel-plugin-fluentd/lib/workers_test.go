@@ -0,0 +1,164 @@
+package mpfluentd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const workerStub = `{"plugins":[{"plugin_id":"stdout_output","plugin_category":"output","type":"stdout","output_plugin":true,"buffer_queue_length":0,"buffer_total_queued_size":%d,"retry_count":0}]}`
+
+// startWorkers starts monitor_agents of the workers on the consecutive ports.
+// Each byte of workers is the worker on the port: 'u' is up, 'd' is down and doesn't listen,
+// and 'f' is a foreign service which responds but isn't a monitor_agent.
+func startWorkers(t *testing.T, workers string) int {
+	t.Helper()
+	n := len(workers)
+	for {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		base := l.Addr().(*net.TCPAddr).Port
+		listeners := []net.Listener{l}
+		for i := 1; i < n; i++ {
+			l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(base+i))
+			if err != nil {
+				break
+			}
+			listeners = append(listeners, l)
+		}
+		if len(listeners) < n {
+			// the consecutive ports are not available; retry with other ports
+			for _, l := range listeners {
+				l.Close()
+			}
+			continue
+		}
+		for i, l := range listeners {
+			var h http.HandlerFunc
+			switch workers[i] {
+			case 'd':
+				l.Close()
+				continue
+			case 'f':
+				h = func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(w, `{"status":"ok"}`)
+				}
+			default:
+				h = func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintf(w, workerStub, i)
+				}
+			}
+			ts := httptest.NewUnstartedServer(h)
+			ts.Listener.Close()
+			ts.Listener = l
+			ts.Start()
+			t.Cleanup(ts.Close)
+		}
+		return base
+	}
+}
+
+func TestFetchMetricsWorkers(t *testing.T) {
+	port := startWorkers(t, "udu")
+	fluentd := FluentdPlugin{Host: "127.0.0.1", Port: strconv.Itoa(port), Workers: 3, Timeout: time.Second}
+	stat, err := fluentd.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), stat["buffer_total_queued_size.worker0.stdout_output"])
+	assert.NotContains(t, stat, "buffer_total_queued_size.worker1.stdout_output")
+	assert.Equal(t, float64(2), stat["buffer_total_queued_size.worker2.stdout_output"])
+	assert.Equal(t, float64(2), stat["workers_up"])
+	assert.Equal(t, float64(1), stat["workers_down"])
+}
+
+func TestFetchMetricsAutoWorkers(t *testing.T) {
+	port := startWorkers(t, "uduuf")
+
+	// probing the ports stops at the first one which doesn't respond
+	state := filepath.Join(t.TempDir(), "workers")
+	fluentd := FluentdPlugin{Host: "127.0.0.1", Port: strconv.Itoa(port), AutoWorkers: true, StateFile: state, Timeout: time.Second}
+	stat, err := fluentd.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), stat["buffer_total_queued_size.worker0.stdout_output"])
+	assert.NotContains(t, stat, "buffer_total_queued_size.worker2.stdout_output")
+	assert.Equal(t, float64(1), stat["workers_up"])
+	assert.Equal(t, float64(0), stat["workers_down"])
+
+	// the workers known by the state are counted as down, unless they have been down for long,
+	// and probing the ports after them finds the workers up to the port which isn't a monitor_agent
+	now := time.Now()
+	require.NoError(t, saveWorkersState(state, workersState{LastUp: []time.Time{
+		now, now.Add(-time.Hour), {}, now.Add(-workersForgetAfter - time.Hour),
+	}}))
+	stat, err = fluentd.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, float64(3), stat["buffer_total_queued_size.worker3.stdout_output"])
+	assert.Equal(t, float64(3), stat["workers_up"])
+	assert.Equal(t, float64(1), stat["workers_down"])
+	saved, err := loadWorkersState(state)
+	require.NoError(t, err)
+	assert.Len(t, saved.LastUp, 4)
+	assert.Equal(t, port+4, saved.ForeignPort)
+
+	// the port which isn't a monitor_agent is not probed again for a while
+	_, err = fluentd.FetchMetrics()
+	require.NoError(t, err)
+	again, err := loadWorkersState(state)
+	require.NoError(t, err)
+	assert.True(t, again.ForeignAt.Equal(saved.ForeignAt))
+
+	// reading the config
+	conf := filepath.Join(t.TempDir(), "fluentd.conf")
+	require.NoError(t, os.WriteFile(conf, []byte("<system>\n  workers 4\n</system>\n"), 0644))
+	fluentd = FluentdPlugin{Host: "127.0.0.1", Port: strconv.Itoa(port), AutoWorkers: true, ConfigFile: conf, Timeout: time.Second}
+	stat, err = fluentd.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, float64(3), stat["workers_up"])
+	assert.Equal(t, float64(1), stat["workers_down"])
+}
+
+func TestReadWorkersConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want uint
+	}{
+		{
+			name: "workers",
+			conf: "<system>\n  log_level info\n  workers 3 # three workers\n</system>\n\n<source>\n  @type monitor_agent\n  port 24230\n</source>\n",
+			want: 3,
+		},
+		{
+			name: "no workers",
+			conf: "<system>\n  log_level info\n</system>\n",
+			want: 1,
+		},
+		{
+			name: "workers outside of system",
+			conf: "<source>\n  @type sample\n  workers 3\n</source>\n",
+			want: 1,
+		},
+		{
+			name: "commented out",
+			conf: "<system>\n  # workers 3\n</system>\n",
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := filepath.Join(t.TempDir(), "fluentd.conf")
+			require.NoError(t, os.WriteFile(conf, []byte(tt.conf), 0644))
+			n, err := readWorkersConfig(conf)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, n)
+		})
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 
 	stats_api "github.com/fukata/golang-stats-api-handler"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -17,6 +17,7 @@ import (
 type GostatsPlugin struct {
 	URI    string
 	Prefix string
+	httpclient.Options
 }
 
 /*
@@ -105,13 +106,12 @@ func (m GostatsPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // FetchMetrics interface for mackerelplugin
 func (m GostatsPlugin) FetchMetrics() (map[string]float64, error) {
-	req, err := http.NewRequest(http.MethodGet, m.URI, nil)
+	m.UserAgent = "mackerel-plugin-gostats"
+	client, err := m.Options.NewClient()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "mackerel-plugin-gostats")
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Get(m.URI)
 	if err != nil {
 		return nil, err
 	}
@@ -157,11 +157,11 @@ func Do() {
 	optPath := flag.String("path", "/api/stats", "Path")
 	optPrefix := flag.String("metric-key-prefix", "gostats", "Metric key prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	var gosrv GostatsPlugin
+	gosrv.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	gosrv := GostatsPlugin{
-		Prefix: *optPrefix,
-	}
+	gosrv.Prefix = *optPrefix
 	if *optURI != "" {
 		gosrv.URI = *optURI
 	} else {
@@ -6,10 +6,15 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 const prefix = "h2o"
@@ -183,26 +188,23 @@ var graphdef = map[string]mp.Graphs{
 	},
 }
 
-type stringSlice []string
-
-func (s *stringSlice) Set(v string) error {
-	*s = append(*s, v)
-	return nil
-}
-
-func (s *stringSlice) String() string {
-	return fmt.Sprintf("%v", *s)
-}
-
 // H2OPlugin mackerel plugin for H2O
 type H2OPlugin struct {
 	Prefix string
 	URI    string
-	Header stringSlice
+	httpclient.Options
+
+	// status is fetched once and shared between GraphDefinition and FetchMetrics
+	status *h2oStatus
+}
+
+type h2oStatus struct {
+	metrics map[string]float64
+	graphs  map[string]mp.Graphs
 }
 
 // MetricKeyPrefix interface for mackerelplugin
-func (h2o H2OPlugin) MetricKeyPrefix() string {
+func (h2o *H2OPlugin) MetricKeyPrefix() string {
 	if h2o.Prefix == "" {
 		h2o.Prefix = prefix
 	}
@@ -210,78 +212,212 @@ func (h2o H2OPlugin) MetricKeyPrefix() string {
 }
 
 // GraphDefinition interface for mackerelplugin
-func (h2o H2OPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+// Graphs are generated from the values present in the status, so that events of newer h2o are also posted.
+// If the status cannot be fetched, the graphs of the well-known values are returned.
+func (h2o *H2OPlugin) GraphDefinition() map[string]mp.Graphs {
+	status, err := h2o.fetchStatus()
+	if err != nil {
+		return graphdef
+	}
+	return status.graphs
 }
 
 // FetchMetrics interface for mackerelplugin
-func (h2o H2OPlugin) FetchMetrics() (map[string]float64, error) {
-	req, err := http.NewRequest("GET", h2o.URI, nil)
+func (h2o *H2OPlugin) FetchMetrics() (map[string]float64, error) {
+	status, err := h2o.fetchStatus()
 	if err != nil {
 		return nil, err
 	}
-	for _, h := range h2o.Header {
-		kv := strings.SplitN(h, ":", 2)
-		var k, v string
-		k = strings.TrimSpace(kv[0])
-		if len(kv) == 2 {
-			v = strings.TrimSpace(kv[1])
-		}
-		if http.CanonicalHeaderKey(k) == "Host" {
-			req.Host = v
-		} else {
-			req.Header.Set(k, v)
-		}
-	}
-	// set default User-Agent unless specified by h2o.Header
-	if _, ok := req.Header["User-Agent"]; !ok {
-		req.Header.Set("User-Agent", "mackerel-plugin-h2o")
+	return status.metrics, nil
+}
+
+func (h2o *H2OPlugin) fetchStatus() (*h2oStatus, error) {
+	if h2o.status != nil {
+		return h2o.status, nil
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	h2o.UserAgent = "mackerel-plugin-h2o"
+	client, err := h2o.Options.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(h2o.URI)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return h2o.parseStats(resp.Body)
+	status, err := parseStatus(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	h2o.status = status
+	return status, nil
 }
 
-func (h2o H2OPlugin) parseStats(body io.Reader) (map[string]float64, error) {
-	stat := make(map[string]any)
-	metrics := make(map[string]float64)
-
-	b, err := io.ReadAll(body)
+func (h2o *H2OPlugin) parseStats(body io.Reader) (map[string]float64, error) {
+	status, err := parseStatus(body)
 	if err != nil {
 		return nil, err
 	}
+	return status.metrics, nil
+}
 
-	err = json.Unmarshal(b, &stat)
-	if err != nil {
+var (
+	nameReplacer = strings.NewReplacer(".", "_", "-", "_")
+	invalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+	// e.g. "connect-time-99"
+	durationRe = regexp.MustCompile(`^([a-z0-9-]+)-([0-9]+)$`)
+
+	// graphs of events which had been defined before graphs were generated
+	legacyEventGraphs = map[string]string{
+		"http2.read-closed":  "read_closed",
+		"http2.write-closed": "write_closed",
+	}
+)
+
+// labels of the metrics defined in graphdef
+var metricLabels = func() map[string]string {
+	labels := make(map[string]string)
+	for _, g := range graphdef {
+		for _, m := range g.Metrics {
+			labels[m.Name] = m.Label
+		}
+	}
+	return labels
+}()
+
+var acronyms = map[string]string{
+	"ssl":   "SSL",
+	"alpn":  "ALPN",
+	"http1": "HTTP1",
+	"http2": "HTTP2",
+	"http3": "HTTP3",
+	"quic":  "QUIC",
+}
+
+func title(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool { return r == '.' || r == '-' || r == '_' })
+	for i, w := range words {
+		if a, ok := acronyms[w]; ok {
+			words[i] = a
+		} else {
+			words[i] = cases.Title(language.Und, cases.NoLower).String(w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// parseStatus parses the output of status/json and generates graphs from its values:
+//
+//   - durations such as "connect-time-99" are grouped by the name before the percentile
+//   - events such as "status-errors.404" or "ssl.handshake.full" are grouped by the name before the last dot, as counters
+//   - in-flight requests are counted in total and by protocol
+func parseStatus(body io.Reader) (*h2oStatus, error) {
+	stat := make(map[string]any)
+	if err := json.NewDecoder(body).Decode(&stat); err != nil {
 		return nil, err
 	}
 
-	r := strings.NewReplacer(".", "_", "-", "_")
+	metrics := make(map[string]float64)
+	graphs := make(map[string]mp.Graphs)
+	addMetric := func(graphName, graphLabel, unit string, m mp.Metrics) {
+		g, ok := graphs[graphName]
+		if !ok {
+			g = mp.Graphs{Label: "H2O " + graphLabel, Unit: unit}
+		}
+		g.Metrics = append(g.Metrics, m)
+		graphs[graphName] = g
+	}
 
 	for k, v := range stat {
 		switch k {
 		case "server-version", "openssl-version", "current-time", "restart-time", "generation":
+			continue
 		case "requests":
-			requests, ok := stat["requests"].([]any)
+			requests, ok := v.([]any)
 			if !ok {
 				return nil, errors.New("cannot get \"requests\" value")
 			}
 			metrics["requests"] = float64(len(requests))
-		default:
-			f, ok := v.(float64)
+			addMetric("requests", "Requests", mp.UnitInteger, mp.Metrics{Name: "requests", Label: "In-flight Requests"})
+
+			byProtocol := make(map[string]float64)
+			for _, r := range requests {
+				if req, ok := r.(map[string]any); ok {
+					if p, ok := req["protocol"].(string); ok && p != "" {
+						byProtocol[p]++
+					}
+				}
+			}
+			for p, n := range byProtocol {
+				name := "requests_protocol_" + invalidChars.ReplaceAllString(strings.ToLower(p), "_")
+				metrics[name] = n
+				addMetric("requests_protocol", "In-flight Requests by Protocol", mp.UnitInteger, mp.Metrics{Name: name, Label: p, Stacked: true})
+			}
+			continue
+		}
+
+		f, ok := v.(float64)
+		if !ok {
+			// e.g. "evloop-latency-nanosec" is a list
+			continue
+		}
+		name := invalidChars.ReplaceAllString(nameReplacer.Replace(k), "_")
+		metrics[name] = f
+
+		if i := strings.LastIndex(k, "."); i > 0 {
+			graphName, ok := legacyEventGraphs[k]
 			if !ok {
-				return nil, fmt.Errorf("cannot get %q value", k)
+				graphName = invalidChars.ReplaceAllString(nameReplacer.Replace(k[:i]), "_")
+			}
+			label, ok := metricLabels[name]
+			if !ok {
+				label = title(k[i+1:])
+			}
+			addMetric(graphName, title(graphName), mp.UnitInteger, mp.Metrics{Name: name, Label: label, Diff: true})
+			continue
+		}
+
+		if m := durationRe.FindStringSubmatch(k); m != nil {
+			graphName := nameReplacer.Replace(m[1])
+			addMetric(graphName, title(m[1]), mp.UnitFloat, mp.Metrics{Name: name, Label: m[2] + " Percentile"})
+			continue
+		}
+
+		for graphName, g := range graphdef {
+			for _, m := range g.Metrics {
+				if m.Name == name {
+					addMetric(graphName, strings.TrimPrefix(g.Label, "H2O "), g.Unit, m)
+				}
 			}
-			metrics[r.Replace(k)] = f
 		}
+		if _, ok := metricLabels[name]; !ok {
+			addMetric(name, title(k), mp.UnitFloat, mp.Metrics{Name: name, Label: title(k)})
+		}
+	}
+
+	for k, g := range graphs {
+		sort.Slice(g.Metrics, func(i, j int) bool {
+			return metricOrder(g.Metrics[i].Name) < metricOrder(g.Metrics[j].Name)
+		})
+		graphs[k] = g
 	}
+	return &h2oStatus{metrics: metrics, graphs: graphs}, nil
+}
 
-	return metrics, nil
+var numberSuffixRe = regexp.MustCompile(`^(.+)_([0-9]+)$`)
+
+// metricOrder returns a key to sort metrics in a graph.
+// Metrics with a number suffix such as percentiles and status codes are sorted from the highest, as graphdef.
+func metricOrder(name string) string {
+	if m := numberSuffixRe.FindStringSubmatch(name); m != nil {
+		if n, err := strconv.Atoi(m[2]); err == nil && n < 1000 {
+			return fmt.Sprintf("%s_%03d", m[1], 999-n)
+		}
+	}
+	return name
 }
 
 // Do the plugin
@@ -293,21 +429,18 @@ func Do() {
 	optPath := flag.String("path", "/server-status/json", "Path")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optPrefix := flag.String("metric-key-prefix", prefix, "Metric key prefix")
-	optHeader := &stringSlice{}
-	flag.Var(optHeader, "header", "Set http header (e.g. \"Host: servername\")")
+	var h2o H2OPlugin
+	h2o.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	var h2o = H2OPlugin{
-		Prefix: *optPrefix,
-		Header: *optHeader,
-	}
+	h2o.Prefix = *optPrefix
 	if *optURI != "" {
 		h2o.URI = *optURI
 	} else {
 		h2o.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
 	}
 
-	helper := mp.NewMackerelPlugin(h2o)
+	helper := mp.NewMackerelPlugin(&h2o)
 	helper.Tempfile = *optTempfile
 	helper.Run()
 }
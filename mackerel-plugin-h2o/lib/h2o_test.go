@@ -2,10 +2,14 @@ package mph2o
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	mp "github.com/mackerelio/go-mackerel-plugin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGraphDefinition(t *testing.T) {
@@ -19,7 +23,21 @@ func TestGraphDefinition(t *testing.T) {
 
 func TestParse(t *testing.T) {
 	var h2o H2OPlugin
-	stub := `{
+	stub := statusStub
+	h2oStats := strings.NewReader(stub)
+
+	stat, err := h2o.parseStats(h2oStats)
+	fmt.Println(stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 288, stat["uptime"])
+	assert.EqualValues(t, 1, stat["requests"])
+	assert.EqualValues(t, 1, stat["connections"])
+	assert.EqualValues(t, 2, stat["status_errors_404"])
+	assert.EqualValues(t, 3, stat["http2_read_closed"])
+	assert.EqualValues(t, 0, stat["connect_time_25"])
+}
+
+var statusStub = `{
  "server-version": "2.3.0-DEV",
  "openssl-version": "LibreSSL 2.4.5",
  "current-time": "01/Dec/2017:08:18:16 +0000",
@@ -158,15 +176,81 @@ func TestParse(t *testing.T) {
  "duration-99": 0
 }`
 
-	h2oStats := strings.NewReader(stub)
+func TestParseStatusGraphs(t *testing.T) {
+	status, err := parseStatus(strings.NewReader(statusStub))
+	require.NoError(t, err)
 
-	stat, err := h2o.parseStats(h2oStats)
-	fmt.Println(stat)
-	assert.Nil(t, err)
-	assert.EqualValues(t, 288, stat["uptime"])
-	assert.EqualValues(t, 1, stat["requests"])
-	assert.EqualValues(t, 1, stat["connections"])
+	// graphs of the well-known values are the same as the static definitions
+	for _, name := range []string{"uptime", "connections", "status_errors", "read_closed", "connect_time", "duration"} {
+		assert.Equal(t, graphdef[name], status.graphs[name], name)
+	}
+	assert.Len(t, status.graphs["http2_errors"].Metrics, 12)
+	assert.Contains(t, status.graphs["http2_errors"].Metrics, mp.Metrics{Name: "http2_errors_stream_closed", Label: "Stream Closed", Diff: true})
+	assert.Equal(t, mp.Metrics{Name: "requests_protocol_http_2", Label: "HTTP/2", Stacked: true}, status.graphs["requests_protocol"].Metrics[0])
+	assert.EqualValues(t, 1, status.metrics["requests_protocol_http_2"])
+}
+
+func TestParseStatusNewEvents(t *testing.T) {
+	stub := `{
+ "server-version": "2.3.0-beta2",
+ "uptime": 100,
+ "connections": 3,
+ "evloop-latency-nanosec": [100, 200],
+ "requests": [],
+ "ssl.errors": 1,
+ "ssl.alpn.h1": 10,
+ "ssl.alpn.h2": 20,
+ "ssl.handshake.full": 5,
+ "ssl.handshake.resume": 25,
+ "quic.packet-received": 1000,
+ "memory.mmap_errors": 0,
+ "first-byte-time-0": 0.001,
+ "first-byte-time-50": 0.01,
+ "first-byte-time-99": 0.5
+}`
+	status, err := parseStatus(strings.NewReader(stub))
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 0, status.metrics["requests"])
+	assert.NotContains(t, status.metrics, "evloop_latency_nanosec")
+	assert.EqualValues(t, 25, status.metrics["ssl_handshake_resume"])
+
+	assert.Equal(t, mp.Graphs{
+		Label: "H2O SSL Handshake",
+		Unit:  mp.UnitInteger,
+		Metrics: []mp.Metrics{
+			{Name: "ssl_handshake_full", Label: "Full", Diff: true},
+			{Name: "ssl_handshake_resume", Label: "Resume", Diff: true},
+		},
+	}, status.graphs["ssl_handshake"])
+	assert.Equal(t, []mp.Metrics{{Name: "ssl_errors", Label: "Errors", Diff: true}}, status.graphs["ssl"].Metrics)
+	assert.Len(t, status.graphs["ssl_alpn"].Metrics, 2)
+	assert.Contains(t, status.graphs, "quic")
+	assert.Contains(t, status.graphs, "memory")
+	assert.Equal(t, mp.Graphs{
+		Label: "H2O First Byte Time",
+		Unit:  mp.UnitFloat,
+		Metrics: []mp.Metrics{
+			{Name: "first_byte_time_99", Label: "99 Percentile"},
+			{Name: "first_byte_time_50", Label: "50 Percentile"},
+			{Name: "first_byte_time_0", Label: "0 Percentile"},
+		},
+	}, status.graphs["first_byte_time"])
+}
+
+func TestFetchStatusOnce(t *testing.T) {
+	var n int
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		fmt.Fprint(w, statusStub)
+	}))
+	defer sv.Close()
+
+	h2o := H2OPlugin{URI: sv.URL}
+	graphs := h2o.GraphDefinition()
+	stat, err := h2o.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Contains(t, graphs, "http2_errors")
 	assert.EqualValues(t, 2, stat["status_errors_404"])
-	assert.EqualValues(t, 3, stat["http2_read_closed"])
-	assert.EqualValues(t, 0, stat["connect_time_25"])
 }
@@ -2,18 +2,19 @@ package mphaproxy
 
 import (
 	"bufio"
-	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 )
 
 var graphdef = map[string]mp.Graphs{
@@ -41,12 +42,197 @@ var graphdef = map[string]mp.Graphs{
 	},
 }
 
+type detailMetric struct {
+	graph  string
+	column string
+	mp.Metrics
+}
+
+// metrics of each frontend, backend and server. graph keys are suffixed with "frontend", "backend" or "server".
+var detailMetrics = []detailMetric{
+	{"sessions", "scur", mp.Metrics{Name: "current", Label: "Current"}},
+	{"sessions", "smax", mp.Metrics{Name: "max", Label: "Max"}},
+	{"sessions", "slim", mp.Metrics{Name: "limit", Label: "Limit"}},
+	{"queue", "qcur", mp.Metrics{Name: "current", Label: "Current"}},
+	{"queue", "qmax", mp.Metrics{Name: "max", Label: "Max"}},
+	{"responses", "hrsp_1xx", mp.Metrics{Name: "1xx", Label: "1xx", Diff: true, Stacked: true}},
+	{"responses", "hrsp_2xx", mp.Metrics{Name: "2xx", Label: "2xx", Diff: true, Stacked: true}},
+	{"responses", "hrsp_3xx", mp.Metrics{Name: "3xx", Label: "3xx", Diff: true, Stacked: true}},
+	{"responses", "hrsp_4xx", mp.Metrics{Name: "4xx", Label: "4xx", Diff: true, Stacked: true}},
+	{"responses", "hrsp_5xx", mp.Metrics{Name: "5xx", Label: "5xx", Diff: true, Stacked: true}},
+	{"responses", "hrsp_other", mp.Metrics{Name: "other", Label: "Other", Diff: true, Stacked: true}},
+	{"errors", "ereq", mp.Metrics{Name: "request", Label: "Request", Diff: true}},
+	{"errors", "econ", mp.Metrics{Name: "connection", Label: "Connection", Diff: true}},
+	{"errors", "eresp", mp.Metrics{Name: "response", Label: "Response", Diff: true}},
+	{"warnings", "wretr", mp.Metrics{Name: "retries", Label: "Retries", Diff: true}},
+	{"warnings", "wredis", mp.Metrics{Name: "redispatches", Label: "Redispatches", Diff: true}},
+	{"time", "qtime", mp.Metrics{Name: "queue", Label: "Queue"}},
+	{"time", "ctime", mp.Metrics{Name: "connect", Label: "Connect"}},
+	{"time", "rtime", mp.Metrics{Name: "response", Label: "Response"}},
+	{"time", "ttime", mp.Metrics{Name: "total", Label: "Total"}},
+	{"status", "status", mp.Metrics{Name: "status", Label: "Status"}},
+	{"check_failures", "chkfail", mp.Metrics{Name: "failed", Label: "Failed Checks", Diff: true}},
+	{"check_failures", "chkdown", mp.Metrics{Name: "down", Label: "UP to DOWN Transitions", Diff: true}},
+}
+
+// frontends have no queue, server side warnings, timings nor health checks
+var frontendSkipGraphs = map[string]bool{
+	"queue":          true,
+	"warnings":       true,
+	"time":           true,
+	"check_failures": true,
+}
+
+var detailGraphLabels = map[string]struct{ label, unit string }{
+	"sessions":       {"Sessions", mp.UnitInteger},
+	"queue":          {"Queue", mp.UnitInteger},
+	"responses":      {"HTTP Responses", mp.UnitInteger},
+	"errors":         {"Errors", mp.UnitInteger},
+	"warnings":       {"Warnings", mp.UnitInteger},
+	"time":           {"Average Time (last 1024 requests)", mp.UnitMilliseconds},
+	"status":         {"Status (0:DOWN 1:UP 2:MAINT 3:DRAIN 4:NOLB)", mp.UnitInteger},
+	"check_failures": {"Check Failures", mp.UnitInteger},
+}
+
+// statusValues maps the status column to a number. Transitional states such as "UP 1/3" are mapped by their prefix.
+var statusValues = []struct {
+	prefix string
+	value  float64
+}{
+	{"DOWN", 0},
+	{"UP", 1},
+	{"OPEN", 1},
+	{"no check", 1},
+	{"MAINT", 2},
+	{"DRAIN", 3},
+	{"NOLB", 4},
+}
+
+func parseStatus(s string) (float64, bool) {
+	for _, st := range statusValues {
+		if strings.HasPrefix(s, st.prefix) {
+			return st.value, true
+		}
+	}
+	return 0, false
+}
+
+var normalizeNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeName(in string) string {
+	return normalizeNameRe.ReplaceAllString(in, "_")
+}
+
+// parseField returns the numeric value of the field. Empty fields mean the field is not applicable to the row.
+func parseField(row statRow, name string) (float64, bool) {
+	s, ok := row[name]
+	if !ok || s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
 // HAProxyPlugin mackerel plugin for haproxy
 type HAProxyPlugin struct {
-	URI      string
-	Username string
-	Password string
-	Socket   string
+	URI    string
+	Socket string
+	httpclient.Options
+	// Format is the format to request: "csv", "typed", "prometheus" or "dataplane"
+	Format string
+
+	// per frontend/backend and per server metrics
+	ProxyMetrics         bool
+	ServerMetrics        bool
+	ProxyPattern         *regexp.Regexp
+	ProxyExcludePattern  *regexp.Regexp
+	ServerPattern        *regexp.Regexp
+	ServerExcludePattern *regexp.Regexp
+}
+
+func matchFilter(name string, include, exclude *regexp.Regexp) bool {
+	if include != nil && !include.MatchString(name) {
+		return false
+	}
+	if exclude != nil && exclude.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// rowTypes maps the values of the "type" field to the types of the rows.
+var rowTypes = map[string]string{
+	"0": "frontend",
+	"1": "backend",
+	"2": "server",
+	"3": "listener",
+}
+
+// rowType returns "frontend", "backend", "server" or "listener" for the row by its "type" field.
+// The rows without the field are classified by svname.
+func rowType(row statRow) string {
+	if t, ok := rowTypes[row["type"]]; ok {
+		return t
+	}
+	switch row["svname"] {
+	case "FRONTEND":
+		return "frontend"
+	case "BACKEND":
+		return "backend"
+	}
+	return "server"
+}
+
+// rowKind returns "frontend", "backend" or "server" for the row, or "" if the row should be skipped.
+// Listener rows, reported with `option socket-stats`, are always skipped.
+func (p HAProxyPlugin) rowKind(row statRow) string {
+	if !p.ProxyMetrics && !p.ServerMetrics {
+		return ""
+	}
+	if !matchFilter(row["pxname"], p.ProxyPattern, p.ProxyExcludePattern) {
+		return ""
+	}
+	switch t := rowType(row); t {
+	case "frontend", "backend":
+		if p.ProxyMetrics {
+			return t
+		}
+	case "server":
+		if p.ServerMetrics && matchFilter(row["svname"], p.ServerPattern, p.ServerExcludePattern) {
+			return t
+		}
+	}
+	return ""
+}
+
+// parseDetail sets per frontend/backend/server metrics of the row into stat.
+// Empty fields, which mean the metric is not applicable to the row, are skipped.
+func (p HAProxyPlugin) parseDetail(row statRow, stat map[string]float64) {
+	kind := p.rowKind(row)
+	if kind == "" {
+		return
+	}
+	name := normalizeName(row["pxname"])
+	if kind == "server" {
+		name = normalizeName(row["pxname"] + "_" + row["svname"])
+	}
+
+	for _, m := range detailMetrics {
+		var value float64
+		var ok bool
+		if m.column == "status" {
+			value, ok = parseStatus(row[m.column])
+		} else {
+			value, ok = parseField(row, m.column)
+		}
+		if !ok {
+			continue
+		}
+		stat[fmt.Sprintf("haproxy.%s.%s.%s.%s", kind, m.graph, name, m.Name)] = value
+	}
 }
 
 // FetchMetrics interface for mackerelplugin
@@ -62,21 +248,17 @@ func (p HAProxyPlugin) FetchMetrics() (map[string]float64, error) {
 }
 
 func (p HAProxyPlugin) fetchMetricsFromTCP() (map[string]float64, error) {
-	client := &http.Client{
-		Timeout: time.Duration(5) * time.Second,
-	}
-
-	requestURI := p.URI + ";csv;norefresh"
-	req, err := http.NewRequest("GET", requestURI, nil)
+	p.UserAgent = "mackerel-plugin-haproxy"
+	client, err := p.Options.NewClient()
 	if err != nil {
 		return nil, err
 	}
-	if p.Username != "" {
-		req.SetBasicAuth(p.Username, p.Password)
-	}
-	req.Header.Set("User-Agent", "mackerel-plugin-haproxy")
 
-	resp, err := client.Do(req)
+	requestURI := p.URI + ";csv;norefresh"
+	if p.Format == "prometheus" || p.Format == "dataplane" {
+		requestURI = p.URI
+	}
+	resp, err := client.Get(requestURI)
 	if err != nil {
 		return nil, err
 	}
@@ -96,54 +278,41 @@ func (p HAProxyPlugin) fetchMetricsFromSocket() (map[string]float64, error) {
 	}
 	defer client.Close()
 
-	fmt.Fprintln(client, "show stat")
+	if p.Format == "typed" {
+		fmt.Fprintln(client, "show stat typed")
+	} else {
+		fmt.Fprintln(client, "show stat")
+	}
 
 	return p.parseStats(bufio.NewReader(client))
 }
 
 func (p HAProxyPlugin) parseStats(statsBody io.Reader) (map[string]float64, error) {
-	stat := make(map[string]float64)
-	reader := csv.NewReader(statsBody)
-
-	for {
-		columns, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
+	rows, err := readStats(statsBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no stats found (specified uri/socket may be wrong)")
+	}
 
-		if len(columns) < 60 {
-			return nil, errors.New("length of stats csv is too short (specified uri/socket may be wrong)")
-		}
+	stat := make(map[string]float64)
+	for _, row := range rows {
+		p.parseDetail(row, stat)
 
-		if columns[1] != "BACKEND" {
+		if rowType(row) != "backend" {
 			continue
 		}
-
-		var data float64
-
-		data, err = strconv.ParseFloat(columns[7], 64)
-		if err != nil {
-			return nil, errors.New("cannot get values")
-		}
-		stat["sessions"] += data
-
-		data, err = strconv.ParseFloat(columns[8], 64)
-		if err != nil {
-			return nil, errors.New("cannot get values")
+		for column, key := range map[string]string{
+			"stot": "sessions",
+			"bin":  "bytes_in",
+			"bout": "bytes_out",
+			"econ": "connection_errors",
+		} {
+			if v, ok := parseField(row, column); ok {
+				stat[key] += v
+			}
 		}
-		stat["bytes_in"] += data
-
-		data, err = strconv.ParseFloat(columns[9], 64)
-		if err != nil {
-			return nil, errors.New("cannot get values")
-		}
-		stat["bytes_out"] += data
-
-		data, err = strconv.ParseFloat(columns[13], 64)
-		if err != nil {
-			return nil, errors.New("cannot get values")
-		}
-		stat["connection_errors"] += data
 	}
 
 	return stat, nil
@@ -151,7 +320,41 @@ func (p HAProxyPlugin) parseStats(statsBody io.Reader) (map[string]float64, erro
 
 // GraphDefinition interface for mackerelplugin
 func (p HAProxyPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	if !p.ProxyMetrics && !p.ServerMetrics {
+		return graphdef
+	}
+
+	var kinds []string
+	if p.ProxyMetrics {
+		kinds = append(kinds, "frontend", "backend")
+	}
+	if p.ServerMetrics {
+		kinds = append(kinds, "server")
+	}
+
+	graphs := make(map[string]mp.Graphs, len(graphdef))
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	for _, kind := range kinds {
+		for _, m := range detailMetrics {
+			if kind == "frontend" && frontendSkipGraphs[m.graph] {
+				continue
+			}
+			key := fmt.Sprintf("haproxy.%s.%s.#", kind, m.graph)
+			g, ok := graphs[key]
+			if !ok {
+				l := detailGraphLabels[m.graph]
+				g = mp.Graphs{
+					Label: fmt.Sprintf("HAProxy %s %s", strings.ToUpper(kind[:1])+kind[1:], l.label),
+					Unit:  l.unit,
+				}
+			}
+			g.Metrics = append(g.Metrics, m.Metrics)
+			graphs[key] = g
+		}
+	}
+	return graphs
 }
 
 // Do the plugin
@@ -161,13 +364,22 @@ func Do() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "80", "Port")
 	optPath := flag.String("path", "/", "Path")
-	optUsername := flag.String("username", "", "Username for Basic Auth")
-	optPassword := flag.String("password", os.Getenv("HAPROXY_PASSWORD"), "Password for Basic Auth")
+	optUsername := flag.String("username", "", "Username for Basic Auth (alias of -user)")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optSocket := flag.String("socket", "", "Unix Domain Socket")
+	optFormat := flag.String("format", "csv", "Format of stats to request: csv, typed (socket only), prometheus (uri of the Prometheus exporter) or dataplane (uri of the native stats of Data Plane API)")
+	optProxyMetrics := flag.Bool("proxy-metrics", false, "Collect metrics of each frontend and backend")
+	optServerMetrics := flag.Bool("server-metrics", false, "Collect metrics of each server")
+	optProxyPattern := flag.String("proxy-pattern", "", "Collect only frontends, backends and servers whose proxy name matches this pattern")
+	optProxyExcludePattern := flag.String("proxy-exclude-pattern", "", "Skip frontends, backends and servers whose proxy name matches this pattern")
+	optServerPattern := flag.String("server-pattern", "", "Collect only servers whose name matches this pattern")
+	optServerExcludePattern := flag.String("server-exclude-pattern", "", "Skip servers whose name matches this pattern")
+	var haproxy HAProxyPlugin
+	haproxy.Password = os.Getenv("HAPROXY_PASSWORD")
+	haproxy.Timeout = 5 * time.Second
+	haproxy.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	var haproxy HAProxyPlugin
 	if *optURI != "" {
 		haproxy.URI = *optURI
 	} else {
@@ -175,17 +387,44 @@ func Do() {
 	}
 
 	if *optUsername != "" {
-		haproxy.Username = *optUsername
-	}
-
-	if *optPassword != "" {
-		haproxy.Password = *optPassword
+		haproxy.User = *optUsername
 	}
 
 	if *optSocket != "" {
 		haproxy.Socket = *optSocket
 	}
 
+	switch *optFormat {
+	case "csv", "typed", "prometheus", "dataplane":
+		haproxy.Format = *optFormat
+	default:
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-haproxy: invalid format: %s\n", *optFormat)
+		os.Exit(1)
+	}
+
+	haproxy.ProxyMetrics = *optProxyMetrics
+	haproxy.ServerMetrics = *optServerMetrics
+	for _, pat := range []struct {
+		name string
+		str  string
+		re   **regexp.Regexp
+	}{
+		{"proxy-pattern", *optProxyPattern, &haproxy.ProxyPattern},
+		{"proxy-exclude-pattern", *optProxyExcludePattern, &haproxy.ProxyExcludePattern},
+		{"server-pattern", *optServerPattern, &haproxy.ServerPattern},
+		{"server-exclude-pattern", *optServerExcludePattern, &haproxy.ServerExcludePattern},
+	} {
+		if pat.str == "" {
+			continue
+		}
+		re, err := regexp.Compile(pat.str)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-haproxy: invalid %s: %s\n", pat.name, err)
+			os.Exit(1)
+		}
+		*pat.re = re
+	}
+
 	helper := mp.NewMackerelPlugin(haproxy)
 	helper.Tempfile = *optTempfile
 
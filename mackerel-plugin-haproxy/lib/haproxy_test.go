@@ -3,6 +3,7 @@ package mphaproxy
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,3 +36,50 @@ hastats,BACKEND,0,0,0,1,7,17,7061,15994,0,0,,17,0,0,0,UP,0,0,0,,0,1543,0,,1,1,0,
 	assert.EqualValues(t, stat["bytes_out"], 15994)
 	assert.EqualValues(t, stat["connection_errors"], 17)
 }
+
+func TestGraphDefinitionDetail(t *testing.T) {
+	haproxy := HAProxyPlugin{ProxyMetrics: true, ServerMetrics: true}
+
+	graphdef := haproxy.GraphDefinition()
+	assert.Contains(t, graphdef, "haproxy.total.sessions")
+	assert.Contains(t, graphdef, "haproxy.frontend.responses.#")
+	assert.Contains(t, graphdef, "haproxy.backend.queue.#")
+	assert.Contains(t, graphdef, "haproxy.server.status.#")
+	assert.NotContains(t, graphdef, "haproxy.frontend.queue.#")
+	assert.Len(t, graphdef["haproxy.server.sessions.#"].Metrics, 3)
+}
+
+func TestParseDetail(t *testing.T) {
+	haproxy := HAProxyPlugin{
+		ProxyMetrics:        true,
+		ServerMetrics:       true,
+		ProxyExcludePattern: regexp.MustCompile(`^stats$`),
+	}
+	stub := `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,rate_lim,rate_max,check_status,check_code,check_duration,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other,hanafail,req_rate,req_rate_max,req_tot,cli_abrt,srv_abrt,comp_in,comp_out,comp_byp,comp_rsp,lastsess,last_chk,last_agt,qtime,ctime,rtime,ttime,
+web,FRONTEND,,,3,10,2000,120,7061,15994,0,0,1,,,,,OPEN,,,,,,,,,1,2,0,,,,0,2,0,5,,,,0,100,2,15,3,0,,2,5,120,,,0,0,0,0,,,,,,,,
+web,sock-1,,,7,10,2000,120,7061,15994,0,0,1,,,,,OPEN,,,,,,,,,1,2,0,,,,3,2,0,5,,,,0,100,2,15,3,0,,2,5,120,,,0,0,0,0,,,,,,,,
+app,app1.local,0,2,1,5,,60,3000,8000,,0,,0,1,2,0,UP 1/3,1,1,0,4,1,10,3,,1,3,1,,60,,2,1,,3,L4OK,,0,0,50,1,7,2,0,0,,,,0,0,,,,,2,,,0,1,20,40,
+app,app2.local,0,0,0,3,,60,3000,8000,,0,,0,0,0,1,MAINT,1,1,0,0,0,10,0,,1,3,2,,60,,2,0,,2,L4OK,,0,0,50,1,8,1,0,0,,,,0,0,,,,,3,,,0,1,25,45,
+app,BACKEND,1,3,1,8,200,120,6000,16000,0,0,,0,1,2,1,UP,2,2,0,,0,1543,0,,1,3,0,,120,,1,1,,5,,,,0,100,2,15,1,0,,,,,0,0,0,0,0,0,2,,,0,1,22,42,
+stats,BACKEND,0,0,0,1,7,17,7061,15994,0,0,,17,0,0,0,UP,0,0,0,,0,1543,0,,1,1,0,,0,,1,0,,1,,,,0,0,0,0,17,0,,,,,0,0,0,0,0,0,0,,,0,0,0,0,
+`
+
+	stat, err := haproxy.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, stat["haproxy.frontend.sessions.web.current"])
+	assert.EqualValues(t, 2000, stat["haproxy.frontend.sessions.web.limit"])
+	assert.EqualValues(t, 1, stat["haproxy.frontend.status.web.status"])
+	assert.EqualValues(t, 15, stat["haproxy.frontend.responses.web.4xx"])
+	assert.NotContains(t, stat, "haproxy.frontend.queue.web.current")
+	// listener rows of `option socket-stats` are neither frontends nor servers
+	assert.NotContains(t, stat, "haproxy.server.sessions.web_sock-1.current")
+	assert.EqualValues(t, 1, stat["haproxy.server.status.app_app1_local.status"])
+	assert.EqualValues(t, 2, stat["haproxy.server.status.app_app2_local.status"])
+	assert.EqualValues(t, 4, stat["haproxy.server.check_failures.app_app1_local.failed"])
+	assert.EqualValues(t, 40, stat["haproxy.server.time.app_app1_local.total"])
+	assert.EqualValues(t, 3, stat["haproxy.backend.queue.app.max"])
+	assert.EqualValues(t, 2, stat["haproxy.backend.warnings.app.retries"])
+	assert.NotContains(t, stat, "haproxy.backend.sessions.stats.current")
+	// totals are summed over all backends regardless of filters
+	assert.EqualValues(t, 17, stat["connection_errors"])
+}
@@ -0,0 +1,297 @@
+package mphaproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/promtext"
+)
+
+// statRow is a row of the stats, which maps field names of the stats CSV (pxname, svname, scur, ...) to their values.
+// See also. https://docs.haproxy.org/2.8/management.html#9.1
+type statRow map[string]string
+
+// readStats reads the stats in any of CSV, `show stat typed`, Prometheus exposition format or the native stats of Data Plane API.
+func readStats(r io.Reader) ([]statRow, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var first string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if first = strings.TrimSpace(scanner.Text()); first != "" {
+			break
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(first, "["), strings.HasPrefix(first, "{"):
+		return readDataPlaneStats(body)
+	case strings.HasPrefix(first, "# HELP"), strings.HasPrefix(first, "# TYPE"), strings.HasPrefix(first, "haproxy_"):
+		return readPrometheusStats(bytes.NewReader(body))
+	case strings.HasPrefix(first, "#") && strings.Contains(first, ","):
+		return readCSVStats(bytes.NewReader(body))
+	case typedLineRe.MatchString(first):
+		return readTypedStats(bytes.NewReader(body))
+	}
+	return nil, errors.New("unknown format of stats (specified uri/socket may be wrong)")
+}
+
+// readCSVStats reads the output of `show stat` or `;csv`.
+// Columns are looked up by the header line, as they differ between HAProxy versions.
+func readCSVStats(r io.Reader) ([]statRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	var header []string
+	var rows []statRow
+	for {
+		columns, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(columns[0], "#") {
+			header = make([]string, len(columns))
+			for i, c := range columns {
+				header[i] = strings.TrimSpace(strings.TrimPrefix(c, "#"))
+			}
+			continue
+		}
+		if header == nil {
+			return nil, errors.New("stats csv has no header line (specified uri/socket may be wrong)")
+		}
+
+		row := make(statRow, len(columns))
+		for i, c := range columns {
+			if i < len(header) && header[i] != "" {
+				row[header[i]] = c
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// e.g. "F.2.0.0.pxname.1:KNSV:str:web"
+var typedLineRe = regexp.MustCompile(`^[FBLS]\.\d+\.\d+\.\d+\.[a-z0-9_]+\.\d+:`)
+
+// maps the object types of `show stat typed` to the values of the "type" field
+var typedObjectTypes = map[string]string{
+	"F": "0",
+	"B": "1",
+	"S": "2",
+	"L": "3",
+}
+
+// readTypedStats reads the output of `show stat typed`.
+// Each line is "<type>.<proxy id>.<object id>.<field pos>.<field name>.<process>:<tags>:<value type>:<value>".
+func readTypedStats(r io.Reader) ([]statRow, error) {
+	var rows []statRow
+	index := make(map[string]statRow)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !typedLineRe.MatchString(line) {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		desc := strings.Split(parts[0], ".")
+		key := strings.Join([]string{desc[0], desc[1], desc[2], desc[5]}, ".")
+		row, ok := index[key]
+		if !ok {
+			row = make(statRow)
+			index[key] = row
+			rows = append(rows, row)
+		}
+		row[desc[4]] = parts[3]
+		if _, ok := row["type"]; !ok {
+			row["type"] = typedObjectTypes[desc[0]]
+		}
+	}
+	return rows, scanner.Err()
+}
+
+// maps metric names of the Prometheus exporter (without "haproxy_<frontend|backend|server>_") to CSV fields
+var prometheusFields = map[string]string{
+	"current_queue":                 "qcur",
+	"max_queue":                     "qmax",
+	"current_sessions":              "scur",
+	"max_sessions":                  "smax",
+	"limit_sessions":                "slim",
+	"sessions_total":                "stot",
+	"bytes_in_total":                "bin",
+	"bytes_out_total":               "bout",
+	"requests_denied_total":         "dreq",
+	"responses_denied_total":        "dresp",
+	"request_errors_total":          "ereq",
+	"connection_errors_total":       "econ",
+	"response_errors_total":         "eresp",
+	"retry_warnings_total":          "wretr",
+	"redispatch_warnings_total":     "wredis",
+	"check_failures_total":          "chkfail",
+	"check_up_down_total":           "chkdown",
+	"queue_time_average_seconds":    "qtime",
+	"connect_time_average_seconds":  "ctime",
+	"response_time_average_seconds": "rtime",
+	"total_time_average_seconds":    "ttime",
+	"status":                        "status",
+}
+
+// status values of exporters before HAProxy 2.4, which have no "state" label
+var prometheusStatusNames = []string{"DOWN", "UP", "MAINT", "DRAIN", "NOLB"}
+
+// readPrometheusStats reads the output of the Prometheus exporter (`http-request use-service prometheus-exporter`).
+func readPrometheusStats(r io.Reader) ([]statRow, error) {
+	var rows []statRow
+	index := make(map[string]statRow)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, value, err := promtext.ParseLine(line)
+		if err != nil {
+			continue
+		}
+
+		var svname, typ string
+		switch {
+		case strings.HasPrefix(name, "haproxy_frontend_"):
+			name, svname, typ = strings.TrimPrefix(name, "haproxy_frontend_"), "FRONTEND", "0"
+		case strings.HasPrefix(name, "haproxy_backend_"):
+			name, svname, typ = strings.TrimPrefix(name, "haproxy_backend_"), "BACKEND", "1"
+		case strings.HasPrefix(name, "haproxy_server_"):
+			name, svname, typ = strings.TrimPrefix(name, "haproxy_server_"), labels["server"], "2"
+		default:
+			continue
+		}
+		pxname := labels["proxy"]
+		if pxname == "" || svname == "" {
+			continue
+		}
+
+		var field string
+		switch {
+		case name == "http_responses_total":
+			field = "hrsp_" + labels["code"]
+		case name == "status":
+			field = "status"
+			if state, ok := labels["state"]; ok {
+				if v, err := strconv.ParseFloat(value, 64); err != nil || v != 1 {
+					continue
+				}
+				value = state
+			} else if v, err := strconv.Atoi(value); err == nil && v >= 0 && v < len(prometheusStatusNames) {
+				value = prometheusStatusNames[v]
+			}
+		case strings.HasSuffix(name, "_seconds"):
+			// CSV reports times in milliseconds
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			field, value = prometheusFields[name], strconv.FormatFloat(v*1000, 'f', -1, 64)
+		default:
+			field = prometheusFields[name]
+		}
+		if field == "" {
+			continue
+		}
+
+		key := pxname + "\x00" + svname
+		row, ok := index[key]
+		if !ok {
+			row = statRow{"pxname": pxname, "svname": svname, "type": typ}
+			index[key] = row
+			rows = append(rows, row)
+		}
+		row[field] = value
+	}
+	return rows, scanner.Err()
+}
+
+// dataPlaneStats is the stats of a runtime API in the response of Data Plane API.
+type dataPlaneStats struct {
+	RuntimeAPI string `json:"runtimeAPI"`
+	Error      string `json:"error"`
+	Stats      []struct {
+		Name        string         `json:"name"`
+		BackendName string         `json:"backend_name"`
+		Type        string         `json:"type"`
+		Stats       map[string]any `json:"stats"`
+	} `json:"stats"`
+}
+
+// maps the types of Data Plane API to the values of the "type" field
+var dataPlaneTypes = map[string]string{
+	"frontend": "0",
+	"backend":  "1",
+	"server":   "2",
+}
+
+// readDataPlaneStats reads the response of `/v2/services/haproxy/stats/native` (or v3) of Data Plane API.
+// The stats are keyed by the CSV fields, but the status is a string.
+func readDataPlaneStats(body []byte) ([]statRow, error) {
+	var all []dataPlaneStats
+	if err := json.Unmarshal(body, &all); err != nil {
+		// the stats of a single runtime API are also accepted
+		var one dataPlaneStats
+		if err := json.Unmarshal(body, &one); err != nil {
+			return nil, fmt.Errorf("invalid stats of Data Plane API: %w", err)
+		}
+		all = []dataPlaneStats{one}
+	}
+
+	var rows []statRow
+	for _, stats := range all {
+		if stats.Error != "" {
+			return nil, fmt.Errorf("Data Plane API failed to read stats of %s: %s", stats.RuntimeAPI, stats.Error) // nolint
+		}
+		for _, s := range stats.Stats {
+			typ, ok := dataPlaneTypes[s.Type]
+			if !ok {
+				continue
+			}
+			row := statRow{"pxname": s.Name, "type": typ}
+			switch s.Type {
+			case "frontend":
+				row["svname"] = "FRONTEND"
+			case "backend":
+				row["svname"] = "BACKEND"
+			case "server":
+				row["pxname"], row["svname"] = s.BackendName, s.Name
+			}
+			for k, v := range s.Stats {
+				switch v := v.(type) {
+				case float64:
+					row[k] = strconv.FormatFloat(v, 'f', -1, 64)
+				case string:
+					row[k] = v
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
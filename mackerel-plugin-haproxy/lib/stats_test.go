@@ -0,0 +1,143 @@
+package mphaproxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCSVStatsByHeader(t *testing.T) {
+	// columns are reordered and rows are shorter than the header
+	stub := `# svname,pxname,bin,stot,bout,econ,status
+FRONTEND,web,100,10,200,,OPEN
+BACKEND,app,300,5,400,2,UP
+BACKEND,api,,
+`
+
+	rows, err := readStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, "app", rows[1]["pxname"])
+	assert.Equal(t, "BACKEND", rows[1]["svname"])
+	assert.Equal(t, "300", rows[1]["bin"])
+
+	var haproxy HAProxyPlugin
+	stat, err := haproxy.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 5, stat["sessions"])
+	assert.EqualValues(t, 300, stat["bytes_in"])
+	assert.EqualValues(t, 400, stat["bytes_out"])
+	assert.EqualValues(t, 2, stat["connection_errors"])
+}
+
+func TestReadStatsUnknown(t *testing.T) {
+	_, err := readStats(bytes.NewBufferString("<html>Not Found</html>"))
+	assert.NotNil(t, err)
+
+	var haproxy HAProxyPlugin
+	_, err = haproxy.parseStats(bytes.NewBufferString(""))
+	assert.NotNil(t, err)
+}
+
+func TestReadTypedStats(t *testing.T) {
+	stub := `F.2.0.0.pxname.1:KNSV:str:web
+F.2.0.1.svname.1:KNSV:str:FRONTEND
+F.2.0.4.scur.1:MGP:u32:3
+F.2.0.17.status.1:SGP:str:OPEN
+
+L.2.1.0.pxname.1:KNSV:str:web
+L.2.1.1.svname.1:KNSV:str:sock-1
+L.2.1.4.scur.1:MGP:u32:2
+L.2.1.17.status.1:SGP:str:OPEN
+
+B.3.0.0.pxname.1:KNSV:str:app
+B.3.0.1.svname.1:KNSV:str:BACKEND
+B.3.0.7.stot.1:MCP:u64:120
+B.3.0.8.bin.1:MCP:u64:6000
+B.3.0.9.bout.1:MCP:u64:16000
+B.3.0.13.econ.1:MCP:u64:1
+B.3.0.17.status.1:SGP:str:UP
+
+S.3.1.0.pxname.1:KNSV:str:app
+S.3.1.1.svname.1:KNSV:str:app1
+S.3.1.17.status.1:SGP:str:DOWN
+S.3.1.21.chkfail.1:MCP:u64:4
+`
+
+	haproxy := HAProxyPlugin{ProxyMetrics: true, ServerMetrics: true}
+	stat, err := haproxy.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 120, stat["sessions"])
+	assert.EqualValues(t, 6000, stat["bytes_in"])
+	assert.EqualValues(t, 1, stat["connection_errors"])
+	assert.EqualValues(t, 3, stat["haproxy.frontend.sessions.web.current"])
+	assert.EqualValues(t, 1, stat["haproxy.backend.status.app.status"])
+	assert.EqualValues(t, 0, stat["haproxy.server.status.app_app1.status"])
+	assert.EqualValues(t, 4, stat["haproxy.server.check_failures.app_app1.failed"])
+	assert.NotContains(t, stat, "haproxy.server.sessions.web_sock-1.current")
+}
+
+func TestReadPrometheusStats(t *testing.T) {
+	stub := `# HELP haproxy_process_nbthread Number of started threads (global.nbthread)
+# TYPE haproxy_process_nbthread gauge
+haproxy_process_nbthread 4
+# HELP haproxy_frontend_current_sessions Number of current sessions on the frontend, backend or server
+# TYPE haproxy_frontend_current_sessions gauge
+haproxy_frontend_current_sessions{proxy="web"} 3
+haproxy_frontend_http_responses_total{proxy="web",code="2xx"} 100
+haproxy_frontend_http_responses_total{proxy="web",code="5xx"} 2
+haproxy_backend_status{proxy="app",state="DOWN"} 0
+haproxy_backend_status{proxy="app",state="UP"} 1
+haproxy_backend_sessions_total{proxy="app"} 120
+haproxy_backend_bytes_in_total{proxy="app"} 6000
+haproxy_backend_connection_errors_total{proxy="app"} 1
+haproxy_backend_total_time_average_seconds{proxy="app"} 0.042
+haproxy_server_status{proxy="app",server="app1"} 2
+haproxy_server_check_failures_total{proxy="app",server="app1"} 4
+`
+
+	haproxy := HAProxyPlugin{ProxyMetrics: true, ServerMetrics: true}
+	stat, err := haproxy.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 120, stat["sessions"])
+	assert.EqualValues(t, 6000, stat["bytes_in"])
+	assert.EqualValues(t, 1, stat["connection_errors"])
+	assert.EqualValues(t, 3, stat["haproxy.frontend.sessions.web.current"])
+	assert.EqualValues(t, 100, stat["haproxy.frontend.responses.web.2xx"])
+	assert.EqualValues(t, 2, stat["haproxy.frontend.responses.web.5xx"])
+	assert.EqualValues(t, 1, stat["haproxy.backend.status.app.status"])
+	assert.EqualValues(t, 42, stat["haproxy.backend.time.app.total"])
+	assert.EqualValues(t, 2, stat["haproxy.server.status.app_app1.status"])
+	assert.EqualValues(t, 4, stat["haproxy.server.check_failures.app_app1.failed"])
+}
+
+func TestReadDataPlaneStats(t *testing.T) {
+	stub := `[
+  {
+    "runtimeAPI": "/var/run/haproxy.sock",
+    "stats": [
+      {"name": "web", "type": "frontend", "stats": {"scur": 3, "stot": 40, "hrsp_2xx": 100, "hrsp_5xx": 2, "status": "OPEN"}},
+      {"name": "app", "type": "backend", "stats": {"stot": 120, "bin": 6000, "bout": 9000, "econ": 1, "ttime": 42, "status": "UP"}},
+      {"name": "app1", "backend_name": "app", "type": "server", "stats": {"stot": 120, "chkfail": 4, "status": "MAINT"}}
+    ]
+  }
+]`
+
+	haproxy := HAProxyPlugin{ProxyMetrics: true, ServerMetrics: true}
+	stat, err := haproxy.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 120, stat["sessions"])
+	assert.EqualValues(t, 6000, stat["bytes_in"])
+	assert.EqualValues(t, 9000, stat["bytes_out"])
+	assert.EqualValues(t, 1, stat["connection_errors"])
+	assert.EqualValues(t, 3, stat["haproxy.frontend.sessions.web.current"])
+	assert.EqualValues(t, 100, stat["haproxy.frontend.responses.web.2xx"])
+	assert.EqualValues(t, 1, stat["haproxy.frontend.status.web.status"])
+	assert.EqualValues(t, 42, stat["haproxy.backend.time.app.total"])
+	assert.EqualValues(t, 2, stat["haproxy.server.status.app_app1.status"])
+	assert.EqualValues(t, 4, stat["haproxy.server.check_failures.app_app1.failed"])
+
+	_, err = readStats(bytes.NewBufferString(`[{"runtimeAPI": "/var/run/haproxy.sock", "error": "connection refused"}]`))
+	assert.NotNil(t, err)
+}
@@ -1,13 +1,20 @@
 package mpjmxjolokia
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"maps"
+	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 )
 
 var logger = logging.GetLogger("metrics.plugin.jmx-jolokia")
@@ -16,6 +23,13 @@ var logger = logging.GetLogger("metrics.plugin.jmx-jolokia")
 type JmxJolokiaPlugin struct {
 	Target   string
 	Tempfile string
+	// MBeans are collected in addition to the JVM metrics
+	MBeans []MBean
+	// JMXTarget is the JMX service URL of the JVM to read via the Jolokia agent in the proxy mode
+	JMXTarget   string
+	JMXUser     string
+	JMXPassword string
+	httpclient.Options
 }
 
 // JmxJolokiaResponse response for Jolokia
@@ -76,123 +90,274 @@ var graphdef = map[string]mp.Graphs{
 	},
 }
 
-// FetchMetrics interface for mackerelplugin
-func (j JmxJolokiaPlugin) FetchMetrics() (map[string]any, error) {
-	stat := make(map[string]any)
-	if err := j.fetchMemory(stat); err != nil {
-		logger.Warningf(err.Error())
-	}
+// builtinMBeans are the MBeans of the graphs in graphdef.
+var builtinMBeans = []struct {
+	mbean string
+	set   func(stat map[string]any, value map[string]any)
+}{
+	{"java.lang:type=Memory", setMemory},
+	{"java.lang:type=ClassLoading", setClassLoad},
+	{"java.lang:type=Threading", setThread},
+	{"java.lang:type=OperatingSystem", setOperatingSystem},
+}
 
-	if err := j.fetchClassLoad(stat); err != nil {
-		logger.Warningf(err.Error())
-	}
+// jvmMBeans are the MBeans of the JVM posted as wildcard graphs, one per collector or pool.
+var jvmMBeans = []MBean{
+	{
+		Name:       "gc_count",
+		Label:      "Jmx GarbageCollector count",
+		Unit:       "integer",
+		MBean:      "java.lang:type=GarbageCollector,*",
+		Attributes: []Attribute{{Attribute: "CollectionCount", Name: "count", Diff: true}},
+	},
+	{
+		Name:       "gc_time",
+		Label:      "Jmx GarbageCollector time (ms)",
+		Unit:       "integer",
+		MBean:      "java.lang:type=GarbageCollector,*",
+		Attributes: []Attribute{{Attribute: "CollectionTime", Name: "time", Diff: true}},
+	},
+	{
+		Name:  "memory_pool",
+		Label: "Jmx MemoryPool",
+		Unit:  "bytes",
+		MBean: "java.lang:type=MemoryPool,*",
+		Attributes: []Attribute{
+			// the pools without the limit, e.g. Metaspace, report -1 for init and max
+			{Attribute: "Usage", Path: "init", Name: "init", undefinedIfNegative: true},
+			{Attribute: "Usage", Path: "committed", Name: "committed"},
+			{Attribute: "Usage", Path: "max", Name: "max", undefinedIfNegative: true},
+			{Attribute: "Usage", Path: "used", Name: "used"},
+			// only the heap pools have the usage after the latest GC
+			{Attribute: "CollectionUsage", Path: "used", Name: "after_gc_used", Label: "used after GC"},
+		},
+	},
+	{
+		Name:       "buffer_pool_count",
+		Label:      "Jmx BufferPool count",
+		Unit:       "integer",
+		MBean:      "java.nio:type=BufferPool,*",
+		Attributes: []Attribute{{Attribute: "Count", Name: "count"}},
+	},
+	{
+		Name:  "buffer_pool_memory",
+		Label: "Jmx BufferPool memory",
+		Unit:  "bytes",
+		MBean: "java.nio:type=BufferPool,*",
+		Attributes: []Attribute{
+			{Attribute: "TotalCapacity", Name: "capacity"},
+			{Attribute: "MemoryUsed", Name: "used"},
+		},
+	},
+}
 
-	if err := j.fetchThread(stat); err != nil {
-		logger.Warningf(err.Error())
-	}
+// jolokiaRequest is a read request in the bulk request.
+type jolokiaRequest struct {
+	Type      string         `json:"type"`
+	MBean     string         `json:"mbean"`
+	Attribute []string       `json:"attribute,omitempty"`
+	Target    *jolokiaTarget `json:"target,omitempty"`
+}
 
-	if err := j.fetchOperatingSystem(stat); err != nil {
-		logger.Warningf(err.Error())
-	}
+// jolokiaTarget is the target JVM of a request in the proxy mode.
+type jolokiaTarget struct {
+	URL      string `json:"url"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+}
 
-	return stat, nil
+func (j JmxJolokiaPlugin) mbeans() []MBean {
+	return slices.Concat(jvmMBeans, j.MBeans)
 }
 
-func (j JmxJolokiaPlugin) fetchMemory(stat map[string]any) error {
-	resp, err := j.executeGetRequest("java.lang:type=Memory")
+// FetchMetrics interface for mackerelplugin
+func (j JmxJolokiaPlugin) FetchMetrics() (map[string]any, error) {
+	var r readRequests
+	for _, b := range builtinMBeans {
+		r.add(b.mbean, nil, b.set)
+	}
+	for _, b := range j.mbeans() {
+		r.add(b.MBean, b.attributes(), b.setMetrics)
+	}
+	if j.JMXTarget != "" {
+		target := &jolokiaTarget{URL: j.JMXTarget, User: j.JMXUser, Password: j.JMXPassword}
+		for i := range r.reqs {
+			r.reqs[i].Target = target
+		}
+	}
+	resps, err := j.executeBulkRequest(r.reqs)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	heap := resp.Value["HeapMemoryUsage"].(map[string]any)
-	stat["HeapMemoryInit"] = heap["init"]
-	stat["HeapMemoryCommitted"] = heap["committed"]
-	stat["HeapMemoryMax"] = heap["max"]
-	stat["HeapMemoryUsed"] = heap["used"]
 
-	nonHeap := resp.Value["NonHeapMemoryUsage"].(map[string]any)
-	stat["NonHeapMemoryInit"] = nonHeap["init"]
-	stat["NonHeapMemoryCommitted"] = nonHeap["committed"]
-	stat["NonHeapMemoryMax"] = nonHeap["max"]
-	stat["NonHeapMemoryUsed"] = nonHeap["used"]
+	stat := make(map[string]any)
+	for i, resp := range resps {
+		if resp.Status != http.StatusOK {
+			logger.Warningf("%s: %s", r.reqs[i].MBean, resp.Error)
+			continue
+		}
+		for _, set := range r.sets[i] {
+			set(stat, resp.Value)
+		}
+	}
+	return stat, nil
+}
 
-	return nil
+// readRequests merges the reads of the same MBean into one request, such as GarbageCollector of gc_count and gc_time.
+type readRequests struct {
+	reqs []jolokiaRequest
+	sets [][]func(stat map[string]any, value map[string]any)
 }
 
-func (j JmxJolokiaPlugin) fetchClassLoad(stat map[string]any) error {
-	resp, err := j.executeGetRequest("java.lang:type=ClassLoading")
-	if err != nil {
-		return err
+// add adds a read of the attributes of mbean, or all the attributes if attrs is nil.
+func (r *readRequests) add(mbean string, attrs []string, set func(stat map[string]any, value map[string]any)) {
+	i := slices.IndexFunc(r.reqs, func(req jolokiaRequest) bool { return req.MBean == mbean })
+	switch {
+	case i < 0:
+		i = len(r.reqs)
+		r.reqs = append(r.reqs, jolokiaRequest{Type: "read", MBean: mbean, Attribute: attrs})
+		r.sets = append(r.sets, nil)
+	case attrs == nil:
+		r.reqs[i].Attribute = nil
+	case r.reqs[i].Attribute != nil:
+		for _, a := range attrs {
+			if !slices.Contains(r.reqs[i].Attribute, a) {
+				r.reqs[i].Attribute = append(r.reqs[i].Attribute, a)
+			}
+		}
 	}
-	stat["LoadedClassCount"] = resp.Value["LoadedClassCount"]
-	stat["UnloadedClassCount"] = resp.Value["UnloadedClassCount"]
-	stat["TotalLoadedClassCount"] = resp.Value["TotalLoadedClassCount"]
-
-	return nil
+	r.sets[i] = append(r.sets[i], set)
 }
 
-func (j JmxJolokiaPlugin) fetchThread(stat map[string]any) error {
-	resp, err := j.executeGetRequest("java.lang:type=Threading")
-	if err != nil {
-		return err
+func setMemory(stat map[string]any, value map[string]any) {
+	if heap, ok := value["HeapMemoryUsage"].(map[string]any); ok {
+		stat["HeapMemoryInit"] = heap["init"]
+		stat["HeapMemoryCommitted"] = heap["committed"]
+		stat["HeapMemoryMax"] = heap["max"]
+		stat["HeapMemoryUsed"] = heap["used"]
 	}
-	stat["ThreadCount"] = resp.Value["ThreadCount"]
-	stat["DaemonThreadCount"] = resp.Value["DaemonThreadCount"]
-	stat["PeakThreadCount"] = resp.Value["PeakThreadCount"]
 
-	return nil
+	if nonHeap, ok := value["NonHeapMemoryUsage"].(map[string]any); ok {
+		stat["NonHeapMemoryInit"] = nonHeap["init"]
+		stat["NonHeapMemoryCommitted"] = nonHeap["committed"]
+		stat["NonHeapMemoryMax"] = nonHeap["max"]
+		stat["NonHeapMemoryUsed"] = nonHeap["used"]
+	}
 }
 
-func (j JmxJolokiaPlugin) fetchOperatingSystem(stat map[string]any) error {
-	resp, err := j.executeGetRequest("java.lang:type=OperatingSystem")
-	if err != nil {
-		return err
-	}
-	stat["ProcessCpuLoad"] = resp.Value["ProcessCpuLoad"]
-	stat["SystemCpuLoad"] = resp.Value["SystemCpuLoad"]
+func setClassLoad(stat map[string]any, value map[string]any) {
+	stat["LoadedClassCount"] = value["LoadedClassCount"]
+	stat["UnloadedClassCount"] = value["UnloadedClassCount"]
+	stat["TotalLoadedClassCount"] = value["TotalLoadedClassCount"]
+}
+
+func setThread(stat map[string]any, value map[string]any) {
+	stat["ThreadCount"] = value["ThreadCount"]
+	stat["DaemonThreadCount"] = value["DaemonThreadCount"]
+	stat["PeakThreadCount"] = value["PeakThreadCount"]
+}
 
-	return nil
+func setOperatingSystem(stat map[string]any, value map[string]any) {
+	stat["ProcessCpuLoad"] = value["ProcessCpuLoad"]
+	stat["SystemCpuLoad"] = value["SystemCpuLoad"]
 }
 
-func (j JmxJolokiaPlugin) executeGetRequest(mbean string) (*JmxJolokiaResponse, error) {
-	req, err := http.NewRequest(http.MethodGet, j.Target+mbean, nil)
+// executeBulkRequest sends all the requests at once, and returns the responses in the same order.
+// See also. https://jolokia.org/reference/html/manual/jolokia_protocol.html
+func (j JmxJolokiaPlugin) executeBulkRequest(reqs []jolokiaRequest) ([]JmxJolokiaResponse, error) {
+	j.UserAgent = "mackerel-plugin-jmx-jolokia"
+	client, err := j.Options.NewClient()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "mackerel-plugin-jmx-jolokia")
 
-	resp, err := http.DefaultClient.Do(req)
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+	req, err := client.NewRequest(http.MethodPost, j.Target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	var respJ JmxJolokiaResponse
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", j.Target, resp.Status)
+	}
+	var respJ []JmxJolokiaResponse
 	dec := json.NewDecoder(resp.Body)
 	if err := dec.Decode(&respJ); err != nil {
 		return nil, err
 	}
-	return &respJ, nil
+	if len(respJ) != len(reqs) {
+		return nil, fmt.Errorf("%s: %d responses to %d requests", j.Target, len(respJ), len(reqs))
+	}
+	return respJ, nil
 }
 
 // GraphDefinition interface for mackerelplugin
 func (j JmxJolokiaPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	graphs := maps.Clone(graphdef)
+	for _, b := range j.mbeans() {
+		graphs[b.graphKey()] = b.graph()
+	}
+	return graphs
+}
+
+// tempfileBasename returns the basename of the tempfile, which contains the target in the proxy mode
+// since a proxy agent reads many targets.
+func tempfileBasename(host, port, jmxTarget string) string {
+	basename := fmt.Sprintf("mackerel-plugin-jmx-jolokia-%s-%s", host, port)
+	if jmxTarget != "" {
+		// the JMX service URL contains "/" and ":"
+		basename += "-" + normalizeName(jmxTarget)
+	}
+	return basename
 }
 
 // Do the plugin
 func Do() {
+	optScheme := flag.String("scheme", "http", "Scheme of the Jolokia agent, http or https")
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "8778", "Port")
+	optPath := flag.String("path", "/jolokia/", "URL path of the Jolokia agent")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optConfig := flag.String("config", "", "JSON file of the MBeans to collect in addition to the JVM metrics")
+	var jmxJolokia JmxJolokiaPlugin
+	flag.StringVar(&jmxJolokia.JMXTarget, "jmx-target", "", "JMX service URL of the target JVM in the proxy mode (e.g. service:jmx:rmi:///jndi/rmi://host:9999/jmxrmi)")
+	flag.StringVar(&jmxJolokia.JMXUser, "jmx-user", "", "JMX user of the target JVM in the proxy mode")
+	flag.StringVar(&jmxJolokia.JMXPassword, "jmx-password", os.Getenv("JMX_PASSWORD"), "JMX password of the target JVM in the proxy mode")
+	jmxJolokia.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	var jmxJolokia JmxJolokiaPlugin
-	jmxJolokia.Target = fmt.Sprintf("http://%s:%s/jolokia/read/", *optHost, *optPort)
+	if *optScheme != "http" && *optScheme != "https" {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-jmx-jolokia: invalid scheme: %s\n", *optScheme)
+		os.Exit(1)
+	}
+	// the bulk request is posted to the base URL of the agent
+	path := "/" + strings.Trim(*optPath, "/") + "/"
+	if path == "//" {
+		path = "/"
+	}
+	jmxJolokia.Target = fmt.Sprintf("%s://%s%s", *optScheme, net.JoinHostPort(*optHost, *optPort), path)
+	if *optConfig != "" {
+		mbeans, err := loadMBeans(*optConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-jmx-jolokia: %s\n", err)
+			os.Exit(1)
+		}
+		jmxJolokia.MBeans = mbeans
+	}
 
 	helper := mp.NewMackerelPlugin(jmxJolokia)
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-jmx-jolokia-%s-%s", *optHost, *optPort))
+		helper.SetTempfileByBasename(tempfileBasename(*optHost, *optPort, jmxJolokia.JMXTarget))
 	}
 	helper.Run()
 }
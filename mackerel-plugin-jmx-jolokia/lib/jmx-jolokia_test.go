@@ -0,0 +1,262 @@
+package mpjmxjolokia
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var kafkaTopics = MBean{
+	Name:  "kafka_topic",
+	Label: "Kafka BrokerTopicMetrics",
+	Unit:  "integer",
+	MBean: "kafka.server:type=BrokerTopicMetrics,*",
+	Attributes: []Attribute{
+		{Attribute: "Count", Diff: true},
+		{Attribute: "OneMinuteRate", Label: "1 min rate"},
+	},
+}
+
+var appHeap = MBean{
+	Name:  "app_heap",
+	MBean: "java.lang:type=Memory",
+	Attributes: []Attribute{
+		{Attribute: "HeapMemoryUsage", Path: "used"},
+		{Attribute: "ObjectPendingFinalizationCount", Name: "pending"},
+		{Attribute: "Verbose"},
+	},
+}
+
+const bulkResponse = `[
+  {"request":{"mbean":"java.lang:type=Memory","type":"read"},"status":200,"timestamp":1455079714,
+   "value":{"HeapMemoryUsage":{"init":1073741824,"committed":1069023232,"max":1069023232,"used":994632048},
+            "NonHeapMemoryUsage":{"init":2555904,"committed":44040192,"max":1350565888,"used":43070016},
+            "ObjectPendingFinalizationCount":0,"Verbose":true}},
+  {"request":{"mbean":"java.lang:type=ClassLoading","type":"read"},"status":200,"timestamp":1455079714,
+   "value":{"LoadedClassCount":8000,"UnloadedClassCount":10,"TotalLoadedClassCount":8010}},
+  {"request":{"mbean":"java.lang:type=Threading","type":"read"},"status":200,"timestamp":1455079714,
+   "value":{"ThreadCount":30,"DaemonThreadCount":20,"PeakThreadCount":35}},
+  {"request":{"mbean":"java.lang:type=OperatingSystem","type":"read"},"status":404,"timestamp":1455079714,
+   "error_type":"javax.management.InstanceNotFoundException","error":"javax.management.InstanceNotFoundException : java.lang:type=OperatingSystem"},
+  {"request":{"mbean":"java.lang:type=GarbageCollector,*","type":"read"},"status":200,"timestamp":1455079714,
+   "value":{
+     "java.lang:name=G1 Young Generation,type=GarbageCollector":{"CollectionCount":120,"CollectionTime":1500},
+     "java.lang:name=G1 Old Generation,type=GarbageCollector":{"CollectionCount":0,"CollectionTime":0}}},
+  {"request":{"mbean":"java.lang:type=MemoryPool,*","type":"read"},"status":200,"timestamp":1455079714,
+   "value":{
+     "java.lang:name=G1 Old Gen,type=MemoryPool":{"Usage":{"init":1006632960,"committed":1006632960,"max":1073741824,"used":800000000},
+                                                  "CollectionUsage":{"init":1006632960,"committed":1006632960,"max":1073741824,"used":700000000}},
+     "java.lang:name=Metaspace,type=MemoryPool":{"Usage":{"init":0,"committed":40000000,"max":-1,"used":38000000},"CollectionUsage":null},
+     "java.lang:name=CodeHeap 'non-nmethods',type=MemoryPool":{"Usage":{"init":-1,"committed":2555904,"max":-1,"used":1300000},"CollectionUsage":null}}},
+  {"request":{"mbean":"java.nio:type=BufferPool,*","type":"read"},"status":200,"timestamp":1455079714,
+   "value":{"java.nio:name=direct,type=BufferPool":{"Count":12,"TotalCapacity":65536,"MemoryUsed":65536}}},
+  {"request":{"mbean":"kafka.server:type=BrokerTopicMetrics,*","type":"read"},"status":200,"timestamp":1455079714,
+   "value":{
+     "kafka.server:name=BytesInPerSec,type=BrokerTopicMetrics":{"Count":1000,"OneMinuteRate":1.5},
+     "kafka.server:name=BytesInPerSec,topic=orders,type=BrokerTopicMetrics":{"Count":600,"OneMinuteRate":0.5}}}
+]`
+
+func TestFetchMetrics(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/jolokia/", r.URL.Path)
+		var reqs []jolokiaRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&reqs))
+		assert.Equal(t, []jolokiaRequest{
+			{Type: "read", MBean: "java.lang:type=Memory"},
+			{Type: "read", MBean: "java.lang:type=ClassLoading"},
+			{Type: "read", MBean: "java.lang:type=Threading"},
+			{Type: "read", MBean: "java.lang:type=OperatingSystem"},
+			// the reads of the same MBean are merged
+			{Type: "read", MBean: "java.lang:type=GarbageCollector,*", Attribute: []string{"CollectionCount", "CollectionTime"}},
+			{Type: "read", MBean: "java.lang:type=MemoryPool,*", Attribute: []string{"Usage", "CollectionUsage"}},
+			{Type: "read", MBean: "java.nio:type=BufferPool,*", Attribute: []string{"Count", "TotalCapacity", "MemoryUsed"}},
+			{Type: "read", MBean: "kafka.server:type=BrokerTopicMetrics,*", Attribute: []string{"Count", "OneMinuteRate"}},
+		}, reqs)
+		w.Write([]byte(bulkResponse))
+	}))
+	defer ts.Close()
+
+	j := JmxJolokiaPlugin{
+		Target: ts.URL + "/jolokia/",
+		MBeans: []MBean{kafkaTopics, appHeap},
+	}
+	stat, err := j.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, map[string]any{
+		"HeapMemoryInit":         1073741824.0,
+		"HeapMemoryCommitted":    1069023232.0,
+		"HeapMemoryMax":          1069023232.0,
+		"HeapMemoryUsed":         994632048.0,
+		"NonHeapMemoryInit":      2555904.0,
+		"NonHeapMemoryCommitted": 44040192.0,
+		"NonHeapMemoryMax":       1350565888.0,
+		"NonHeapMemoryUsed":      43070016.0,
+		"LoadedClassCount":       8000.0,
+		"UnloadedClassCount":     10.0,
+		"TotalLoadedClassCount":  8010.0,
+		"ThreadCount":            30.0,
+		"DaemonThreadCount":      20.0,
+		"PeakThreadCount":        35.0,
+
+		"jmx.jolokia.gc_count.G1_Young_Generation.count":            120.0,
+		"jmx.jolokia.gc_count.G1_Old_Generation.count":              0.0,
+		"jmx.jolokia.gc_time.G1_Young_Generation.time":              1500.0,
+		"jmx.jolokia.gc_time.G1_Old_Generation.time":                0.0,
+		"jmx.jolokia.memory_pool.G1_Old_Gen.init":                   1006632960.0,
+		"jmx.jolokia.memory_pool.G1_Old_Gen.committed":              1006632960.0,
+		"jmx.jolokia.memory_pool.G1_Old_Gen.max":                    1073741824.0,
+		"jmx.jolokia.memory_pool.G1_Old_Gen.used":                   800000000.0,
+		"jmx.jolokia.memory_pool.G1_Old_Gen.after_gc_used":          700000000.0,
+		"jmx.jolokia.memory_pool.Metaspace.init":                    0.0,
+		"jmx.jolokia.memory_pool.Metaspace.committed":               40000000.0,
+		"jmx.jolokia.memory_pool.Metaspace.used":                    38000000.0,
+		"jmx.jolokia.memory_pool.CodeHeap__non-nmethods_.committed": 2555904.0,
+		"jmx.jolokia.memory_pool.CodeHeap__non-nmethods_.used":      1300000.0,
+		"jmx.jolokia.buffer_pool_count.direct.count":                12.0,
+		"jmx.jolokia.buffer_pool_memory.direct.capacity":            65536.0,
+		"jmx.jolokia.buffer_pool_memory.direct.used":                65536.0,
+
+		"jmx.jolokia.kafka_topic.BytesInPerSec.Count":                1000.0,
+		"jmx.jolokia.kafka_topic.BytesInPerSec.OneMinuteRate":        1.5,
+		"jmx.jolokia.kafka_topic.BytesInPerSec_orders.Count":         600.0,
+		"jmx.jolokia.kafka_topic.BytesInPerSec_orders.OneMinuteRate": 0.5,
+
+		"HeapMemoryUsage_used": 994632048.0,
+		"pending":              0.0,
+		"Verbose":              1.0,
+	}, stat)
+
+	graphs := j.GraphDefinition()
+	assert.Contains(t, graphs, "jmx.jolokia.thread")
+	assert.Contains(t, graphs, "jmx.jolokia.memory_pool.#")
+	assert.Contains(t, graphs, "jmx.jolokia.kafka_topic.#")
+	assert.Contains(t, graphs, "jmx.jolokia.app_heap")
+	assert.Equal(t, "1 min rate", graphs["jmx.jolokia.kafka_topic.#"].Metrics[1].Label)
+	assert.Equal(t, "float", graphs["jmx.jolokia.app_heap"].Unit)
+	assert.NotContains(t, graphdef, "jmx.jolokia.app_heap")
+}
+
+func TestFetchMetricsProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "agent", user)
+		assert.Equal(t, "secret", password)
+		var reqs []jolokiaRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&reqs))
+		for _, req := range reqs {
+			assert.Equal(t, &jolokiaTarget{URL: "service:jmx:rmi:///jndi/rmi://app:9999/jmxrmi", User: "jmx", Password: "jmxsecret"}, req.Target, req.MBean)
+		}
+		w.Write([]byte(bulkResponse))
+	}))
+	defer ts.Close()
+
+	j := JmxJolokiaPlugin{
+		Target:      ts.URL + "/jolokia/",
+		MBeans:      []MBean{kafkaTopics, appHeap},
+		JMXTarget:   "service:jmx:rmi:///jndi/rmi://app:9999/jmxrmi",
+		JMXUser:     "jmx",
+		JMXPassword: "jmxsecret",
+	}
+	j.User = "agent"
+	j.Password = "secret"
+	stat, err := j.FetchMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 30.0, stat["ThreadCount"])
+}
+
+func TestFetchMetricsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	j := JmxJolokiaPlugin{Target: ts.URL + "/jolokia/"}
+	_, err := j.FetchMetrics()
+	assert.Error(t, err)
+}
+
+func TestInstanceName(t *testing.T) {
+	tests := []struct {
+		mbean      MBean
+		objectName string
+		want       string
+	}{
+		{kafkaTopics, "kafka.server:name=BytesInPerSec,topic=orders,type=BrokerTopicMetrics", "BytesInPerSec_orders"},
+		{MBean{MBean: "Catalina:type=GlobalRequestProcessor,name=*"}, `Catalina:name="http-nio-8080",type=GlobalRequestProcessor`, "http-nio-8080"},
+		{MBean{MBean: "kafka.server:type=BrokerTopicMetrics,*", Instance: []string{"topic"}}, "kafka.server:name=BytesInPerSec,topic=orders,type=BrokerTopicMetrics", "orders"},
+		{MBean{MBean: "org.apache.cassandra.metrics:type=Table,keyspace=ks1,*"}, "org.apache.cassandra.metrics:keyspace=ks1,name=ReadLatency,scope=users.v2,type=Table", "ReadLatency_users_v2"},
+	}
+	for _, tt := range tests {
+		name, err := tt.mbean.instanceName(tt.objectName)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, name, tt.objectName)
+	}
+
+	_, err := MBean{MBean: "kafka.server:type=BrokerTopicMetrics,*"}.instanceName("kafka.server:type=BrokerTopicMetrics")
+	assert.Error(t, err)
+}
+
+func TestLoadMBeans(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mbeans.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+  "mbeans": [
+    {
+      "name": "kafka_topic",
+      "label": "Kafka BrokerTopicMetrics",
+      "unit": "integer",
+      "mbean": "kafka.server:type=BrokerTopicMetrics,*",
+      "attributes": [
+        {"attribute": "Count", "diff": true},
+        {"attribute": "OneMinuteRate", "label": "1 min rate"}
+      ]
+    }
+  ]
+}`), 0644))
+	mbeans, err := loadMBeans(file)
+	require.NoError(t, err)
+	assert.Equal(t, []MBean{kafkaTopics}, mbeans)
+
+	tests := []struct {
+		name   string
+		mbeans []MBean
+	}{
+		{"invalid graph name", []MBean{{Name: "kafka.topic", MBean: "kafka.server:type=BrokerTopicMetrics,*", Attributes: []Attribute{{Attribute: "Count"}}}}},
+		{"jvm graph name", []MBean{{Name: "gc_count", MBean: "java.lang:type=GarbageCollector,*", Attributes: []Attribute{{Attribute: "CollectionCount"}}}}},
+		{"builtin graph name", []MBean{{Name: "thread", MBean: "java.lang:type=Threading", Attributes: []Attribute{{Attribute: "ThreadCount", Name: "threads"}}}}},
+		{"no attributes", []MBean{{Name: "kafka", MBean: "kafka.server:type=BrokerTopicMetrics,*"}}},
+		{"builtin metric name", []MBean{{Name: "threads", MBean: "java.lang:type=Threading", Attributes: []Attribute{{Attribute: "ThreadCount"}}}}},
+		{"prefix in wildcard graph", []MBean{{Name: "kafka", MBean: "kafka.server:type=BrokerTopicMetrics,*", Attributes: []Attribute{{Attribute: "Count"}, {Attribute: "CountRate"}}}}},
+		{"duplicate metric name", []MBean{
+			{Name: "a", MBean: "a:type=A", Attributes: []Attribute{{Attribute: "Count"}}},
+			{Name: "b", MBean: "b:type=B", Attributes: []Attribute{{Attribute: "Count"}}},
+		}},
+	}
+	for _, tt := range tests {
+		assert.Error(t, validateMBeans(tt.mbeans), tt.name)
+	}
+}
+
+func TestTempfileBasename(t *testing.T) {
+	assert.Equal(t, "mackerel-plugin-jmx-jolokia-localhost-8778", tempfileBasename("localhost", "8778", ""))
+
+	helper := mp.NewMackerelPlugin(JmxJolokiaPlugin{})
+	helper.SetTempfileByBasename("mackerel-plugin-jmx-jolokia")
+	dir := filepath.Dir(helper.Tempfile)
+
+	// the tempfile of the proxy mode is in the same directory
+	helper.SetTempfileByBasename(tempfileBasename("localhost", "8778", "service:jmx:rmi:///jndi/rmi://app:9999/jmxrmi"))
+	assert.Equal(t, dir, filepath.Dir(helper.Tempfile))
+	assert.Equal(t, "mackerel-plugin-jmx-jolokia-localhost-8778-service_jmx_rmi____jndi_rmi___app_9999_jmxrmi", filepath.Base(helper.Tempfile))
+}
@@ -0,0 +1,305 @@
+package mpjmxjolokia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// MBean is a graph of the attributes of an MBean, or of the MBeans matching an ObjectName pattern.
+// The MBeans matching a pattern are posted as a `#` graph, one graph per MBean.
+type MBean struct {
+	// Name is the graph name; the metrics are posted as "jmx.jolokia.<name>"
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	// Unit is the unit of the graph, "float" by default
+	Unit string `json:"unit"`
+	// MBean is an ObjectName or a pattern, e.g. "kafka.server:type=BrokerTopicMetrics,*"
+	MBean string `json:"mbean"`
+	// Instance is the keys of the properties naming each MBean matching the pattern.
+	// The keys not fixed in the pattern are used if empty.
+	Instance   []string    `json:"instance"`
+	Attributes []Attribute `json:"attributes"`
+}
+
+// Attribute is a metric of MBean.
+type Attribute struct {
+	Attribute string `json:"attribute"`
+	// Path is the path to the value in a composite attribute, e.g. "used" of HeapMemoryUsage
+	Path string `json:"path"`
+	// Name is the metric name, the attribute (and the path) by default
+	Name    string  `json:"name"`
+	Label   string  `json:"label"`
+	Diff    bool    `json:"diff"`
+	Stacked bool    `json:"stacked"`
+	Scale   float64 `json:"scale"`
+
+	// undefinedIfNegative skips the negative values, which mean undefined, e.g. -1 of the max of MemoryUsage
+	undefinedIfNegative bool
+}
+
+// mbeanConfig is the format of the file of -config.
+type mbeanConfig struct {
+	MBeans []MBean `json:"mbeans"`
+}
+
+func loadMBeans(file string) ([]MBean, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c mbeanConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := validateMBeans(c.MBeans); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return c.MBeans, nil
+}
+
+var (
+	validGraphName   = regexp.MustCompile(`\A[-a-zA-Z0-9_]+\z`)
+	invalidNameChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+)
+
+func normalizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// validateMBeans checks that the metric names of the graphs are distinct from each other and from the JVM metrics.
+func validateMBeans(mbeans []MBean) error {
+	graphs := make(map[string]bool)
+	metrics := make(map[string]bool)
+	for key, g := range graphdef {
+		name, _, _ := strings.Cut(strings.TrimPrefix(key, "jmx.jolokia."), ".")
+		graphs[name] = true
+		for _, m := range g.Metrics {
+			metrics[m.Name] = true
+		}
+	}
+	for _, b := range jvmMBeans {
+		graphs[b.Name] = true
+	}
+	for _, b := range mbeans {
+		if !validGraphName.MatchString(b.Name) {
+			return fmt.Errorf("invalid graph name: %q", b.Name)
+		}
+		if graphs[b.Name] {
+			return fmt.Errorf("duplicate graph name: %q", b.Name)
+		}
+		graphs[b.Name] = true
+		if !strings.Contains(b.MBean, ":") {
+			return fmt.Errorf("%s: invalid mbean: %q", b.Name, b.MBean)
+		}
+		if len(b.Attributes) == 0 {
+			return fmt.Errorf("%s: no attributes", b.Name)
+		}
+
+		var names []string
+		for _, a := range b.Attributes {
+			if a.Attribute == "" {
+				return fmt.Errorf("%s: empty attribute", b.Name)
+			}
+			name := a.metricName()
+			if !validGraphName.MatchString(name) {
+				return fmt.Errorf("%s: invalid metric name: %q", b.Name, name)
+			}
+			for _, n := range names {
+				// the metrics of a wildcard graph are matched by prefix
+				if n == name || b.isPattern() && (strings.HasPrefix(n, name) || strings.HasPrefix(name, n)) {
+					return fmt.Errorf("%s: conflicting metric names: %q and %q", b.Name, n, name)
+				}
+			}
+			names = append(names, name)
+			if b.isPattern() {
+				continue
+			}
+			// the metrics of the other graphs are posted with their names as is
+			if metrics[name] {
+				return fmt.Errorf("%s: duplicate metric name: %q", b.Name, name)
+			}
+			metrics[name] = true
+		}
+	}
+	return nil
+}
+
+func (a Attribute) metricName() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if a.Path != "" {
+		return normalizeName(a.Attribute + "_" + a.Path)
+	}
+	return normalizeName(a.Attribute)
+}
+
+// value returns the value of the attribute in attrs, which maps the attributes of an MBean to the values.
+func (a Attribute) value(attrs map[string]any) (any, bool) {
+	v := attrs[a.Attribute]
+	if a.Path != "" {
+		for p := range strings.SplitSeq(a.Path, "/") {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			v = m[p]
+		}
+	}
+	switch v := v.(type) {
+	case float64:
+		if a.undefinedIfNegative && v < 0 {
+			return nil, false
+		}
+		return v, true
+	case string:
+		return v, true
+	case bool:
+		if v {
+			return 1.0, true
+		}
+		return 0.0, true
+	}
+	return nil, false
+}
+
+func (b MBean) isPattern() bool {
+	return strings.ContainsAny(b.MBean, "*?")
+}
+
+func (b MBean) graphKey() string {
+	if b.isPattern() {
+		return "jmx.jolokia." + b.Name + ".#"
+	}
+	return "jmx.jolokia." + b.Name
+}
+
+func (b MBean) graph() mp.Graphs {
+	g := mp.Graphs{
+		Label: b.Label,
+		Unit:  b.Unit,
+	}
+	if g.Label == "" {
+		g.Label = "Jmx " + b.Name
+	}
+	if g.Unit == "" {
+		g.Unit = "float"
+	}
+	for _, a := range b.Attributes {
+		m := mp.Metrics{
+			Name:    a.metricName(),
+			Label:   a.Label,
+			Diff:    a.Diff,
+			Stacked: a.Stacked,
+			Scale:   a.Scale,
+		}
+		if m.Label == "" {
+			m.Label = m.Name
+		}
+		g.Metrics = append(g.Metrics, m)
+	}
+	return g
+}
+
+func (b MBean) attributes() []string {
+	var attrs []string
+	for _, a := range b.Attributes {
+		if !slices.Contains(attrs, a.Attribute) {
+			attrs = append(attrs, a.Attribute)
+		}
+	}
+	return attrs
+}
+
+// setMetrics sets the metrics from the value of the read response.
+// The value maps the attributes to their values, or the ObjectNames to them for a pattern.
+func (b MBean) setMetrics(stat map[string]any, value map[string]any) {
+	if !b.isPattern() {
+		for _, a := range b.Attributes {
+			if v, ok := a.value(value); ok {
+				stat[a.metricName()] = v
+			}
+		}
+		return
+	}
+	for objectName, attrs := range value {
+		attrs, ok := attrs.(map[string]any)
+		if !ok {
+			continue
+		}
+		instance, err := b.instanceName(objectName)
+		if err != nil {
+			logger.Warningf("%s: %s", b.Name, err)
+			continue
+		}
+		for _, a := range b.Attributes {
+			if v, ok := a.value(attrs); ok {
+				stat["jmx.jolokia."+b.Name+"."+instance+"."+a.metricName()] = v
+			}
+		}
+	}
+}
+
+// instanceName returns the name of the MBean matching the pattern from the values of its properties,
+// e.g. "BytesInPerSec_topic1" of "kafka.server:name=BytesInPerSec,topic=topic1,type=BrokerTopicMetrics".
+func (b MBean) instanceName(objectName string) (string, error) {
+	props, err := parseProperties(objectName)
+	if err != nil {
+		return "", err
+	}
+	keys := b.Instance
+	if len(keys) == 0 {
+		fixed := make(map[string]bool)
+		if patternProps, err := parseProperties(b.MBean); err == nil {
+			for k, v := range patternProps {
+				if !strings.ContainsAny(v, "*?") {
+					fixed[k] = true
+				}
+			}
+		}
+		for k := range props {
+			if !fixed[k] {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+	}
+	var values []string
+	for _, k := range keys {
+		if v, ok := props[k]; ok {
+			values = append(values, strings.Trim(v, `"`))
+		}
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("cannot name %s by %v", objectName, keys)
+	}
+	return normalizeName(strings.Join(values, "_")), nil
+}
+
+// parseProperties parses the key properties of an ObjectName.
+// The wildcard "*" in the property list of a pattern is ignored.
+func parseProperties(objectName string) (map[string]string, error) {
+	_, list, ok := strings.Cut(objectName, ":")
+	if !ok {
+		return nil, errors.New("invalid ObjectName: " + objectName)
+	}
+	props := make(map[string]string)
+	for p := range strings.SplitSeq(list, ",") {
+		if p == "*" {
+			continue
+		}
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			return nil, errors.New("invalid ObjectName: " + objectName)
+		}
+		props[k] = v
+	}
+	return props, nil
+}
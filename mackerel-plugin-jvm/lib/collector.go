@@ -0,0 +1,295 @@
+package mpjvm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// The collectors whose graphs are not shaped in the young and old generations.
+// The others, Serial, Parallel and CMS, are posted as the metrics of jstat.
+const (
+	collectorG1         = "G1"
+	collectorZ          = "ZGC"
+	collectorShenandoah = "Shenandoah"
+)
+
+// maxCollectors is the maximum number of sun.gc.collector.<n> counters.
+const maxCollectors = 4
+
+// collector detects the garbage collector from the names of the collector counters, such as
+// "G1 incremental collections", "Z concurrent cycle pauses" and "Shenandoah full".
+// It returns "" for the generational collectors.
+func (d perfData) collector() string {
+	for i := range maxCollectors {
+		name := d.str(fmt.Sprintf("sun.gc.collector.%d.name", i))
+		switch {
+		case strings.HasPrefix(name, "G1"):
+			return collectorG1
+		case strings.HasPrefix(name, "Z ") || strings.HasPrefix(name, "ZGC"):
+			return collectorZ
+		case strings.HasPrefix(name, "Shenandoah"):
+			return collectorShenandoah
+		}
+	}
+	return ""
+}
+
+var invalidNameChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// collectorNames maps the names of the collector counters to the metric names.
+// What the counters count depends on the collector: G1 and ZGC count the stop-the-world pauses,
+// while Shenandoah counts the cycles, recording the concurrent and the degenerated ones as "partial"
+// and the full GCs as "full".
+var collectorNames = map[string]string{
+	"G1 incremental collections":         "young_pauses",
+	"G1 stop-the-world full collections": "full_pauses",
+	"G1 stop-the-world phases":           "remark_cleanup_pauses",
+	"Z concurrent cycle pauses":          "pauses",
+	"ZGC minor collection pauses":        "minor_pauses",
+	"ZGC major collection pauses":        "major_pauses",
+	"Shenandoah partial":                 "partial_cycles",
+	"Shenandoah full":                    "full_cycles",
+}
+
+// collectorName returns the metric name of the collector counter.
+// The unknown ones are posted with their own names.
+func collectorName(name string) string {
+	if n, ok := collectorNames[name]; ok {
+		return n
+	}
+	return normalizeName(name)
+}
+
+// collectorMetrics returns the metrics of G1, ZGC and Shenandoah.
+func (m JVMPlugin) collectorMetrics(d perfData) map[string]float64 {
+	base := m.metricKeyBase()
+	frequency, _ := d.long("sun.os.hrt.frequency")
+	stat := make(map[string]float64)
+	for i := range maxCollectors {
+		ns := fmt.Sprintf("sun.gc.collector.%d.", i)
+		name := d.str(ns + "name")
+		if name == "" {
+			continue
+		}
+		name = collectorName(name)
+		if v, ok := d.long(ns + "invocations"); ok {
+			stat[base+".gc_collector_events."+name] = v
+		}
+		if frequency <= 0 {
+			continue
+		}
+		if v, ok := d.long(ns + "time"); ok {
+			stat[base+".gc_collector_time."+name] = v / frequency
+			stat[base+".gc_collector_time_percentage."+name] = v / frequency
+		}
+		entry, ok1 := d.long(ns + "lastEntryTime")
+		exit, ok2 := d.long(ns + "lastExitTime")
+		// the collector is running if the last entry is after the last exit
+		if ok1 && ok2 && exit >= entry && entry > 0 {
+			stat[base+".gc_last_duration."+name] = (exit - entry) / frequency * 1000
+		}
+	}
+
+	// the CPU time of the GC threads, including the concurrent ones, in the recent versions of JDK
+	for k := range d {
+		if name, ok := strings.CutPrefix(k, "sun.threads.cpu_time.gc_"); ok {
+			if v, ok := d.long(k); ok {
+				stat[base+".gc_cpu_time."+normalizeName(name)] = v / 1e9
+			}
+		}
+	}
+	if v, ok := d.long("sun.threads.total_gc_cpu_time"); ok {
+		stat[base+".gc_cpu_time.total"] = v / 1e9
+	}
+
+	// the whole heap is the old generation for ZGC and Shenandoah
+	var used, committed float64
+	for _, gen := range []string{"sun.gc.generation.0.", "sun.gc.generation.1."} {
+		if v, ok := d.long(gen + "capacity"); ok {
+			committed += v
+		}
+		for i := range 3 {
+			if v, ok := d.long(fmt.Sprintf("%sspace.%d.used", gen, i)); ok {
+				used += v
+			}
+		}
+	}
+	stat["heapUsed"] = used
+	stat["heapCommitted"] = committed
+	if v, ok := d.long("sun.gc.generation.1.maxCapacity"); ok {
+		stat["heapMax"] = v
+	}
+
+	switch m.Collector {
+	case collectorG1:
+		eden, _ := d.long("sun.gc.generation.0.space.0.used")
+		s0, _ := d.long("sun.gc.generation.0.space.1.used")
+		s1, _ := d.long("sun.gc.generation.0.space.2.used")
+		old, _ := d.long("sun.gc.generation.1.space.0.used")
+		stat["g1EdenUsed"] = eden
+		stat["g1SurvivorUsed"] = s0 + s1
+		stat["g1OldUsed"] = old
+	case collectorShenandoah:
+		if v, ok := d.long("sun.gc.shenandoah.regions.max_regions"); ok {
+			stat["shenandoahMaxRegions"] = v
+		}
+		// the regions are sampled only with -XX:+ShenandoahRegionSampling
+		var usedRegions float64
+		sampled := false
+		for k := range d {
+			if !strings.HasPrefix(k, "sun.gc.shenandoah.regions.region.") || !strings.HasSuffix(k, ".data") {
+				continue
+			}
+			v, ok := d[k].(int64)
+			if !ok {
+				continue
+			}
+			sampled = true
+			// bits 0-6 are the percentage of the used memory of the region
+			if v&0x7f > 0 {
+				usedRegions++
+			}
+		}
+		if sampled {
+			stat["shenandoahUsedRegions"] = usedRegions
+		}
+	}
+	return stat
+}
+
+// gcLogTailSize is the size of the tail of the GC log to read the latest regions.
+const gcLogTailSize = 64 * 1024
+
+var g1RegionsRe = regexp.MustCompile(`\b(Survivor|Old|Humongous) regions: (\d+)->(\d+)`)
+
+// readG1Regions reads the numbers of regions after the latest GC from the unified GC log of G1 (JDK 9 or later),
+// written with -Xlog:gc+heap=info or -Xlog:gc*, such as
+// [info][gc,heap] GC(12) Humongous regions: 3->1
+func readG1Regions(path string) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if offset := fi.Size() - gcLogTailSize; offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for _, m := range g1RegionsRe.FindAllSubmatch(b, -1) {
+		after, err := strconv.ParseFloat(string(m[3]), 64)
+		if err != nil {
+			continue
+		}
+		// the later ones overwrite the earlier
+		stat["g1"+string(m[1])+"Regions"] = after
+	}
+	return stat, nil
+}
+
+func (m JVMPlugin) collectorGraphDefinition() map[string]mp.Graphs {
+	base := m.metricKeyBase()
+	label := m.metricLabel()
+	graphs := map[string]mp.Graphs{
+		base + ".gc_collector_events": {
+			Label: fmt.Sprintf("JVM %s GC events by collector", label),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+		base + ".gc_collector_time": {
+			Label: fmt.Sprintf("JVM %s GC time by collector (sec)", label),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+		base + ".gc_collector_time_percentage": {
+			Label: fmt.Sprintf("JVM %s GC time percentage by collector", label),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				// gc_collector_time_percentage is the percentage of gc time to 60 sec.
+				{Name: "*", Label: "%1", Diff: true, Scale: (100.0 / 60)},
+			},
+		},
+		base + ".gc_last_duration": {
+			Label: fmt.Sprintf("JVM %s GC last duration by collector (ms)", label),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1"},
+			},
+		},
+		base + ".gc_cpu_time": {
+			Label: fmt.Sprintf("JVM %s GC threads CPU time (sec)", label),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+		base + ".heap": {
+			Label: fmt.Sprintf("JVM %s Heap", label),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "heapMax", Label: "Max"},
+				{Name: "heapCommitted", Label: "Committed"},
+				{Name: "heapUsed", Label: "Used"},
+			},
+		},
+	}
+
+	switch m.Collector {
+	case collectorG1:
+		graphs[base+".g1_heap"] = mp.Graphs{
+			Label: fmt.Sprintf("JVM %s G1 Heap", label),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "g1EdenUsed", Label: "Eden used", Stacked: true},
+				{Name: "g1SurvivorUsed", Label: "Survivor used", Stacked: true},
+				{Name: "g1OldUsed", Label: "Old used (including humongous)", Stacked: true},
+			},
+		}
+		if m.GCLog != "" {
+			graphs[base+".g1_regions"] = mp.Graphs{
+				Label: fmt.Sprintf("JVM %s G1 Regions after GC", label),
+				Unit:  "integer",
+				Metrics: []mp.Metrics{
+					{Name: "g1SurvivorRegions", Label: "Survivor", Stacked: true},
+					{Name: "g1OldRegions", Label: "Old", Stacked: true},
+					{Name: "g1HumongousRegions", Label: "Humongous", Stacked: true},
+				},
+			}
+		}
+	case collectorShenandoah:
+		graphs[base+".shenandoah_regions"] = mp.Graphs{
+			Label: fmt.Sprintf("JVM %s Shenandoah Regions", label),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "shenandoahMaxRegions", Label: "Max"},
+				{Name: "shenandoahUsedRegions", Label: "Used"},
+			},
+		}
+	}
+	return graphs
+}
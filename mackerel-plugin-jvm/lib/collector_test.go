@@ -0,0 +1,173 @@
+package mpjvm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var g1Entries = []perfEntry{
+	{"sun.os.hrt.frequency", int64(1000000000)},
+	{"sun.rt.javaCommand", "org.apache.catalina.startup.Bootstrap start"},
+	{"sun.gc.generation.0.capacity", int64(64 << 20)},
+	{"sun.gc.generation.0.space.0.used", int64(20 << 20)},
+	{"sun.gc.generation.0.space.1.used", int64(0)},
+	{"sun.gc.generation.0.space.2.used", int64(4 << 20)},
+	{"sun.gc.generation.1.capacity", int64(192 << 20)},
+	{"sun.gc.generation.1.maxCapacity", int64(1 << 30)},
+	{"sun.gc.generation.1.space.0.used", int64(100 << 20)},
+	{"sun.gc.collector.0.name", "G1 incremental collections"},
+	{"sun.gc.collector.0.invocations", int64(120)},
+	{"sun.gc.collector.0.time", int64(1500000000)},
+	{"sun.gc.collector.0.lastEntryTime", int64(9000000000)},
+	{"sun.gc.collector.0.lastExitTime", int64(9012000000)},
+	{"sun.gc.collector.1.name", "G1 stop-the-world full collections"},
+	{"sun.gc.collector.1.invocations", int64(0)},
+	{"sun.gc.collector.1.time", int64(0)},
+	{"sun.gc.collector.1.lastEntryTime", int64(0)},
+	{"sun.gc.collector.1.lastExitTime", int64(0)},
+	{"sun.gc.collector.2.name", "G1 stop-the-world phases"},
+	{"sun.gc.collector.2.invocations", int64(8)},
+	{"sun.gc.collector.2.time", int64(40000000)},
+	{"sun.threads.total_gc_cpu_time", int64(3000000000)},
+	{"sun.threads.cpu_time.gc_conc_mark", int64(2000000000)},
+	{"sun.threads.cpu_time.gc_parallel_workers", int64(1000000000)},
+	{"sun.threads.cpu_time.vm", int64(500000000)},
+}
+
+func TestCollector(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"G1 incremental collections", "G1 stop-the-world full collections", "G1 stop-the-world phases"}, collectorG1},
+		{[]string{"", "", "Z concurrent cycle pauses"}, collectorZ},
+		{[]string{"Shenandoah partial", "Shenandoah full"}, collectorShenandoah},
+		{[]string{"PSScavenge", "PSParallelCompact"}, ""},
+		{[]string{"Copy", "MSC"}, ""},
+		{[]string{"ParNew", "CMS"}, ""},
+	}
+	for _, tt := range tests {
+		d := perfData{}
+		for i, name := range tt.names {
+			if name != "" {
+				d[fmt.Sprintf("sun.gc.collector.%d.name", i)] = name
+			}
+		}
+		assert.Equal(t, tt.want, d.collector(), strings.Join(tt.names, ","))
+	}
+}
+
+func TestCollectorName(t *testing.T) {
+	assert.Equal(t, "young_pauses", collectorName("G1 incremental collections"))
+	assert.Equal(t, "major_pauses", collectorName("ZGC major collection pauses"))
+	// the unknown collectors in the future versions
+	assert.Equal(t, "ZGC_minor_collection_cycles", collectorName("ZGC minor collection cycles"))
+}
+
+func TestCollectorMetricsG1(t *testing.T) {
+	d, err := parsePerfData(buildPerfData(binary.LittleEndian, g1Entries))
+	require.NoError(t, err)
+	m := JVMPlugin{JavaName: "Tomcat", Collector: d.collector()}
+	stat := m.collectorMetrics(d)
+
+	expected := map[string]float64{
+		"jvm.tomcat.gc_collector_events.young_pauses":                   120,
+		"jvm.tomcat.gc_collector_events.full_pauses":                    0,
+		"jvm.tomcat.gc_collector_events.remark_cleanup_pauses":          8,
+		"jvm.tomcat.gc_collector_time.young_pauses":                     1.5,
+		"jvm.tomcat.gc_collector_time.full_pauses":                      0,
+		"jvm.tomcat.gc_collector_time.remark_cleanup_pauses":            0.04,
+		"jvm.tomcat.gc_collector_time_percentage.young_pauses":          1.5,
+		"jvm.tomcat.gc_collector_time_percentage.full_pauses":           0,
+		"jvm.tomcat.gc_collector_time_percentage.remark_cleanup_pauses": 0.04,
+		"jvm.tomcat.gc_last_duration.young_pauses":                      12,
+		"jvm.tomcat.gc_cpu_time.total":                                  3,
+		"jvm.tomcat.gc_cpu_time.conc_mark":                              2,
+		"jvm.tomcat.gc_cpu_time.parallel_workers":                       1,
+		"heapUsed":       124 << 20,
+		"heapCommitted":  256 << 20,
+		"heapMax":        1 << 30,
+		"g1EdenUsed":     20 << 20,
+		"g1SurvivorUsed": 4 << 20,
+		"g1OldUsed":      100 << 20,
+	}
+	assert.Len(t, stat, len(expected))
+	for k, v := range expected {
+		assert.InDelta(t, v, stat[k], 0.001, k)
+	}
+
+	// the keys of wildcard graphs match the graph definitions
+	graphs := m.GraphDefinition()
+	assert.Contains(t, graphs, "jvm.tomcat.gc_collector_events")
+	assert.Contains(t, graphs, "jvm.tomcat.g1_heap")
+	assert.Contains(t, graphs, "jvm.tomcat.metaspace")
+	assert.NotContains(t, graphs, "jvm.tomcat.g1_regions")
+	assert.NotContains(t, graphs, "jvm.tomcat.old_space")
+}
+
+func TestCollectorMetricsShenandoah(t *testing.T) {
+	d := perfData{
+		"sun.gc.collector.0.name":                      "Shenandoah partial",
+		"sun.gc.collector.0.invocations":               int64(30),
+		"sun.gc.collector.1.name":                      "Shenandoah full",
+		"sun.gc.collector.1.invocations":               int64(1),
+		"sun.gc.generation.1.capacity":                 int64(512 << 20),
+		"sun.gc.generation.1.maxCapacity":              int64(1 << 30),
+		"sun.gc.generation.1.space.0.used":             int64(300 << 20),
+		"sun.gc.shenandoah.regions.max_regions":        int64(4),
+		"sun.gc.shenandoah.regions.region.0.data":      int64(100 | 80<<7),
+		"sun.gc.shenandoah.regions.region.1.data":      int64(50 | 10<<7),
+		"sun.gc.shenandoah.regions.region.2.data":      int64(0),
+		"sun.gc.shenandoah.regions.region.3.data":      int64(0),
+		"sun.gc.shenandoah.regions.region.3.something": int64(1),
+	}
+	m := JVMPlugin{JavaName: "App", Collector: d.collector()}
+	require.Equal(t, collectorShenandoah, m.Collector)
+	stat := m.collectorMetrics(d)
+	assert.EqualValues(t, 30, stat["jvm.app.gc_collector_events.partial_cycles"])
+	assert.EqualValues(t, 1, stat["jvm.app.gc_collector_events.full_cycles"])
+	assert.EqualValues(t, 300<<20, stat["heapUsed"])
+	assert.EqualValues(t, 512<<20, stat["heapCommitted"])
+	assert.EqualValues(t, 4, stat["shenandoahMaxRegions"])
+	assert.EqualValues(t, 2, stat["shenandoahUsedRegions"])
+	assert.Contains(t, m.GraphDefinition(), "jvm.app.shenandoah_regions")
+}
+
+func TestReadG1Regions(t *testing.T) {
+	log := `[2024-01-01T00:00:00.000+0000][info][gc,heap] GC(11) Eden regions: 24->0(22)
+[2024-01-01T00:00:00.000+0000][info][gc,heap] GC(11) Survivor regions: 2->3(3)
+[2024-01-01T00:00:00.000+0000][info][gc,heap] GC(11) Old regions: 40->41
+[2024-01-01T00:00:00.000+0000][info][gc,heap] GC(11) Humongous regions: 5->2
+[2024-01-01T00:00:01.000+0000][info][gc,heap] GC(12) Eden regions: 22->0(23)
+[2024-01-01T00:00:01.000+0000][info][gc,heap] GC(12) Survivor regions: 3->2(3)
+[2024-01-01T00:00:01.000+0000][info][gc,heap] GC(12) Old regions: 41->43
+[2024-01-01T00:00:01.000+0000][info][gc,heap] GC(12) Humongous regions: 7->7
+[2024-01-01T00:00:01.000+0000][info][gc      ] GC(12) Pause Young (Normal) (G1 Evacuation Pause) 80M->60M(256M) 3.456ms
+`
+	path := filepath.Join(t.TempDir(), "gc.log")
+	require.NoError(t, os.WriteFile(path, []byte(log), 0644))
+	stat, err := readG1Regions(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"g1SurvivorRegions":  2,
+		"g1OldRegions":       43,
+		"g1HumongousRegions": 7,
+	}, stat)
+
+	// G1 with the GC log
+	perf := filepath.Join(t.TempDir(), "1234")
+	require.NoError(t, os.WriteFile(perf, buildPerfData(binary.BigEndian, g1Entries), 0600))
+	m := JVMPlugin{JavaName: "Tomcat", PerfDataPath: perf, Collector: collectorG1, GCLog: path}
+	result, err := m.FetchMetrics()
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, result["g1HumongousRegions"])
+	assert.EqualValues(t, 120, result["jvm.tomcat.gc_collector_events.young_pauses"])
+	assert.Contains(t, m.GraphDefinition(), "jvm.tomcat.g1_regions")
+}
@@ -0,0 +1,313 @@
+package mpjvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hsperfdata is the performance counters which HotSpot JVM writes to /tmp/hsperfdata_<user>/<pid>.
+// jps and jstat read the same file, so the metrics are available without JDK tools.
+// See also. https://github.com/openjdk/jdk/blob/master/src/hotspot/share/runtime/perfMemory.hpp
+
+const (
+	perfDataMagic       = 0xcafec0c0
+	perfDataPrologueLen = 32
+	perfDataEntryLen    = 20
+)
+
+// DefaultPerfDataDir is the directory containing hsperfdata_<user> directories.
+// HotSpot always uses /tmp regardless of java.io.tmpdir.
+const DefaultPerfDataDir = "/tmp"
+
+// perfData holds the counters, whose values are int64 or string.
+type perfData map[string]any
+
+func readPerfData(path string) (perfData, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parsePerfData(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+func parsePerfData(b []byte) (perfData, error) {
+	if len(b) < perfDataPrologueLen {
+		return nil, errors.New("too short for hsperfdata")
+	}
+	// the magic is always big endian
+	if binary.BigEndian.Uint32(b[0:4]) != perfDataMagic {
+		return nil, errors.New("invalid magic of hsperfdata")
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if b[4] == 1 {
+		order = binary.LittleEndian
+	}
+	if major := b[5]; major != 2 {
+		return nil, fmt.Errorf("unsupported version of hsperfdata: %d.%d", major, b[6])
+	}
+	if accessible := b[7]; accessible == 0 {
+		return nil, errors.New("hsperfdata is not accessible yet")
+	}
+	offset := int(int32(order.Uint32(b[24:28])))
+	numEntries := int(int32(order.Uint32(b[28:32])))
+
+	d := make(perfData, numEntries)
+	for range numEntries {
+		if offset < 0 || offset+perfDataEntryLen > len(b) {
+			return nil, errors.New("hsperfdata is truncated")
+		}
+		entryLen := int(int32(order.Uint32(b[offset:])))
+		nameOffset := int(int32(order.Uint32(b[offset+4:])))
+		vectorLen := int(int32(order.Uint32(b[offset+8:])))
+		dataType := b[offset+12]
+		dataOffset := int(int32(order.Uint32(b[offset+16:])))
+		if entryLen < perfDataEntryLen || offset+entryLen > len(b) {
+			return nil, errors.New("hsperfdata is truncated")
+		}
+		entry := b[offset : offset+entryLen]
+		offset += entryLen
+
+		if nameOffset < perfDataEntryLen || nameOffset >= entryLen {
+			continue
+		}
+		name := cString(entry[nameOffset:])
+		switch {
+		case vectorLen == 0 && dataType == 'J':
+			if dataOffset < 0 || dataOffset+8 > entryLen {
+				continue
+			}
+			d[name] = int64(order.Uint64(entry[dataOffset:]))
+		case vectorLen > 0 && dataType == 'B':
+			if dataOffset < 0 || dataOffset+vectorLen > entryLen {
+				continue
+			}
+			d[name] = cString(entry[dataOffset : dataOffset+vectorLen])
+		}
+	}
+	return d, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func (d perfData) long(name string) (float64, bool) {
+	v, ok := d[name].(int64)
+	return float64(v), ok
+}
+
+func (d perfData) str(name string) string {
+	s, _ := d[name].(string)
+	return s
+}
+
+// jvmName returns the name of the JVM as jps shows;
+// the simple name of the main class, or the file name of the jar.
+func (d perfData) jvmName() string {
+	cmd, _, _ := strings.Cut(strings.TrimSpace(d.str("sun.rt.javaCommand")), " ")
+	if i := strings.LastIndexAny(cmd, `/\`); i > 0 {
+		cmd = cmd[i+1:]
+	}
+	if i := strings.LastIndex(cmd, "."); i > 0 && cmd[i+1:] != "jar" {
+		cmd = cmd[i+1:]
+	}
+	return cmd
+}
+
+// jvmProcess is a JVM found in the hsperfdata directories.
+type jvmProcess struct {
+	Pid  string
+	Name string
+	Path string
+}
+
+// discoverJVMs finds the JVMs of all users whose hsperfdata files are readable.
+func discoverJVMs(dir string) ([]jvmProcess, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "hsperfdata_*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	var jvms []jvmProcess
+	for _, path := range paths {
+		pid := filepath.Base(path)
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		// the files of other users are not readable, and the files of dead JVMs may be left
+		d, err := readPerfData(path)
+		if err != nil {
+			logger.Debugf("Skipped %s: %s", path, err)
+			continue
+		}
+		jvms = append(jvms, jvmProcess{Pid: pid, Name: d.jvmName(), Path: path})
+	}
+	return jvms, nil
+}
+
+// findPerfDataByName returns the path of the hsperfdata file of the JVM named name.
+func findPerfDataByName(dir, name string) (string, error) {
+	jvms, err := discoverJVMs(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, jvm := range jvms {
+		if jvm.Name == name {
+			return jvm.Path, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find hsperfdata of %s in %s", name, dir)
+}
+
+// findPerfDataByPid returns the path of the hsperfdata file of the JVM whose pid is pid.
+func findPerfDataByPid(dir, pid string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "hsperfdata_*", pid))
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("cannot find hsperfdata of pid %s in %s", pid, dir)
+	}
+	return paths[0], nil
+}
+
+const (
+	perfKB = iota
+	perfCount
+	perfSec
+)
+
+// jstatCounters maps the columns of `jstat -gc`, `-gccapacity`, `-gcnew` and `-gcold` to the counters.
+// See also. https://github.com/openjdk/jdk/blob/master/src/jdk.jcmd/share/classes/sun/tools/jstat/resources/jstat_options
+var jstatCounters = []struct {
+	key     string
+	counter string
+	unit    int
+}{
+	{"S0C", "sun.gc.generation.0.space.1.capacity", perfKB},
+	{"S1C", "sun.gc.generation.0.space.2.capacity", perfKB},
+	{"S0U", "sun.gc.generation.0.space.1.used", perfKB},
+	{"S1U", "sun.gc.generation.0.space.2.used", perfKB},
+	{"EC", "sun.gc.generation.0.space.0.capacity", perfKB},
+	{"EU", "sun.gc.generation.0.space.0.used", perfKB},
+	{"OC", "sun.gc.generation.1.space.0.capacity", perfKB},
+	{"OU", "sun.gc.generation.1.space.0.used", perfKB},
+	{"NGCMN", "sun.gc.generation.0.minCapacity", perfKB},
+	{"NGCMX", "sun.gc.generation.0.maxCapacity", perfKB},
+	{"NGC", "sun.gc.generation.0.capacity", perfKB},
+	{"OGCMN", "sun.gc.generation.1.minCapacity", perfKB},
+	{"OGCMX", "sun.gc.generation.1.maxCapacity", perfKB},
+	{"OGC", "sun.gc.generation.1.capacity", perfKB},
+	// Java 8 or later
+	{"MC", "sun.gc.metaspace.capacity", perfKB},
+	{"MU", "sun.gc.metaspace.used", perfKB},
+	{"MCMN", "sun.gc.metaspace.minCapacity", perfKB},
+	{"MCMX", "sun.gc.metaspace.maxCapacity", perfKB},
+	{"CCSC", "sun.gc.compressedclassspace.capacity", perfKB},
+	{"CCSU", "sun.gc.compressedclassspace.used", perfKB},
+	{"CCSMN", "sun.gc.compressedclassspace.minCapacity", perfKB},
+	{"CCSMX", "sun.gc.compressedclassspace.maxCapacity", perfKB},
+	// Java 7
+	{"PC", "sun.gc.generation.2.space.0.capacity", perfKB},
+	{"PU", "sun.gc.generation.2.space.0.used", perfKB},
+	{"PGCMN", "sun.gc.generation.2.minCapacity", perfKB},
+	{"PGCMX", "sun.gc.generation.2.maxCapacity", perfKB},
+	{"PGC", "sun.gc.generation.2.capacity", perfKB},
+	{"TT", "sun.gc.policy.tenuringThreshold", perfCount},
+	{"MTT", "sun.gc.policy.maxTenuringThreshold", perfCount},
+	{"DSS", "sun.gc.policy.desiredSurvivorSize", perfKB},
+	{"YGC", "sun.gc.collector.0.invocations", perfCount},
+	{"YGCT", "sun.gc.collector.0.time", perfSec},
+	{"FGC", "sun.gc.collector.1.invocations", perfCount},
+	{"FGCT", "sun.gc.collector.1.time", perfSec},
+	// Java 9 or later
+	{"CGC", "sun.gc.collector.2.invocations", perfCount},
+	{"CGCT", "sun.gc.collector.2.time", perfSec},
+}
+
+// jstatMetrics returns the metrics with the same names and units as jstat; the sizes in KB and the times in seconds.
+func (d perfData) jstatMetrics() map[string]float64 {
+	frequency, _ := d.long("sun.os.hrt.frequency")
+	stat := make(map[string]float64)
+	for _, c := range jstatCounters {
+		v, ok := d.long(c.counter)
+		if !ok {
+			continue
+		}
+		switch c.unit {
+		case perfKB:
+			stat[c.key] = v / 1024
+		case perfCount:
+			stat[c.key] = v
+		case perfSec:
+			if frequency > 0 {
+				stat[c.key] = v / frequency
+			}
+		}
+	}
+	if _, ok := stat["YGCT"]; ok {
+		stat["GCT"] = stat["YGCT"] + stat["FGCT"] + stat["CGCT"]
+	}
+	return stat
+}
+
+var cmsInitiatingOccupancyFractionRe = regexp.MustCompile(`-XX:CMSInitiatingOccupancyFraction=(-?\d+)`)
+
+// cmsInitiatingOccupancyFraction returns CMSInitiatingOccupancyFraction if CMS is used.
+// It is -1 unless specified as jinfo shows.
+func (d perfData) cmsInitiatingOccupancyFraction() (float64, bool) {
+	args := d.str("java.rt.vmArgs") + " " + d.str("java.rt.vmFlags")
+	if d.str("sun.gc.collector.1.name") != "CMS" && !strings.Contains(args, "-XX:+UseConcMarkSweepGC") {
+		return 0, false
+	}
+	m := cmsInitiatingOccupancyFractionRe.FindAllStringSubmatch(args, -1)
+	if len(m) == 0 {
+		return -1, true
+	}
+	// the last one takes precedence
+	fraction, _ := strconv.ParseFloat(m[len(m)-1][1], 64)
+	return fraction, true
+}
+
+func (m JVMPlugin) fetchPerfDataMetrics() (map[string]float64, error) {
+	d, err := readPerfData(m.PerfDataPath)
+	if err != nil {
+		logger.Errorf("Failed to read hsperfdata. %s. Please run with the java process user.", err)
+		return nil, err
+	}
+	stat := d.jstatMetrics()
+	if len(stat) == 0 {
+		return nil, fmt.Errorf("no GC counters in %s", m.PerfDataPath)
+	}
+	mergeStat(stat, memorySpaceRate(stat))
+	if fraction, ok := d.cmsInitiatingOccupancyFraction(); ok {
+		stat["CMSInitiatingOccupancyFraction"] = fraction
+	}
+
+	switch m.Collector {
+	case collectorG1, collectorZ, collectorShenandoah:
+		mergeStat(stat, m.collectorMetrics(d))
+	}
+	if m.Collector == collectorG1 && m.GCLog != "" {
+		regions, err := readG1Regions(m.GCLog)
+		if err != nil {
+			logger.Warningf("Failed to read GC log. %s", err)
+		} else {
+			mergeStat(stat, regions)
+		}
+	}
+	return stat, nil
+}
@@ -0,0 +1,233 @@
+package mpjvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type perfEntry struct {
+	name  string
+	value any // int64 or string
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// buildPerfData builds a hsperfdata file in the same layout as HotSpot.
+func buildPerfData(order binary.ByteOrder, entries []perfEntry) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xca, 0xfe, 0xc0, 0xc0})
+	if order == binary.LittleEndian {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.Write([]byte{2, 0, 1}) // major, minor, accessible
+	prologue := make([]byte, 24)
+	order.PutUint32(prologue[16:], perfDataPrologueLen)
+	order.PutUint32(prologue[20:], uint32(len(entries)))
+	buf.Write(prologue)
+
+	for _, e := range entries {
+		nameOffset := perfDataEntryLen
+		dataOffset := align8(nameOffset + len(e.name) + 1)
+		var data []byte
+		var dataType byte
+		var vectorLen int
+		switch v := e.value.(type) {
+		case int64:
+			dataType = 'J'
+			data = make([]byte, 8)
+			order.PutUint64(data, uint64(v))
+		case string:
+			dataType = 'B'
+			vectorLen = len(v) + 1
+			data = append([]byte(v), 0)
+		}
+		entry := make([]byte, align8(dataOffset+len(data)))
+		order.PutUint32(entry[0:], uint32(len(entry)))
+		order.PutUint32(entry[4:], uint32(nameOffset))
+		order.PutUint32(entry[8:], uint32(vectorLen))
+		entry[12] = dataType
+		order.PutUint32(entry[16:], uint32(dataOffset))
+		copy(entry[nameOffset:], e.name)
+		copy(entry[dataOffset:], data)
+		buf.Write(entry)
+	}
+	return buf.Bytes()
+}
+
+// serialGCEntries are the counters of a JVM with Serial GC, equivalent to testdata/jstat_serialgc.sh.
+var serialGCEntries = []perfEntry{
+	{"sun.os.hrt.frequency", int64(1000000000)},
+	{"sun.rt.javaCommand", "io.netty.example.NettyServer --port 8080"},
+	{"java.rt.vmArgs", "-Xmx2g -XX:+UseSerialGC"},
+	{"sun.gc.generation.0.space.0.capacity", int64(361728 * 1024)},
+	{"sun.gc.generation.0.space.0.used", int64(135592653)},
+	{"sun.gc.generation.0.space.1.capacity", int64(45184 * 1024)},
+	{"sun.gc.generation.0.space.1.used", int64(45184 * 1024)},
+	{"sun.gc.generation.0.space.2.capacity", int64(45184 * 1024)},
+	{"sun.gc.generation.0.space.2.used", int64(0)},
+	{"sun.gc.generation.0.minCapacity", int64(10240 * 1024)},
+	{"sun.gc.generation.0.maxCapacity", int64(452096 * 1024)},
+	{"sun.gc.generation.0.capacity", int64(452096 * 1024)},
+	{"sun.gc.generation.1.space.0.capacity", int64(904068 * 1024)},
+	{"sun.gc.generation.1.space.0.used", int64(695551488)},
+	{"sun.gc.generation.1.minCapacity", int64(20480 * 1024)},
+	{"sun.gc.generation.1.maxCapacity", int64(1597440 * 1024)},
+	{"sun.gc.generation.1.capacity", int64(904068 * 1024)},
+	{"sun.gc.metaspace.capacity", int64(21248 * 1024)},
+	{"sun.gc.metaspace.used", int64(21286195)},
+	{"sun.gc.compressedclassspace.capacity", int64(2304 * 1024)},
+	{"sun.gc.compressedclassspace.used", int64(2156339)},
+	{"sun.gc.policy.tenuringThreshold", int64(15)},
+	{"sun.gc.policy.maxTenuringThreshold", int64(15)},
+	{"sun.gc.policy.desiredSurvivorSize", int64(23134208)},
+	{"sun.gc.collector.0.name", "Copy"},
+	{"sun.gc.collector.0.invocations", int64(22)},
+	{"sun.gc.collector.0.time", int64(8584000000)},
+	{"sun.gc.collector.1.name", "MSC"},
+	{"sun.gc.collector.1.invocations", int64(6)},
+	{"sun.gc.collector.1.time", int64(2343000000)},
+}
+
+func TestParsePerfData(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		d, err := parsePerfData(buildPerfData(order, serialGCEntries))
+		require.NoError(t, err)
+		assert.Len(t, d, len(serialGCEntries))
+		assert.Equal(t, int64(22), d["sun.gc.collector.0.invocations"])
+		assert.Equal(t, "Copy", d["sun.gc.collector.0.name"])
+		assert.Equal(t, "NettyServer", d.jvmName())
+	}
+
+	_, err := parsePerfData([]byte("not a hsperfdata file, but long enough"))
+	assert.Error(t, err)
+
+	b := buildPerfData(binary.LittleEndian, serialGCEntries)
+	_, err = parsePerfData(b[:len(b)-8])
+	assert.Error(t, err, "truncated")
+}
+
+func TestPerfDataJstatMetrics(t *testing.T) {
+	d, err := parsePerfData(buildPerfData(binary.LittleEndian, serialGCEntries))
+	require.NoError(t, err)
+	stat := d.jstatMetrics()
+
+	expected := map[string]float64{
+		"S0C":  45184.0,
+		"S1C":  45184.0,
+		"S0U":  45184.0,
+		"S1U":  0.0,
+		"EC":   361728.0,
+		"EU":   132414.7,
+		"OC":   904068.0,
+		"OU":   679249.5,
+		"MC":   21248.0,
+		"MU":   20787.3,
+		"CCSC": 2304.0,
+		"CCSU": 2105.8,
+		"YGC":  22,
+		"YGCT": 8.584,
+		"FGC":  6,
+		"FGCT": 2.343,
+		"GCT":  10.927,
+		// -gccapacity
+		"NGCMN": 10240.0,
+		"NGCMX": 452096.0,
+		"NGC":   452096.0,
+		"OGCMN": 20480.0,
+		"OGCMX": 1597440.0,
+		"OGC":   904068.0,
+		// -gcnew
+		"TT":  15,
+		"MTT": 15,
+		"DSS": 22592.0,
+	}
+	for k, v := range expected {
+		assert.InDelta(t, v, stat[k], 0.1, k)
+	}
+	// no concurrent collector in Serial GC
+	assert.NotContains(t, stat, "CGC")
+	assert.NotContains(t, stat, "PC")
+}
+
+func TestJVMName(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"org.apache.catalina.startup.Bootstrap start", "Bootstrap"},
+		{"/opt/app/app.jar --spring.profiles.active=prod", "app.jar"},
+		{"app.jar", "app.jar"},
+		{"jdk.jcmd/sun.tools.jps.Jps", "Jps"},
+		{"NettyServer", "NettyServer"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		d := perfData{"sun.rt.javaCommand": tt.command}
+		assert.Equal(t, tt.want, d.jvmName(), tt.command)
+	}
+}
+
+func TestCMSInitiatingOccupancyFraction(t *testing.T) {
+	d := perfData{"sun.gc.collector.1.name": "MSC", "java.rt.vmArgs": "-Xmx2g"}
+	_, ok := d.cmsInitiatingOccupancyFraction()
+	assert.False(t, ok)
+
+	d = perfData{"sun.gc.collector.1.name": "CMS", "java.rt.vmArgs": "-XX:+UseConcMarkSweepGC"}
+	fraction, ok := d.cmsInitiatingOccupancyFraction()
+	assert.True(t, ok)
+	assert.EqualValues(t, -1, fraction)
+
+	d = perfData{"java.rt.vmArgs": "-XX:+UseConcMarkSweepGC -XX:CMSInitiatingOccupancyFraction=70 -XX:CMSInitiatingOccupancyFraction=75"}
+	fraction, ok = d.cmsInitiatingOccupancyFraction()
+	assert.True(t, ok)
+	assert.EqualValues(t, 75, fraction)
+}
+
+func TestDiscoverJVMs(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "hsperfdata_app"), 0755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "hsperfdata_tomcat"), 0755))
+	netty := filepath.Join(dir, "hsperfdata_app", "1234")
+	require.NoError(t, os.WriteFile(netty, buildPerfData(binary.LittleEndian, serialGCEntries), 0600))
+	tomcat := filepath.Join(dir, "hsperfdata_tomcat", "5678")
+	require.NoError(t, os.WriteFile(tomcat, buildPerfData(binary.LittleEndian, []perfEntry{
+		{"sun.rt.javaCommand", "org.apache.catalina.startup.Bootstrap start"},
+	}), 0600))
+	// the files of dead JVMs may be left empty
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hsperfdata_app", "999"), nil, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hsperfdata_app", "not-a-pid"), nil, 0600))
+
+	jvms, err := discoverJVMs(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []jvmProcess{
+		{Pid: "1234", Name: "NettyServer", Path: netty},
+		{Pid: "5678", Name: "Bootstrap", Path: tomcat},
+	}, jvms)
+
+	path, err := findPerfDataByName(dir, "Bootstrap")
+	require.NoError(t, err)
+	assert.Equal(t, tomcat, path)
+	_, err = findPerfDataByName(dir, "Unknown")
+	assert.Error(t, err)
+
+	path, err = findPerfDataByPid(dir, "1234")
+	require.NoError(t, err)
+	assert.Equal(t, netty, path)
+
+	m := JVMPlugin{JavaName: "NettyServer", PerfDataPath: netty}
+	stat, err := m.FetchMetrics()
+	require.NoError(t, err)
+	assert.EqualValues(t, 22, stat["YGC"])
+	assert.InDelta(t, 75.1, stat["oldSpaceRate"], 0.1)
+	assert.NotContains(t, stat, "CMSInitiatingOccupancyFraction")
+}
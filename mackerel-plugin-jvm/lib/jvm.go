@@ -27,6 +27,12 @@ type JVMPlugin struct {
 	Tempfile    string
 	MetricKey   string
 	MetricLabel string
+	// PerfDataPath is the hsperfdata file of the JVM. jstat is used if it is empty.
+	PerfDataPath string
+	// Collector is the garbage collector detected from hsperfdata; G1, ZGC, Shenandoah or empty.
+	Collector string
+	// GCLog is the GC log of G1 to read the regions.
+	GCLog string
 }
 
 // # jps
@@ -100,10 +106,15 @@ func (m JVMPlugin) fetchJstatMetrics(option string) (map[string]float64, error)
 	return stat, nil
 }
 
-func (m JVMPlugin) calculateMemorySpaceRate(gcStat map[string]float64) (map[string]float64, error) {
+func memorySpaceRate(gcStat map[string]float64) map[string]float64 {
 	ret := make(map[string]float64)
 	ret["oldSpaceRate"] = gcStat["OU"] / gcStat["OC"] * 100
 	ret["newSpaceRate"] = (gcStat["S0U"] + gcStat["S1U"] + gcStat["EU"]) / (gcStat["S0C"] + gcStat["S1C"] + gcStat["EC"]) * 100
+	return ret
+}
+
+func (m JVMPlugin) calculateMemorySpaceRate(gcStat map[string]float64) (map[string]float64, error) {
+	ret := memorySpaceRate(gcStat)
 
 	checkCMSGC, err := m.checkCMSGC()
 	if err != nil {
@@ -206,6 +217,27 @@ func runTimeoutCommand(Path string, Args ...string) (string, string, *timeout.Ex
 
 // FetchMetrics interface for mackerelplugin
 func (m JVMPlugin) FetchMetrics() (map[string]any, error) {
+	var (
+		stat map[string]float64
+		err  error
+	)
+	if m.PerfDataPath != "" {
+		stat, err = m.fetchPerfDataMetrics()
+	} else {
+		stat, err = m.fetchAllJstatMetrics()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]any)
+	for k, v := range stat {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (m JVMPlugin) fetchAllJstatMetrics() (map[string]float64, error) {
 	gcStat, err := m.fetchJstatMetrics("-gc")
 	if err != nil {
 		return nil, err
@@ -233,26 +265,48 @@ func (m JVMPlugin) FetchMetrics() (map[string]any, error) {
 	mergeStat(stat, gcNewStat)
 	mergeStat(stat, gcOldStat)
 	mergeStat(stat, gcSpaceRate)
+	return stat, nil
+}
 
-	result := make(map[string]any)
-	for k, v := range stat {
-		result[k] = v
+func (m JVMPlugin) metricLabel() string {
+	if m.MetricLabel == "" {
+		return m.JavaName
 	}
-	return result, nil
+	return m.MetricLabel
+}
+
+// metricKeyBase returns the prefix of the graph keys, "jvm.<metric key or java name>".
+func (m JVMPlugin) metricKeyBase() string {
+	javaName := m.MetricKey
+	if javaName == "" {
+		javaName = m.JavaName
+	}
+	return "jvm." + strings.ToLower(javaName)
 }
 
 // GraphDefinition interface for mackerelplugin
 func (m JVMPlugin) GraphDefinition() map[string]mp.Graphs {
-	metricLabel := m.MetricLabel
-	if metricLabel == "" {
-		metricLabel = m.JavaName
+	metricLabel := m.metricLabel()
+	lowerJavaName := strings.TrimPrefix(m.metricKeyBase(), "jvm.")
+	metaspace := mp.Graphs{
+		Label: fmt.Sprintf("JVM %s Metaspace", metricLabel),
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "MCMX", Label: "Metaspace capacity max", Diff: false, Scale: 1024},
+			{Name: "MCMN", Label: "Metaspace capacity min", Diff: false, Scale: 1024},
+			{Name: "MC", Label: "Metaspace capacity", Diff: false, Scale: 1024},
+			{Name: "MU", Label: "Metaspace utilization ", Diff: false, Scale: 1024},
+			{Name: "CCSC", Label: "Compressed Class Space Capacity", Diff: false, Scale: 1024},
+			{Name: "CCSU", Label: "Compressed Class Space Used", Diff: false, Scale: 1024},
+		},
 	}
 
-	javaName := m.MetricKey
-	if javaName == "" {
-		javaName = m.JavaName
+	switch m.Collector {
+	case collectorG1, collectorZ, collectorShenandoah:
+		graphs := m.collectorGraphDefinition()
+		graphs[fmt.Sprintf("jvm.%s.metaspace", lowerJavaName)] = metaspace
+		return graphs
 	}
-	lowerJavaName := strings.ToLower(javaName)
 
 	return map[string]mp.Graphs{
 		fmt.Sprintf("jvm.%s.gc_events", lowerJavaName): {
@@ -312,18 +366,7 @@ func (m JVMPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "PU", Label: "Perm used", Diff: false, Scale: 1024},
 			},
 		},
-		fmt.Sprintf("jvm.%s.metaspace", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s Metaspace", metricLabel),
-			Unit:  "float",
-			Metrics: []mp.Metrics{
-				{Name: "MCMX", Label: "Metaspace capacity max", Diff: false, Scale: 1024},
-				{Name: "MCMN", Label: "Metaspace capacity min", Diff: false, Scale: 1024},
-				{Name: "MC", Label: "Metaspace capacity", Diff: false, Scale: 1024},
-				{Name: "MU", Label: "Metaspace utilization ", Diff: false, Scale: 1024},
-				{Name: "CCSC", Label: "Compressed Class Space Capacity", Diff: false, Scale: 1024},
-				{Name: "CCSU", Label: "Compressed Class Space Used", Diff: false, Scale: 1024},
-			},
-		},
+		fmt.Sprintf("jvm.%s.metaspace", lowerJavaName): metaspace,
 		fmt.Sprintf("jvm.%s.memorySpace", lowerJavaName): {
 			Label: fmt.Sprintf("JVM %s MemorySpace", metricLabel),
 			Unit:  "float",
@@ -367,6 +410,26 @@ func generateRemote(remote, host string, port int) string {
 	return remote
 }
 
+func readPidFile(path string) (string, error) {
+	pid, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(pid)), nil
+}
+
+// findPerfData returns the path of the hsperfdata file of the JVM whose pid is in pidFile, or named javaName.
+func findPerfData(dir, javaName, pidFile string) (string, error) {
+	if pidFile != "" {
+		pid, err := readPidFile(pidFile)
+		if err != nil {
+			return "", err
+		}
+		return findPerfDataByPid(dir, pid)
+	}
+	return findPerfDataByName(dir, javaName)
+}
+
 // Do the plugin
 func Do() {
 	// Prefer ${JAVA_HOME}/bin if JAVA_HOME presents
@@ -385,8 +448,24 @@ func Do() {
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optMetricKey := flag.String("metric-key", "", "Specifying the Name field in the Graph Definition")
 	optMetricLabel := flag.String("metric-label", "", "Specifying the Label field in the Graph Definition")
+	optSource := flag.String("source", "auto", "Source of the metrics: hsperfdata, jstat or auto (hsperfdata unless -remote, falling back to jstat)")
+	optPerfDataDir := flag.String("perfdata-dir", DefaultPerfDataDir, "Directory containing hsperfdata_<user> directories")
+	optList := flag.Bool("list", false, "List the JVMs found in the hsperfdata directories and exit")
+	optGCLog := flag.String("gc-log", "", "GC log of G1 written with -Xlog:gc+heap=info to read the regions")
 	flag.Parse()
 
+	if *optList {
+		jvms, err := discoverJVMs(*optPerfDataDir)
+		if err != nil {
+			logger.Errorf("Failed to discover JVMs. %s", err)
+			os.Exit(1)
+		}
+		for _, j := range jvms {
+			fmt.Printf("%s\t%s\t%s\n", j.Pid, j.Name, j.Path)
+		}
+		return
+	}
+
 	var jvm JVMPlugin
 	jvm.JstatPath = *optJstatPath
 	jvm.JinfoPath = *optJinfoPath
@@ -402,27 +481,59 @@ func Do() {
 		logger.Warningf("both '-pidfile' and '-remote' specified, but '-pidfile' does not work with '-remote' therefore ignored")
 	}
 
-	if *optPidFile == "" || jvm.Remote != "" {
+	switch *optSource {
+	case "auto", "jstat":
+	case "hsperfdata":
+		if jvm.Remote != "" {
+			logger.Errorf("'-source=hsperfdata' does not work with '-remote'")
+			os.Exit(1)
+		}
+	default:
+		logger.Errorf("Unknown source: %s", *optSource)
+		os.Exit(1)
+	}
+	if *optSource == "hsperfdata" || (*optSource == "auto" && jvm.Remote == "") {
+		path, err := findPerfData(*optPerfDataDir, *optJavaName, *optPidFile)
+		switch {
+		case err == nil:
+			jvm.PerfDataPath = path
+			// the graphs depend on the collector
+			if d, err := readPerfData(path); err == nil {
+				jvm.Collector = d.collector()
+			}
+		case *optSource == "hsperfdata":
+			logger.Errorf("Failed to find hsperfdata. %s. Please run with the java process user, and make sure that '-XX:+PerfDisableSharedMem' is not specified.", err)
+			os.Exit(1)
+		default:
+			logger.Infof("Falling back to jstat. %s", err)
+		}
+	}
+
+	switch {
+	case jvm.PerfDataPath != "":
+		// lvmid is not needed to read hsperfdata
+	case *optPidFile == "" || jvm.Remote != "":
 		lvmid, err := fetchLvmidByAppname(*optJavaName, generateVmid(jvm.Remote, ""), *optJpsPath)
 		if err != nil {
 			logger.Errorf("Failed to fetch lvmid. %s. Please run with the java process user when monitoring local JVM, or set proper 'remote' option when monitorint remote one.", err)
 			os.Exit(1)
 		}
 		jvm.Lvmid = lvmid
-	} else {
+	default:
 		// https://docs.oracle.com/javase/7/docs/technotes/tools/share/jps.html
 		// `The lvmid is typically, but not necessarily, the operating system's process identifier for the JVM process.`
-		pid, err := os.ReadFile(*optPidFile)
+		pid, err := readPidFile(*optPidFile)
 		if err != nil {
 			logger.Errorf("Failed to load pid. %s", err)
 			os.Exit(1)
 		}
-		jvm.Lvmid = strings.Replace(string(pid), "\n", "", 1)
+		jvm.Lvmid = pid
 	}
 
 	jvm.JavaName = *optJavaName
 	jvm.MetricKey = *optMetricKey
 	jvm.MetricLabel = *optMetricLabel
+	jvm.GCLog = *optGCLog
 
 	helper := mp.NewMackerelPlugin(jvm)
 	helper.Tempfile = *optTempfile
@@ -19,6 +19,6 @@ var cliTempFile = cli.StringFlag{
 var cliType = cli.StringSliceFlag{
 	Name:   "type, p",
 	Value:  &cli.StringSlice{},
-	Usage:  "Select metrics type(s) to fetch: all, swap, netstat, diskstats, proc_stat, users",
+	Usage:  "Select metrics type(s) to fetch: all, swap, netstat, diskstats, proc_stat, processes, users",
 	EnvVar: "ENVVAR_TYPE",
 }
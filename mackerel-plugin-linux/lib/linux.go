@@ -21,6 +21,7 @@ const (
 	pathVmstat = "/proc/vmstat"
 	pathStat   = "/proc/stat"
 	pathSysfs  = "/sys"
+	pathProc   = "/proc"
 )
 
 var collectVirtualDevice = regexp.MustCompile("^fio[a-z]+$") // ioDrive(FusionIO)
@@ -69,6 +70,11 @@ func (c LinuxPlugin) GraphDefinition() map[string]mp.Graphs {
 		}
 	}
 
+	if c.Typemap["all"] || c.Typemap["processes"] {
+		// the graphs are fixed, so /proc is not walked
+		addProcessesGraphDef()
+	}
+
 	if c.Typemap["all"] || c.Typemap["users"] {
 		err = collectWho(&p)
 		if err != nil {
@@ -135,6 +141,13 @@ func (c LinuxPlugin) FetchMetrics() (map[string]any, error) {
 		}
 	}
 
+	if c.Typemap["all"] || c.Typemap["processes"] {
+		err = collectProcesses(pathProc, &p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if c.Typemap["all"] || c.Typemap["users"] {
 		err = collectWho(&p)
 		if err != nil {
@@ -224,11 +237,25 @@ func collectProcStat(path string, p *map[string]any) error {
 		return err
 	}
 	defer file.Close()
-	return parseProcStat(file, p)
+	return parseProcStat(file, procStatMetrics, p)
+}
+
+// maps the fields of /proc/stat to the metrics of proc_stat
+var procStatMetrics = map[string]string{
+	"intr":      "interrupts",
+	"ctxt":      "context_switches",
+	"processes": "forks",
+}
+
+// maps the fields of /proc/stat to the metrics of processes
+var processesMetrics = map[string]string{
+	"procs_running": "procs_running",
+	"procs_blocked": "procs_blocked",
 }
 
 // parsing metrics from /proc/stat
-func parseProcStat(r io.Reader, p *map[string]any) error {
+// Only the fields in metrics are set, since each type posts its own metrics of /proc/stat.
+func parseProcStat(r io.Reader, metrics map[string]string, p *map[string]any) error {
 	scanner := bufio.NewScanner(r)
 
 	for scanner.Scan() {
@@ -237,21 +264,213 @@ func parseProcStat(r io.Reader, p *map[string]any) error {
 		if len(record) < 2 {
 			continue
 		}
-		name := record[0]
+		key, ok := metrics[record[0]]
+		if !ok {
+			continue
+		}
 		value, errParse := atof(record[1])
 		if errParse != nil {
 			return errParse
 		}
+		(*p)[key] = value
+	}
+
+	return nil
+}
+
+// add the graphs of processes
+func addProcessesGraphDef() {
+	graphdef["linux.processes"] = mp.Graphs{
+		Label: "Linux Processes",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "procs_running", Label: "Running", Diff: false},
+			{Name: "procs_blocked", Label: "Blocked", Diff: false},
+		},
+	}
+	graphdef["linux.process_states"] = mp.Graphs{
+		Label: "Linux Process States",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "state_running", Label: "Running", Diff: false, Stacked: true},
+			{Name: "state_sleeping", Label: "Sleeping", Diff: false, Stacked: true},
+			{Name: "state_disk_sleep", Label: "Disk Sleep", Diff: false, Stacked: true},
+			{Name: "state_zombie", Label: "Zombie", Diff: false, Stacked: true},
+			{Name: "state_stopped", Label: "Stopped", Diff: false, Stacked: true},
+		},
+	}
+	graphdef["linux.threads"] = mp.Graphs{
+		Label: "Linux Threads",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "threads", Label: "Threads", Diff: false},
+		},
+	}
+	graphdef["linux.file_descriptors"] = mp.Graphs{
+		Label: "Linux File Descriptors",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "file_nr_allocated", Label: "Allocated", Diff: false},
+			{Name: "file_max", Label: "Max", Diff: false},
+		},
+	}
+	graphdef["linux.pids"] = mp.Graphs{
+		Label: "Linux PIDs",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "pid_used", Label: "Used", Diff: false},
+			{Name: "pid_max", Label: "Max", Diff: false},
+		},
+	}
+	graphdef["linux.entropy"] = mp.Graphs{
+		Label: "Linux Entropy",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "entropy_avail", Label: "Available", Diff: false},
+			{Name: "entropy_poolsize", Label: "Pool Size", Diff: false},
+		},
+	}
+}
 
-		switch name {
-		case "intr":
-			(*p)["interrupts"] = value
-		case "ctxt":
-			(*p)["context_switches"] = value
-		case "processes":
-			(*p)["forks"] = value
+// collect /proc/loadavg, /proc/stat, /proc/<pid>/stat and /proc/sys
+func collectProcesses(path string, p *map[string]any) error {
+	addProcessesGraphDef()
+
+	content, err := os.ReadFile(filepath.Join(path, "loadavg"))
+	if err != nil {
+		return err
+	}
+	if err := parseLoadavg(string(content), p); err != nil {
+		return err
+	}
+
+	file, err := os.Open(filepath.Join(path, "stat"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := parseProcStat(file, processesMetrics, p); err != nil {
+		return err
+	}
+
+	if err := collectProcessStates(path, p); err != nil {
+		return err
+	}
+
+	content, err = os.ReadFile(filepath.Join(path, "sys/fs/file-nr"))
+	if err != nil {
+		return err
+	}
+	if err := parseFileNr(string(content), p); err != nil {
+		return err
+	}
+
+	for key, name := range map[string]string{
+		"file_max":         "sys/fs/file-max",
+		"pid_max":          "sys/kernel/pid_max",
+		"entropy_avail":    "sys/kernel/random/entropy_avail",
+		"entropy_poolsize": "sys/kernel/random/poolsize",
+	} {
+		content, err = os.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			return err
 		}
+		(*p)[key], err = atof(strings.TrimSpace(string(content)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parsing metrics from /proc/loadavg
+func parseLoadavg(str string, p *map[string]any) error {
+	fields := strings.Fields(str)
+	if len(fields) < 4 {
+		return fmt.Errorf("unexpected loadavg format: %q", str)
+	}
+	// 4th field is "<runnable>/<total>" kernel scheduling entities (threads)
+	entities := strings.SplitN(fields[3], "/", 2)
+	if len(entities) != 2 {
+		return fmt.Errorf("unexpected loadavg format: %q", str)
+	}
+	threads, err := atof(entities[1])
+	if err != nil {
+		return err
+	}
+	(*p)["threads"] = threads
+	// every thread holds its own pid
+	(*p)["pid_used"] = threads
+
+	return nil
+}
+
+// walk /proc/<pid>/stat and count processes by state
+func collectProcessStates(path string, p *map[string]any) error {
+	for _, key := range []string{"state_running", "state_sleeping", "state_disk_sleep", "state_zombie", "state_stopped"} {
+		(*p)[key] = float64(0)
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "[0-9]*", "stat"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			// the process may exit while walking
+			continue
+		}
+		parseProcessStat(string(content), p)
+	}
+
+	return nil
+}
+
+// parsing the state field from /proc/<pid>/stat
+func parseProcessStat(str string, p *map[string]any) {
+	// comm (2nd field) may contain spaces and parentheses
+	i := strings.LastIndex(str, ")")
+	if i < 0 {
+		return
+	}
+	fields := strings.Fields(str[i+1:])
+	if len(fields) < 1 || fields[0] == "" {
+		return
+	}
+
+	var key string
+	switch fields[0][0] {
+	case 'R':
+		key = "state_running"
+	case 'S', 'I':
+		// idle kernel threads (I) are reported as sleeping, like ps does
+		key = "state_sleeping"
+	case 'D':
+		key = "state_disk_sleep"
+	case 'Z':
+		key = "state_zombie"
+	case 'T', 't':
+		key = "state_stopped"
+	default:
+		return
+	}
+	v, _ := (*p)[key].(float64)
+	(*p)[key] = v + 1
+}
+
+// parsing metrics from /proc/sys/fs/file-nr
+func parseFileNr(str string, p *map[string]any) error {
+	fields := strings.Fields(str)
+	if len(fields) < 3 {
+		return fmt.Errorf("unexpected file-nr format: %q", str)
+	}
+	allocated, err := atof(fields[0])
+	if err != nil {
+		return err
 	}
+	(*p)["file_nr_allocated"] = allocated
 
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -69,7 +70,7 @@ func TestParseProcStat(t *testing.T) {
  processes 1959410`
 	stat := make(map[string]any)
 
-	err := parseProcStat(bytes.NewBufferString(stub), &stat)
+	err := parseProcStat(bytes.NewBufferString(stub), procStatMetrics, &stat)
 	assert.Nil(t, err)
 	assert.EqualValues(t, stat["interrupts"], 614818624)
 	assert.EqualValues(t, stat["context_switches"], 879305394)
@@ -246,3 +247,107 @@ func TestCollectVirtualDevice(t *testing.T) {
 		assert.EqualValues(t, c.expected, collectVirtualDevice.Match([]byte(c.name)))
 	}
 }
+
+func TestCollectProcesses(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"loadavg":                         "0.20 0.18 0.12 2/80 11206\n",
+		"stat":                            "intr 614818624 122 8\nctxt 879305394\nprocesses 1959410\nprocs_running 2\nprocs_blocked 1\n",
+		"1/stat":                          "1 (systemd) S 0 1 1 0 -1 4194560 103279 3411270 93 1263 366 255 5185 1585 20 0 1 0 5 174866432 2933 18446744073709551615\n",
+		"1234/stat":                       "1234 (my (weird) proc) R 1 1234 1234 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 4 0 5 0 0 18446744073709551615\n",
+		"2345/stat":                       "2345 (defunct) Z 1 2345 2345 0 -1 4227084 0 0 0 0 0 0 0 0 20 0 1 0 5 0 0 18446744073709551615\n",
+		"sys/fs/file-nr":                  "5664\t0\t9223372036854775807\n",
+		"sys/fs/file-max":                 "9223372036854775807\n",
+		"sys/kernel/pid_max":              "4194304\n",
+		"sys/kernel/random/entropy_avail": "256\n",
+		"sys/kernel/random/poolsize":      "256\n",
+	} {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	p := make(map[string]any)
+
+	assert.Nil(t, collectProcesses(dir, &p))
+	assert.Equal(t, map[string]any{
+		"procs_running":     float64(2),
+		"procs_blocked":     float64(1),
+		"state_running":     float64(1),
+		"state_sleeping":    float64(1),
+		"state_disk_sleep":  float64(0),
+		"state_zombie":      float64(1),
+		"state_stopped":     float64(0),
+		"threads":           float64(80),
+		"pid_used":          float64(80),
+		"pid_max":           float64(4194304),
+		"file_nr_allocated": float64(5664),
+		"file_max":          float64(9223372036854775807),
+		"entropy_avail":     float64(256),
+		"entropy_poolsize":  float64(256),
+	}, p)
+}
+
+func TestGraphDefinition_Processes(t *testing.T) {
+	graphs := LinuxPlugin{Typemap: map[string]bool{"processes": true}}.GraphDefinition()
+	assert.Contains(t, graphs, "linux.processes")
+	assert.Contains(t, graphs, "linux.process_states")
+	assert.Contains(t, graphs, "linux.entropy")
+}
+
+func TestParseLoadavg(t *testing.T) {
+	stub := `0.20 0.18 0.12 1/80 11206`
+	stat := make(map[string]any)
+
+	err := parseLoadavg(stub, &stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["threads"], 80)
+	assert.EqualValues(t, stat["pid_used"], 80)
+
+	assert.NotNil(t, parseLoadavg("0.20 0.18 0.12", &stat))
+}
+
+func TestParseProcStat_Procs(t *testing.T) {
+	stub := `processes 1959410
+procs_running 3
+procs_blocked 1`
+	stat := make(map[string]any)
+
+	err := parseProcStat(bytes.NewBufferString(stub), processesMetrics, &stat)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"procs_running": float64(3), "procs_blocked": float64(1)}, stat)
+
+	stat = make(map[string]any)
+	err = parseProcStat(bytes.NewBufferString(stub), procStatMetrics, &stat)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"forks": float64(1959410)}, stat)
+}
+
+func TestParseProcessStat(t *testing.T) {
+	stubs := []string{
+		`1 (systemd) S 0 1 1 0 -1 4194560 103279 3411270 93 1263 366 255 5185 1585 20 0 1 0 5 174866432 2933 18446744073709551615`,
+		`1234 (my (weird) proc) R 1 1234 1234 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 4 0 5 0 0 18446744073709551615`,
+		`2345 (defunct) Z 1 2345 2345 0 -1 4227084 0 0 0 0 0 0 0 0 20 0 1 0 5 0 0 18446744073709551615`,
+		`3456 (kworker/0:1) I 2 0 0 0 -1 69238880 0 0 0 0 0 0 0 0 20 0 1 0 5 0 0 18446744073709551615`,
+		`4567 (dd) D 1 4567 4567 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 5 0 0 18446744073709551615`,
+		`5678 (vim) T 1 5678 5678 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 5 0 0 18446744073709551615`,
+	}
+	stat := make(map[string]any)
+
+	for _, s := range stubs {
+		parseProcessStat(s, &stat)
+	}
+	assert.EqualValues(t, stat["state_sleeping"], 2)
+	assert.EqualValues(t, stat["state_running"], 1)
+	assert.EqualValues(t, stat["state_zombie"], 1)
+	assert.EqualValues(t, stat["state_disk_sleep"], 1)
+	assert.EqualValues(t, stat["state_stopped"], 1)
+}
+
+func TestParseFileNr(t *testing.T) {
+	stub := "5664\t0\t9223372036854775807\n"
+	stat := make(map[string]any)
+
+	err := parseFileNr(stub, &stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["file_nr_allocated"], 5664)
+}
@@ -0,0 +1,123 @@
+package mpmemcached
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// binary protocol
+// See also. https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped
+
+const (
+	magicRequest  = 0x80
+	magicResponse = 0x81
+
+	opStat     = 0x10
+	opSASLAuth = 0x21
+
+	headerSize = 24
+)
+
+type binaryResponse struct {
+	status uint16
+	key    []byte
+	value  []byte
+}
+
+func writeBinaryRequest(w io.Writer, opcode byte, key, value []byte) error {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[0] = magicRequest
+	buf[1] = opcode
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(key)))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(key)+len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readBinaryResponse(r io.Reader) (*binaryResponse, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != magicResponse {
+		return nil, fmt.Errorf("invalid magic of the binary protocol: %#x", header[0])
+	}
+	keyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLen := int(header[4])
+	bodyLen := int(binary.BigEndian.Uint32(header[8:12]))
+	if keyLen+extrasLen > bodyLen {
+		return nil, fmt.Errorf("invalid body length of the binary protocol: %d", bodyLen)
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &binaryResponse{
+		status: binary.BigEndian.Uint16(header[6:8]),
+		key:    body[extrasLen : extrasLen+keyLen],
+		value:  body[extrasLen+keyLen:],
+	}, nil
+}
+
+// binaryConn is a connection speaking the binary protocol.
+// It translates the ASCII `stats` commands written to it into the binary protocol,
+// and formats the responses in the ASCII protocol so that they are parsed in the same way.
+type binaryConn struct {
+	conn io.ReadWriter
+	buf  bytes.Buffer
+}
+
+// authPlain authenticates with the SASL PLAIN mechanism.
+func (c *binaryConn) authPlain(user, password string) error {
+	if err := writeBinaryRequest(c.conn, opSASLAuth, []byte("PLAIN"), []byte("\x00"+user+"\x00"+password)); err != nil {
+		return err
+	}
+	res, err := readBinaryResponse(c.conn)
+	if err != nil {
+		return err
+	}
+	if res.status != 0 {
+		return fmt.Errorf("SASL authentication failed: %s", res.value)
+	}
+	return nil
+}
+
+func (c *binaryConn) Write(p []byte) (int, error) {
+	cmd := strings.Fields(string(p))
+	if len(cmd) == 0 || cmd[0] != "stats" {
+		return 0, fmt.Errorf("unsupported command in the binary protocol: %q", p)
+	}
+	var group string
+	if len(cmd) > 1 {
+		group = cmd[1]
+	}
+
+	if err := writeBinaryRequest(c.conn, opStat, []byte(group), nil); err != nil {
+		return 0, err
+	}
+	for {
+		res, err := readBinaryResponse(c.conn)
+		if err != nil {
+			return 0, err
+		}
+		if res.status != 0 {
+			return 0, fmt.Errorf("stats %s failed: %s", group, res.value)
+		}
+		// the response is terminated with an empty key
+		if len(res.key) == 0 {
+			break
+		}
+		fmt.Fprintf(&c.buf, "STAT %s %s\r\n", res.key, res.value)
+	}
+	c.buf.WriteString("END\r\n")
+	return len(p), nil
+}
+
+func (c *binaryConn) Read(p []byte) (int, error) {
+	return c.buf.Read(p)
+}
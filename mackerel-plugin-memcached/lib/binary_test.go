@@ -0,0 +1,130 @@
+package mpmemcached
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/tlsutil"
+)
+
+func writeBinaryResponse(w io.Writer, opcode byte, status uint16, key, value string) error {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[0] = magicResponse
+	buf[1] = opcode
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(key)))
+	binary.BigEndian.PutUint16(buf[6:8], status)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(key)+len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	_, err := w.Write(buf)
+	return err
+}
+
+// startBinaryStubServer starts a server which requires SASL PLAIN authentication
+// and responds to the stat commands of the binary protocol.
+func startBinaryStubServer(t *testing.T, user, password string, stats map[string][][2]string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				authenticated := false
+				for {
+					var header [headerSize]byte
+					if _, err := io.ReadFull(conn, header[:]); err != nil {
+						return
+					}
+					keyLen := binary.BigEndian.Uint16(header[2:4])
+					body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+					if _, err := io.ReadFull(conn, body); err != nil {
+						return
+					}
+					key, value := string(body[:keyLen]), string(body[keyLen:])
+					switch header[1] {
+					case opSASLAuth:
+						if key == "PLAIN" && value == "\x00"+user+"\x00"+password {
+							authenticated = true
+							writeBinaryResponse(conn, opSASLAuth, 0, "", "Authenticated")
+						} else {
+							writeBinaryResponse(conn, opSASLAuth, 0x20, "", "Auth failure")
+						}
+					case opStat:
+						if !authenticated {
+							writeBinaryResponse(conn, opStat, 0x20, "", "Auth failure")
+							continue
+						}
+						for _, kv := range stats[key] {
+							writeBinaryResponse(conn, opStat, 0, kv[0], kv[1])
+						}
+						writeBinaryResponse(conn, opStat, 0, "", "")
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestFetchStats_SASL(t *testing.T) {
+	addr := startBinaryStubServer(t, "mackerel", "secret", map[string][][2]string{
+		"": {
+			{"pid", "1"},
+			{"version", "1.6.21"},
+			{"get_hits", "80"},
+			{"total_items", "12"},
+		},
+		"items": {
+			{"items:1:evicted_nonzero", "3"},
+		},
+	})
+
+	m := MemcachedPlugin{Timeout: time.Second, SASLUser: "mackerel", SASLPassword: "secret"}
+	stat, err := m.fetchStats("tcp", addr)
+	require.NoError(t, err)
+	assert.EqualValues(t, 80, stat["get_hits"])
+	assert.EqualValues(t, 12, stat["new_items"])
+	assert.EqualValues(t, 3, stat["nonzero_evictions"])
+	assert.NotContains(t, stat, "version")
+
+	m.SASLPassword = "wrong"
+	_, err = m.fetchStats("tcp", addr)
+	assert.ErrorContains(t, err, "SASL authentication failed")
+}
+
+func TestFetchStats_TLS(t *testing.T) {
+	// borrow the self-signed certificate of httptest
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	certs := ts.TLS.Certificates
+	ts.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certs})
+	require.NoError(t, err)
+	addr := serveStub(t, l, map[string]float64{"get_hits": 80})
+
+	m := MemcachedPlugin{Timeout: time.Second, TLS: &tls.Config{}}
+	_, err = m.fetchStats("tcp", addr)
+	assert.Error(t, err, "the certificate is not trusted")
+
+	m.TLS, err = tlsutil.TLSConfig("", "", "", true)
+	require.NoError(t, err)
+	stat, err := m.fetchStats("tcp", addr)
+	require.NoError(t, err)
+	assert.EqualValues(t, 80, stat["get_hits"])
+}
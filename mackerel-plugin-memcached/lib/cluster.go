@@ -0,0 +1,362 @@
+package mpmemcached
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+const defaultPort = "11211"
+
+func clusterGraphDefinition(labelPrefix string) map[string]mp.Graphs {
+	return map[string]mp.Graphs{
+		"cluster.nodes": {
+			Label: (labelPrefix + " Cluster Nodes"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "nodes_up", Label: "Up", Stacked: true},
+				{Name: "nodes_down", Label: "Down", Stacked: true},
+			},
+		},
+		"cluster.connections": {
+			Label: (labelPrefix + " Cluster Connections"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "curr_connections", Label: "Connections"},
+			},
+		},
+		"cluster.cmd": {
+			Label: (labelPrefix + " Cluster Command"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "cmd_get", Label: "Get"},
+				{Name: "cmd_set", Label: "Set"},
+			},
+		},
+		"cluster.hitmiss": {
+			Label: (labelPrefix + " Cluster Hits/Misses"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "get_hits", Label: "Get Hits"},
+				{Name: "get_misses", Label: "Get Misses"},
+			},
+		},
+		"cluster.hit_ratio": {
+			Label: (labelPrefix + " Cluster Hit Ratio"),
+			Unit:  mp.UnitPercentage,
+			Metrics: []mp.Metrics{
+				{Name: "hit_ratio", Label: "Hit Ratio"},
+			},
+		},
+		"cluster.evictions": {
+			Label: (labelPrefix + " Cluster Evictions"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "evictions", Label: "Evictions"},
+			},
+		},
+		"cluster.cachesize": {
+			Label: (labelPrefix + " Cluster Cache Size"),
+			Unit:  mp.UnitBytes,
+			Metrics: []mp.Metrics{
+				{Name: "limit_maxbytes", Label: "Total"},
+				{Name: "bytes", Label: "Used"},
+			},
+		},
+		"cluster.items": {
+			Label: (labelPrefix + " Cluster Items"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "curr_items", Label: "Current Items"},
+			},
+		},
+		"node.up.#": {
+			Label: (labelPrefix + " Node Up"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "up", Label: "Up"},
+			},
+		},
+		"node.connections.#": {
+			Label: (labelPrefix + " Node Connections"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "curr_connections", Label: "Connections"},
+			},
+		},
+		"node.cmd.#": {
+			Label: (labelPrefix + " Node Command"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "cmd_get", Label: "Get", Diff: true},
+				{Name: "cmd_set", Label: "Set", Diff: true},
+			},
+		},
+		"node.hitmiss.#": {
+			Label: (labelPrefix + " Node Hits/Misses"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "get_hits", Label: "Get Hits", Diff: true},
+				{Name: "get_misses", Label: "Get Misses", Diff: true},
+			},
+		},
+		"node.hit_ratio.#": {
+			Label: (labelPrefix + " Node Hit Ratio"),
+			Unit:  mp.UnitPercentage,
+			Metrics: []mp.Metrics{
+				{Name: "hit_ratio", Label: "Hit Ratio"},
+			},
+		},
+		"node.evictions.#": {
+			Label: (labelPrefix + " Node Evictions"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "evictions", Label: "Evictions", Diff: true},
+			},
+		},
+		"node.cachesize.#": {
+			Label: (labelPrefix + " Node Cache Size"),
+			Unit:  mp.UnitBytes,
+			Metrics: []mp.Metrics{
+				{Name: "limit_maxbytes", Label: "Total"},
+				{Name: "bytes", Label: "Used"},
+			},
+		},
+		"node.items.#": {
+			Label: (labelPrefix + " Node Items"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "curr_items", Label: "Current Items"},
+			},
+		},
+	}
+}
+
+// nodeGraphs maps the stats of each node to the graphs of the cluster mode.
+var nodeGraphs = map[string]string{
+	"curr_connections": "connections",
+	"cmd_get":          "cmd",
+	"cmd_set":          "cmd",
+	"get_hits":         "hitmiss",
+	"get_misses":       "hitmiss",
+	"evictions":        "evictions",
+	"limit_maxbytes":   "cachesize",
+	"bytes":            "cachesize",
+	"curr_items":       "items",
+}
+
+// counterStats are the counters which are summed up as the rate per minute in the cluster metrics.
+var counterStats = []string{"cmd_get", "cmd_set", "get_hits", "get_misses", "evictions"}
+
+// gaugeStats are the stats which are summed up as is in the cluster metrics.
+var gaugeStats = []string{"curr_connections", "limit_maxbytes", "bytes", "curr_items"}
+
+var invalidNameChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// nodeName converts the address of a node to be usable in a metric name, e.g. "10_0_0_1_11211".
+func nodeName(node string) string {
+	return invalidNameChars.ReplaceAllString(node, "_")
+}
+
+// dialAddress returns the network and the address to dial node, which is "host[:port]" or a path of unix socket.
+func dialAddress(node string) (string, string) {
+	if strings.HasPrefix(node, "/") {
+		return "unix", node
+	}
+	if _, _, err := net.SplitHostPort(node); err != nil {
+		return "tcp", net.JoinHostPort(strings.Trim(node, "[]"), defaultPort)
+	}
+	return "tcp", node
+}
+
+// fetchClusterMetrics fetches the stats of all nodes concurrently.
+// Unreachable nodes are posted as down instead of failing the whole run.
+func (m MemcachedPlugin) fetchClusterMetrics(now time.Time) (map[string]float64, error) {
+	stats := make([]map[string]float64, len(m.Nodes))
+	var wg sync.WaitGroup
+	for i, node := range m.Nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			network, address := dialAddress(node)
+			stat, err := m.fetchStats(network, address)
+			if err != nil {
+				log.Printf("failed to fetch stats of %s: %s", node, err)
+				return
+			}
+			stats[i] = stat
+		}(i, node)
+	}
+	wg.Wait()
+
+	ret := make(map[string]float64)
+	counters := make(map[string]map[string]float64)
+	var up, down float64
+	for i, node := range m.Nodes {
+		name := nodeName(node)
+		stat := stats[i]
+		if stat == nil {
+			down++
+			ret["node.up."+name+".up"] = 0
+			continue
+		}
+		up++
+		ret["node.up."+name+".up"] = 1
+		for k, graph := range nodeGraphs {
+			if v, ok := stat[k]; ok {
+				ret["node."+graph+"."+name+"."+k] = v
+			}
+		}
+		for _, k := range gaugeStats {
+			ret[k] += stat[k]
+		}
+		c := make(map[string]float64, len(counterStats))
+		for _, k := range counterStats {
+			if v, ok := stat[k]; ok {
+				c[k] = v
+			}
+		}
+		counters[name] = c
+	}
+	ret["nodes_up"] = up
+	ret["nodes_down"] = down
+
+	if m.StateFile != "" {
+		if err := setClusterRates(ret, counters, m.StateFile, now); err != nil {
+			log.Printf("failed to calculate the cluster rates: %s", err)
+		}
+	}
+	return ret, nil
+}
+
+type clusterState struct {
+	LastTime time.Time                     `json:"last_time"`
+	Nodes    map[string]map[string]float64 `json:"nodes"`
+}
+
+func loadClusterState(path string) (*clusterState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var s clusterState
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func saveClusterState(path string, s clusterState) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(s)
+}
+
+// setClusterRates adds the cluster counters as the rate per minute, and the hit ratios to ret.
+// They are calculated from the difference of each node since the previous run,
+// so that the totals don't drop when a node is restarted or becomes unreachable.
+func setClusterRates(ret map[string]float64, counters map[string]map[string]float64, stateFile string, now time.Time) error {
+	last, err := loadClusterState(stateFile)
+	if err != nil {
+		// the state file is broken; overwrite it with the current counters
+		log.Println("loadClusterState (ignore):", err)
+	}
+	if err := saveClusterState(stateFile, clusterState{LastTime: now, Nodes: counters}); err != nil {
+		return err
+	}
+	if last == nil {
+		return nil
+	}
+	elapsed := now.Sub(last.LastTime)
+	if elapsed <= 0 || elapsed > 10*time.Minute {
+		return nil
+	}
+
+	totals := make(map[string]float64, len(counterStats))
+	for name, c := range counters {
+		lc, ok := last.Nodes[name]
+		if !ok {
+			continue
+		}
+		deltas := make(map[string]float64, len(counterStats))
+		reset := false
+		for _, k := range counterStats {
+			if c[k] < lc[k] {
+				reset = true
+				break
+			}
+			deltas[k] = c[k] - lc[k]
+		}
+		if reset {
+			log.Printf("counters of %s seem to be reset", name)
+			continue
+		}
+		for k, v := range deltas {
+			totals[k] += v
+		}
+		if gets := deltas["get_hits"] + deltas["get_misses"]; gets > 0 {
+			ret["node.hit_ratio."+name+".hit_ratio"] = deltas["get_hits"] * 100 / gets
+		}
+	}
+
+	for k, v := range totals {
+		ret[k] = v * float64(time.Minute) / float64(elapsed)
+	}
+	if gets := totals["get_hits"] + totals["get_misses"]; gets > 0 {
+		ret["hit_ratio"] = totals["get_hits"] * 100 / gets
+	}
+	return nil
+}
+
+// readNodesFile reads the addresses of nodes from file, one per line.
+// Empty lines and lines starting with "#" are ignored.
+func readNodesFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var nodes []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		nodes = append(nodes, line)
+	}
+	return nodes, s.Err()
+}
+
+// lookupNodes resolves the addresses of nodes from the DNS SRV record of name,
+// such as "_memcache._tcp.example.com".
+func lookupNodes(name string) ([]string, error) {
+	_, addrs, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host := strings.TrimSuffix(addr.Target, ".")
+		nodes = append(nodes, net.JoinHostPort(host, strconv.Itoa(int(addr.Port))))
+	}
+	return nodes, nil
+}
@@ -0,0 +1,132 @@
+package mpmemcached
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startStubServer starts a server which responds to `stats` and `stats items` with stats.
+func startStubServer(t *testing.T, stats map[string]float64) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return serveStub(t, l, stats)
+}
+
+func serveStub(t *testing.T, l net.Listener, stats map[string]float64) string {
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewScanner(conn)
+				for r.Scan() {
+					if r.Text() == "stats" {
+						for k, v := range stats {
+							fmt.Fprintf(conn, "STAT %s %v\r\n", k, v)
+						}
+					}
+					fmt.Fprint(conn, "END\r\n")
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestFetchClusterMetrics(t *testing.T) {
+	node1 := startStubServer(t, map[string]float64{"curr_connections": 10, "cmd_get": 100, "get_hits": 80, "get_misses": 20, "curr_items": 5})
+	node2 := startStubServer(t, map[string]float64{"curr_connections": 20, "cmd_get": 300, "get_hits": 150, "get_misses": 150, "curr_items": 7})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	down := l.Addr().String()
+	l.Close()
+
+	m := MemcachedPlugin{
+		Nodes:     []string{node1, node2, down},
+		Timeout:   time.Second,
+		StateFile: filepath.Join(t.TempDir(), "cluster"),
+	}
+	now := time.Now()
+	stat, err := m.fetchClusterMetrics(now)
+	require.NoError(t, err)
+
+	name1, name2, nameDown := nodeName(node1), nodeName(node2), nodeName(down)
+	assert.EqualValues(t, 2, stat["nodes_up"])
+	assert.EqualValues(t, 1, stat["nodes_down"])
+	assert.EqualValues(t, 1, stat["node.up."+name1+".up"])
+	assert.EqualValues(t, 0, stat["node.up."+nameDown+".up"])
+	assert.EqualValues(t, 10, stat["node.connections."+name1+".curr_connections"])
+	assert.EqualValues(t, 300, stat["node.cmd."+name2+".cmd_get"])
+	assert.EqualValues(t, 30, stat["curr_connections"])
+	assert.EqualValues(t, 12, stat["curr_items"])
+	assert.NotContains(t, stat, "hit_ratio")
+
+	// the cluster rates are calculated from the counters of the previous run
+	require.NoError(t, saveClusterState(m.StateFile, clusterState{
+		LastTime: now.Add(-2 * time.Minute),
+		Nodes: map[string]map[string]float64{
+			name1: {"cmd_get": 40, "get_hits": 30, "get_misses": 10},
+			name2: {"cmd_get": 400, "get_hits": 200, "get_misses": 200}, // restarted
+		},
+	}))
+	stat, err = m.fetchClusterMetrics(now)
+	require.NoError(t, err)
+	assert.EqualValues(t, 30, stat["cmd_get"])
+	assert.EqualValues(t, 25, stat["get_hits"])
+	assert.EqualValues(t, 5, stat["get_misses"])
+	assert.InDelta(t, 83.33, stat["hit_ratio"], 0.01)
+	assert.InDelta(t, 83.33, stat["node.hit_ratio."+name1+".hit_ratio"], 0.01)
+	assert.NotContains(t, stat, "node.hit_ratio."+name2+".hit_ratio")
+}
+
+func TestDialAddress(t *testing.T) {
+	tests := []struct {
+		node    string
+		network string
+		address string
+	}{
+		{"10.0.0.1:11212", "tcp", "10.0.0.1:11212"},
+		{"cache1.example.com", "tcp", "cache1.example.com:11211"},
+		{"[::1]", "tcp", "[::1]:11211"},
+		{"/var/run/memcached.sock", "unix", "/var/run/memcached.sock"},
+	}
+	for _, tt := range tests {
+		network, address := dialAddress(tt.node)
+		assert.Equal(t, tt.network, network, tt.node)
+		assert.Equal(t, tt.address, address, tt.node)
+	}
+	assert.Equal(t, "10_0_0_1_11211", nodeName("10.0.0.1:11211"))
+}
+
+func TestReadNodesFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nodes")
+	content := strings.Join([]string{"# ring", "10.0.0.1:11211", "", "  10.0.0.2:11211  ", "cache3"}, "\n")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+
+	nodes, err := readNodesFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:11211", "10.0.0.2:11211", "cache3"}, nodes)
+}
+
+func TestGraphDefinition_Cluster(t *testing.T) {
+	memcached := MemcachedPlugin{Prefix: "memcached", Nodes: []string{"10.0.0.1:11211"}}
+	graphdef := memcached.GraphDefinition()
+	assert.Contains(t, graphdef, "cluster.hit_ratio")
+	assert.Contains(t, graphdef, "node.up.#")
+	assert.NotContains(t, graphdef, "cmd")
+}
@@ -2,18 +2,26 @@ package mpmemcached
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"maps"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/golib/pluginutil"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/tlsutil"
 )
 
 // MemcachedPlugin mackerel plugin for memchached
@@ -22,6 +30,19 @@ type MemcachedPlugin struct {
 	Socket   string
 	Tempfile string
 	Prefix   string
+	Slabs    bool
+	Timeout  time.Duration
+
+	// TLS is the configuration to connect with TLS, or nil to connect in plain text.
+	TLS *tls.Config
+	// SASLUser enables SASL PLAIN authentication, which requires the binary protocol.
+	SASLUser     string
+	SASLPassword string
+
+	// Nodes are the addresses of nodes in the cluster mode.
+	Nodes []string
+	// StateFile keeps the counters of nodes to calculate the cluster metrics.
+	StateFile string
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -34,36 +55,80 @@ func (m MemcachedPlugin) MetricKeyPrefix() string {
 
 // FetchMetrics interface for mackerelplugin
 func (m MemcachedPlugin) FetchMetrics() (map[string]float64, error) {
+	if len(m.Nodes) > 0 {
+		return m.fetchClusterMetrics(time.Now())
+	}
 	network := "tcp"
 	target := m.Target
 	if m.Socket != "" {
 		network = "unix"
 		target = m.Socket
 	}
-	conn, err := net.Dial(network, target)
+	return m.fetchStats(network, target)
+}
+
+func (m MemcachedPlugin) dial(network, target string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: m.Timeout}
+	if m.TLS != nil {
+		return tls.DialWithDialer(dialer, network, target, m.TLS)
+	}
+	return dialer.Dial(network, target)
+}
+
+func (m MemcachedPlugin) fetchStats(network, target string) (map[string]float64, error) {
+	c, err := m.dial(network, target)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	defer c.Close()
+	if m.Timeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(m.Timeout)); err != nil {
+			return nil, err
+		}
+	}
 
-	fmt.Fprintln(conn, "stats")
+	var conn io.ReadWriter = c
+	if m.SASLUser != "" {
+		bc := &binaryConn{conn: c}
+		if err := bc.authPlain(m.SASLUser, m.SASLPassword); err != nil {
+			return nil, err
+		}
+		conn = bc
+	}
+
+	if _, err := fmt.Fprintln(conn, "stats"); err != nil {
+		return nil, err
+	}
 
 	ret, err := m.parseStats(conn)
 	if err != nil {
 		return nil, err
 	}
+	if ret == nil {
+		return nil, errors.New("unexpected end of `stats`")
+	}
 	ret2, err := m.parseStatsItems(conn)
 	if err != nil {
 		log.Printf("failed to get stats items: %s", err.Error())
 	} else {
 		maps.Copy(ret, ret2)
 	}
+	if m.Slabs && len(m.Nodes) == 0 {
+		ret3, err := m.parseStatsSlabs(conn)
+		if err != nil {
+			log.Printf("failed to get stats slabs: %s", err.Error())
+		} else {
+			maps.Copy(ret, ret3)
+		}
+	}
 	return ret, nil
 }
 
 func (m MemcachedPlugin) parseStatsItems(conn io.ReadWriter) (map[string]float64, error) {
 	ret := make(map[string]float64)
-	fmt.Fprint(conn, "stats items\r\n")
+	if _, err := fmt.Fprint(conn, "stats items\r\n"); err != nil {
+		return nil, err
+	}
 	scr := bufio.NewScanner(bufio.NewReader(conn))
 	for scr.Scan() {
 		// ex. STAT items:1:number 1
@@ -79,11 +144,15 @@ func (m MemcachedPlugin) parseStatsItems(conn io.ReadWriter) (map[string]float64
 		if len(fields2) != 3 {
 			return nil, fmt.Errorf("result of `stats items` is strange: %s", line)
 		}
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			continue
+		}
 		if fields2[2] == "evicted_nonzero" {
-			value, err := strconv.ParseFloat(fields[2], 64)
-			if err == nil {
-				ret["nonzero_evictions"] += value
-			}
+			ret["nonzero_evictions"] += value
+		}
+		if m.Slabs {
+			setSlabItemMetric(ret, fields2[1], fields2[2], value)
 		}
 	}
 	return ret, scr.Err()
@@ -118,6 +187,9 @@ func (m MemcachedPlugin) parseStats(conn io.Reader) (map[string]float64, error)
 // GraphDefinition interface for mackerelplugin
 func (m MemcachedPlugin) GraphDefinition() map[string]mp.Graphs {
 	labelPrefix := cases.Title(language.Und, cases.NoLower).String(m.Prefix)
+	if len(m.Nodes) > 0 {
+		return clusterGraphDefinition(labelPrefix)
+	}
 
 	// https://github.com/memcached/memcached/blob/master/doc/protocol.txt
 	var graphdef = map[string]mp.Graphs{
@@ -204,9 +276,15 @@ func (m MemcachedPlugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 	}
+	if m.Slabs {
+		maps.Copy(graphdef, slabGraphDefinition(labelPrefix))
+	}
 	return graphdef
 }
 
+// DefaultClusterTimeout is the timeout of each node in the cluster mode, where an unreachable node must not block the others.
+const DefaultClusterTimeout = 5 * time.Second
+
 // Do the plugin
 func Do() {
 	optHost := flag.String("host", "localhost", "Hostname")
@@ -214,11 +292,73 @@ func Do() {
 	optSocket := flag.String("socket", "", "Server socket (overrides hosts and port)")
 	optPrefix := flag.String("metric-key-prefix", "memcached", "Metric key prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optSlabs := flag.Bool("slabs", false, "Collect metrics of each slab class")
+	optTimeout := flag.Duration("timeout", DefaultClusterTimeout, "Timeout of each node (no timeout by default when a single node is monitored)")
+	optNodes := flag.String("nodes", "", "Comma separated addresses of the nodes in the cluster mode")
+	optNodesFile := flag.String("nodes-file", "", "File listing the addresses of the nodes in the cluster mode")
+	optNodesSRV := flag.String("nodes-srv", "", "DNS SRV record to resolve the nodes in the cluster mode")
+	optTLS := flag.Bool("tls", false, "Enables TLS connection")
+	optTLSCACert := flag.String("tls-ca-cert", "", "CA certificate file to verify the server")
+	optTLSClientCert := flag.String("tls-client-cert", "", "Client certificate file")
+	optTLSClientKey := flag.String("tls-client-key", "", "Client private key file")
+	optTLSSkipVerify := flag.Bool("tls-skip-verify", false, "Disable TLS certificate verification")
+	optSASLUser := flag.String("sasl-user", "", "Username of SASL PLAIN authentication (uses the binary protocol)")
+	optSASLPassword := flag.String("sasl-password", os.Getenv("MEMCACHED_SASL_PASSWORD"), "Password of SASL PLAIN authentication")
 	flag.Parse()
 
 	var memcached MemcachedPlugin
 
 	memcached.Prefix = *optPrefix
+	memcached.Slabs = *optSlabs
+	memcached.Timeout = *optTimeout
+	memcached.SASLUser = *optSASLUser
+	memcached.SASLPassword = *optSASLPassword
+	if *optTLS {
+		tlsConfig, err := tlsutil.TLSConfig(*optTLSCACert, *optTLSClientCert, *optTLSClientKey, *optTLSSkipVerify)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-memcached: %s\n", err)
+			os.Exit(1)
+		}
+		memcached.TLS = tlsConfig
+	}
+
+	if *optNodes != "" {
+		for node := range strings.SplitSeq(*optNodes, ",") {
+			if node = strings.TrimSpace(node); node != "" {
+				memcached.Nodes = append(memcached.Nodes, node)
+			}
+		}
+	}
+	if *optNodesFile != "" {
+		nodes, err := readNodesFile(*optNodesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-memcached: %s\n", err)
+			os.Exit(1)
+		}
+		memcached.Nodes = append(memcached.Nodes, nodes...)
+	}
+	if *optNodesSRV != "" {
+		nodes, err := lookupNodes(*optNodesSRV)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-memcached: %s\n", err)
+			os.Exit(1)
+		}
+		memcached.Nodes = append(memcached.Nodes, nodes...)
+	}
+	if len(memcached.Nodes) == 0 {
+		passed := false
+		flag.Visit(func(f *flag.Flag) { passed = passed || f.Name == "timeout" })
+		// a single node has been monitored without timeout
+		if !passed {
+			memcached.Timeout = 0
+		}
+	} else {
+		if *optTempfile != "" {
+			memcached.StateFile = *optTempfile + ".cluster"
+		} else {
+			memcached.StateFile = filepath.Join(pluginutil.PluginWorkDir(), "mackerel-plugin-memcached-cluster-"+memcached.Prefix)
+		}
+	}
 
 	if *optSocket != "" {
 		memcached.Socket = *optSocket
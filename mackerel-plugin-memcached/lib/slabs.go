@@ -0,0 +1,140 @@
+package mpmemcached
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+func slabGraphDefinition(labelPrefix string) map[string]mp.Graphs {
+	return map[string]mp.Graphs{
+		"slab.chunk_size.#": {
+			Label: (labelPrefix + " Slab Chunk Size"),
+			Unit:  mp.UnitBytes,
+			Metrics: []mp.Metrics{
+				{Name: "chunk_size", Label: "Chunk Size"},
+			},
+		},
+		"slab.chunks.#": {
+			Label: (labelPrefix + " Slab Chunks"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "used_chunks", Label: "Used", Stacked: true},
+				{Name: "free_chunks", Label: "Free", Stacked: true},
+			},
+		},
+		"slab.items.#": {
+			Label: (labelPrefix + " Slab Items"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "number", Label: "Items"},
+			},
+		},
+		"slab.evictions.#": {
+			Label: (labelPrefix + " Slab Evictions"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "evictions", Label: "Evicted", Diff: true},
+				{Name: "evicted_unfetched", Label: "Evicted unfetched", Diff: true},
+				{Name: "outofmemory", Label: "Out of memory", Diff: true},
+			},
+		},
+		"slab.age.#": {
+			Label: (labelPrefix + " Slab Oldest Item Age"),
+			Unit:  mp.UnitInteger,
+			Metrics: []mp.Metrics{
+				{Name: "age", Label: "Age (sec)"},
+			},
+		},
+		"slab.memory.#": {
+			Label: (labelPrefix + " Slab Memory"),
+			Unit:  mp.UnitBytes,
+			Metrics: []mp.Metrics{
+				{Name: "mem_requested", Label: "Requested"},
+				{Name: "allocated", Label: "Allocated"},
+			},
+		},
+	}
+}
+
+type slabMetric struct {
+	graph string
+	name  string
+}
+
+// slabItemsMetrics maps the fields of `stats items` to the slab metrics.
+// "evicted" is renamed because the wildcard graph would match "evicted_unfetched" by its prefix.
+var slabItemsMetrics = map[string]slabMetric{
+	"number":            {"items", "number"},
+	"evicted":           {"evictions", "evictions"},
+	"evicted_unfetched": {"evictions", "evicted_unfetched"},
+	"outofmemory":       {"evictions", "outofmemory"},
+	"age":               {"age", "age"},
+	"mem_requested":     {"memory", "mem_requested"},
+}
+
+// slabsGraphs maps the fields of `stats slabs` to the graphs of slab metrics.
+var slabsGraphs = map[string]string{
+	"chunk_size":    "chunk_size",
+	"used_chunks":   "chunks",
+	"free_chunks":   "chunks",
+	"mem_requested": "memory",
+}
+
+// setSlabItemMetric sets a field of `stats items` to ret if it is a metric of slab classes.
+func setSlabItemMetric(ret map[string]float64, class, field string, value float64) {
+	if m, ok := slabItemsMetrics[field]; ok {
+		ret["slab."+m.graph+"."+class+"."+m.name] = value
+	}
+}
+
+func (m MemcachedPlugin) parseStatsSlabs(conn io.ReadWriter) (map[string]float64, error) {
+	ret := make(map[string]float64)
+	if _, err := fmt.Fprint(conn, "stats slabs\r\n"); err != nil {
+		return nil, err
+	}
+	scr := bufio.NewScanner(bufio.NewReader(conn))
+	totalChunks := make(map[string]float64)
+	for scr.Scan() {
+		// ex. STAT 1:chunk_size 96
+		//     STAT active_slabs 1
+		line := scr.Text()
+		if line == "END" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("result of `stats slabs` is strange: %s", line)
+		}
+		class, field, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			// totals of all slab classes, e.g. total_malloced
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			continue
+		}
+		if field == "total_chunks" {
+			totalChunks[class] = value
+		}
+		if graph, ok := slabsGraphs[field]; ok {
+			ret["slab."+graph+"."+class+"."+field] = value
+		}
+	}
+	if err := scr.Err(); err != nil {
+		return nil, err
+	}
+
+	// memory allocated to chunks, to be compared with mem_requested to find out wasted memory
+	for class, chunks := range totalChunks {
+		if size, ok := ret["slab.chunk_size."+class+".chunk_size"]; ok {
+			ret["slab.memory."+class+".allocated"] = chunks * size
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,101 @@
+package mpmemcached
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubConn struct {
+	io.Reader
+	io.Writer
+}
+
+func newStubConn(resp string) (*stubConn, *bytes.Buffer) {
+	var req bytes.Buffer
+	return &stubConn{Reader: strings.NewReader(resp), Writer: &req}, &req
+}
+
+func TestParseStatsItems(t *testing.T) {
+	stub := `STAT items:1:number 5
+STAT items:1:age 3600
+STAT items:1:evicted 10
+STAT items:1:evicted_nonzero 2
+STAT items:1:evicted_unfetched 4
+STAT items:1:outofmemory 0
+STAT items:1:mem_requested 480
+STAT items:12:number 3
+STAT items:12:age 60
+STAT items:12:evicted 1
+STAT items:12:evicted_nonzero 1
+END
+`
+	conn, req := newStubConn(stub)
+	m := MemcachedPlugin{Slabs: true}
+	stat, err := m.parseStatsItems(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "stats items\r\n", req.String())
+
+	assert.EqualValues(t, 3, stat["nonzero_evictions"])
+	assert.EqualValues(t, 5, stat["slab.items.1.number"])
+	assert.EqualValues(t, 3600, stat["slab.age.1.age"])
+	assert.EqualValues(t, 10, stat["slab.evictions.1.evictions"])
+	assert.EqualValues(t, 4, stat["slab.evictions.1.evicted_unfetched"])
+	assert.EqualValues(t, 0, stat["slab.evictions.1.outofmemory"])
+	assert.EqualValues(t, 480, stat["slab.memory.1.mem_requested"])
+	assert.EqualValues(t, 60, stat["slab.age.12.age"])
+	assert.NotContains(t, stat, "slab.evictions.1.evicted_nonzero")
+
+	conn, _ = newStubConn(stub)
+	stat, err = MemcachedPlugin{}.parseStatsItems(conn)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"nonzero_evictions": 3}, stat)
+}
+
+func TestParseStatsSlabs(t *testing.T) {
+	stub := `STAT 1:chunk_size 96
+STAT 1:chunks_per_page 10922
+STAT 1:total_pages 1
+STAT 1:total_chunks 10922
+STAT 1:used_chunks 5
+STAT 1:free_chunks 10917
+STAT 1:free_chunks_end 0
+STAT 1:get_hits 12
+STAT 12:chunk_size 1184
+STAT 12:total_chunks 885
+STAT 12:used_chunks 3
+STAT 12:free_chunks 882
+STAT active_slabs 2
+STAT total_malloced 2097152
+END
+`
+	conn, req := newStubConn(stub)
+	stat, err := MemcachedPlugin{Slabs: true}.parseStatsSlabs(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "stats slabs\r\n", req.String())
+	assert.Equal(t, map[string]float64{
+		"slab.chunk_size.1.chunk_size":  96,
+		"slab.chunks.1.used_chunks":     5,
+		"slab.chunks.1.free_chunks":     10917,
+		"slab.memory.1.allocated":       96 * 10922,
+		"slab.chunk_size.12.chunk_size": 1184,
+		"slab.chunks.12.used_chunks":    3,
+		"slab.chunks.12.free_chunks":    882,
+		"slab.memory.12.allocated":      1184 * 885,
+	}, stat)
+
+	conn, _ = newStubConn("STAT broken\r\nEND\r\n")
+	_, err = MemcachedPlugin{Slabs: true}.parseStatsSlabs(conn)
+	assert.Error(t, err)
+}
+
+func TestGraphDefinition_Slabs(t *testing.T) {
+	memcached := MemcachedPlugin{Prefix: "memcached", Slabs: true}
+	graphdef := memcached.GraphDefinition()
+	assert.Len(t, graphdef, 15)
+	assert.Contains(t, graphdef, "slab.evictions.#")
+}
@@ -7,40 +7,102 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/golib/pluginutil"
 )
 
-var graphDef = map[string]mp.Graphs{
-	"multicore.cpu.#": {
-		Label: "MultiCore CPU",
-		Unit:  "percentage",
-		Metrics: []mp.Metrics{
-			{Name: "guest_nice", Label: "guest_nice", Diff: false, Stacked: true},
-			{Name: "guest", Label: "guest", Diff: false, Stacked: true},
-			{Name: "steal", Label: "steal", Diff: false, Stacked: true},
-			{Name: "softirq", Label: "softirq", Diff: false, Stacked: true},
-			{Name: "irq", Label: "irq", Diff: false, Stacked: true},
-			{Name: "iowait", Label: "ioWait", Diff: false, Stacked: true},
-			{Name: "idle", Label: "idle", Diff: false, Stacked: true},
-			{Name: "system", Label: "system", Diff: false, Stacked: true},
-			{Name: "nice", Label: "nice", Diff: false, Stacked: true},
-			{Name: "user", Label: "user", Diff: false, Stacked: true},
+const sysfsCPUDir = "/sys/devices/system/cpu"
+
+// MulticorePlugin mackerel plugin for multicore CPU metrics
+type MulticorePlugin struct {
+	Prefix    string
+	Tempfile  string
+	Softirqs  bool
+	Frequency bool
+	Throttle  bool
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (m MulticorePlugin) MetricKeyPrefix() string {
+	if m.Prefix == "" {
+		m.Prefix = "multicore"
+	}
+	return m.Prefix
+}
+
+// GraphDefinition interface for mackerelplugin
+func (m MulticorePlugin) GraphDefinition() map[string]mp.Graphs {
+	graphdef := map[string]mp.Graphs{
+		"cpu.#": {
+			Label: "MultiCore CPU",
+			Unit:  mp.UnitPercentage,
+			Metrics: []mp.Metrics{
+				{Name: "guest_nice", Label: "guest_nice", Diff: false, Stacked: true},
+				{Name: "guest", Label: "guest", Diff: false, Stacked: true},
+				{Name: "steal", Label: "steal", Diff: false, Stacked: true},
+				{Name: "softirq", Label: "softirq", Diff: false, Stacked: true},
+				{Name: "irq", Label: "irq", Diff: false, Stacked: true},
+				{Name: "iowait", Label: "ioWait", Diff: false, Stacked: true},
+				{Name: "idle", Label: "idle", Diff: false, Stacked: true},
+				{Name: "system", Label: "system", Diff: false, Stacked: true},
+				{Name: "nice", Label: "nice", Diff: false, Stacked: true},
+				{Name: "user", Label: "user", Diff: false, Stacked: true},
+			},
 		},
-	},
-	"multicore.loadavg_per_core": {
-		Label: "MultiCore loadavg5 per core",
-		Unit:  "float",
-		Metrics: []mp.Metrics{
-			{Name: "loadavg5", Label: "loadavg5", Diff: false, Stacked: false},
+		"loadavg_per_core": {
+			Label: "MultiCore loadavg5 per core",
+			Unit:  mp.UnitFloat,
+			Metrics: []mp.Metrics{
+				{Name: "loadavg5", Label: "loadavg5", Diff: false, Stacked: false},
+			},
 		},
-	},
+	}
+	if m.Softirqs {
+		graphdef["softirqs.#"] = mp.Graphs{
+			Label: "MultiCore softirqs",
+			Unit:  mp.UnitFloat,
+			Metrics: []mp.Metrics{
+				{Name: "hi", Label: "HI", Diff: true, Stacked: true},
+				{Name: "timer", Label: "TIMER", Diff: true, Stacked: true},
+				{Name: "net_tx", Label: "NET_TX", Diff: true, Stacked: true},
+				{Name: "net_rx", Label: "NET_RX", Diff: true, Stacked: true},
+				{Name: "block", Label: "BLOCK", Diff: true, Stacked: true},
+				{Name: "block_iopoll", Label: "BLOCK_IOPOLL", Diff: true, Stacked: true},
+				{Name: "irq_poll", Label: "IRQ_POLL", Diff: true, Stacked: true},
+				{Name: "tasklet", Label: "TASKLET", Diff: true, Stacked: true},
+				{Name: "sched", Label: "SCHED", Diff: true, Stacked: true},
+				{Name: "hrtimer", Label: "HRTIMER", Diff: true, Stacked: true},
+				{Name: "rcu", Label: "RCU", Diff: true, Stacked: true},
+			},
+		}
+	}
+	if m.Frequency {
+		graphdef["frequency.#"] = mp.Graphs{
+			Label: "MultiCore CPU frequency (MHz)",
+			Unit:  mp.UnitFloat,
+			Metrics: []mp.Metrics{
+				{Name: "current", Label: "current", Diff: false, Stacked: false},
+			},
+		}
+	}
+	if m.Throttle {
+		graphdef["thermal_throttle.#"] = mp.Graphs{
+			Label: "MultiCore thermal throttle",
+			Unit:  mp.UnitFloat,
+			Metrics: []mp.Metrics{
+				{Name: "core", Label: "core", Diff: true, Stacked: false},
+				{Name: "package", Label: "package", Diff: true, Stacked: false},
+			},
+		}
+	}
+	return graphdef
 }
 
 type saveItem struct {
@@ -237,6 +299,102 @@ func calculatePercentage(currentValue *uint64, lastValue *uint64, currentTotal u
 	return &ret
 }
 
+// parseSoftirqs parses /proc/softirqs, which has one column per cpu and one row per softirq type.
+func parseSoftirqs(out io.Reader) (map[string]map[string]uint64, error) {
+	scanner := bufio.NewScanner(out)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("cannot read softirqs header")
+	}
+	cpus := strings.Fields(scanner.Text())
+
+	result := make(map[string]map[string]uint64, len(cpus))
+	for _, cpu := range cpus {
+		result[strings.ToLower(cpu)] = make(map[string]uint64)
+	}
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(fields[0], ":"))
+		for i, valStr := range fields[1:] {
+			if i >= len(cpus) {
+				break
+			}
+			val, err := strconv.ParseUint(valStr, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			result[strings.ToLower(cpus[i])][name] = val
+		}
+	}
+	return result, scanner.Err()
+}
+
+func collectSoftirqs() (map[string]map[string]uint64, error) {
+	file, err := os.Open("/proc/softirqs")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseSoftirqs(file)
+}
+
+func readUintFile(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// collectFrequency returns the current frequency of each cpu in MHz.
+// cpus without cpufreq support (e.g. most virtual machines) are omitted.
+func collectFrequency(sysfsCPUDir string) (map[string]float64, error) {
+	paths, err := filepath.Glob(filepath.Join(sysfsCPUDir, "cpu[0-9]*", "cpufreq", "scaling_cur_freq"))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64, len(paths))
+	for _, path := range paths {
+		khz, err := readUintFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cpu := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		result[cpu] = float64(khz) / 1000.0
+	}
+	return result, nil
+}
+
+// collectThrottle returns the thermal throttle counters of each cpu.
+// These are exposed only on x86 with the thermal_throttle driver.
+func collectThrottle(sysfsCPUDir string) (map[string]map[string]uint64, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsCPUDir, "cpu[0-9]*", "thermal_throttle"))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]map[string]uint64, len(dirs))
+	for _, dir := range dirs {
+		counts := make(map[string]uint64)
+		for _, name := range []string{"core", "package"} {
+			val, err := readUintFile(filepath.Join(dir, name+"_throttle_count"))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			counts[name] = val
+		}
+		result[filepath.Base(filepath.Dir(dir))] = counts
+	}
+	return result, nil
+}
+
 func fetchLoadavg5() (float64, error) {
 	contentbytes, err := os.ReadFile("/proc/loadavg")
 	if err != nil {
@@ -255,102 +413,131 @@ func fetchLoadavg5() (float64, error) {
 	return 0.0, fmt.Errorf("cannot fetch loadavg5")
 }
 
-func printValue(key string, value *float64, time time.Time) {
+func setValue(metrics map[string]float64, key string, value *float64) {
 	if value != nil {
-		fmt.Printf("%s\t%f\t%d\n", key, *value, time.Unix())
+		metrics[key] = *value
 	}
 }
 
-func outputCPUUsage(cpuUsage []cpuPercentages, now time.Time) {
+func setCPUUsage(metrics map[string]float64, cpuUsage []cpuPercentages) {
 	for _, u := range cpuUsage {
-		printValue("multicore.cpu."+u.CPUName+".user", u.User, now)
-		printValue("multicore.cpu."+u.CPUName+".nice", u.Nice, now)
-		printValue("multicore.cpu."+u.CPUName+".system", u.System, now)
-		printValue("multicore.cpu."+u.CPUName+".idle", u.Idle, now)
-		printValue("multicore.cpu."+u.CPUName+".iowait", u.IoWait, now)
-		printValue("multicore.cpu."+u.CPUName+".irq", u.Irq, now)
-		printValue("multicore.cpu."+u.CPUName+".softirq", u.SoftIrq, now)
-		printValue("multicore.cpu."+u.CPUName+".steal", u.Steal, now)
-		printValue("multicore.cpu."+u.CPUName+".guest", u.Guest, now)
-		printValue("multicore.cpu."+u.CPUName+".guest_nice", u.GuestNice, now)
+		setValue(metrics, "cpu."+u.CPUName+".user", u.User)
+		setValue(metrics, "cpu."+u.CPUName+".nice", u.Nice)
+		setValue(metrics, "cpu."+u.CPUName+".system", u.System)
+		setValue(metrics, "cpu."+u.CPUName+".idle", u.Idle)
+		setValue(metrics, "cpu."+u.CPUName+".iowait", u.IoWait)
+		setValue(metrics, "cpu."+u.CPUName+".irq", u.Irq)
+		setValue(metrics, "cpu."+u.CPUName+".softirq", u.SoftIrq)
+		setValue(metrics, "cpu."+u.CPUName+".steal", u.Steal)
+		setValue(metrics, "cpu."+u.CPUName+".guest", u.Guest)
+		setValue(metrics, "cpu."+u.CPUName+".guest_nice", u.GuestNice)
 	}
 }
 
-func outputLoadavgPerCore(loadavgPerCore float64, now time.Time) {
-	printValue("multicore.loadavg_per_core.loadavg5", &loadavgPerCore, now)
-}
-
-func outputDefinitions() {
-	fmt.Println("# mackerel-agent-plugin")
-	var graphs mp.GraphDef
-	graphs.Graphs = graphDef
-
-	b, err := json.Marshal(graphs)
-	if err != nil {
-		log.Fatalln("OutputDefinitions: ", err)
+func setCounters(metrics map[string]float64, prefix string, counters map[string]map[string]uint64) {
+	for cpu, values := range counters {
+		for name, v := range values {
+			metrics[prefix+"."+cpu+"."+name] = float64(v)
+		}
 	}
-	fmt.Println(string(b))
 }
 
-func outputMulticore(tempFileName string) {
+// FetchMetrics interface for mackerelplugin
+func (m MulticorePlugin) FetchMetrics() (map[string]float64, error) {
 	now := time.Now()
+	metrics := make(map[string]float64)
+
+	if m.Softirqs {
+		softirqs, err := collectSoftirqs()
+		if err != nil {
+			return nil, fmt.Errorf("collectSoftirqs: %w", err)
+		}
+		setCounters(metrics, "softirqs", softirqs)
+	}
+	if m.Throttle {
+		throttle, err := collectThrottle(sysfsCPUDir)
+		if err != nil {
+			return nil, fmt.Errorf("collectThrottle: %w", err)
+		}
+		setCounters(metrics, "thermal_throttle", throttle)
+	}
+	if m.Frequency {
+		freqs, err := collectFrequency(sysfsCPUDir)
+		if err != nil {
+			return nil, fmt.Errorf("collectFrequency: %w", err)
+		}
+		for cpu, v := range freqs {
+			metrics["frequency."+cpu+".current"] = v
+		}
+	}
 
+	// CPU usage is calculated from the counters of the previous run, which are kept in our own state file
+	// because the percentages need the delta of every counter and the delta of total.
 	currentValues, err := collectProcStatValues()
 	if err != nil {
-		log.Fatalln("collectProcStatValues: ", err)
+		return nil, fmt.Errorf("collectProcStatValues: %w", err)
+	}
+	tempFileName := m.Tempfile
+	if tempFileName == "" {
+		tempFileName = generateTempfilePath(m.MetricKeyPrefix())
 	}
-
 	savedItem, err := fetchSavedItem(tempFileName)
 	if err != nil {
-		log.Fatalln("fetchLastValues: ", err)
+		return nil, fmt.Errorf("fetchLastValues: %w", err)
 	}
 	err = saveValues(tempFileName, currentValues, now)
 	if err != nil {
-		log.Fatalln("saveValues: ", err)
+		return nil, fmt.Errorf("saveValues: %w", err)
 	}
 
 	// maybe first time run
 	if savedItem == nil {
-		return
+		return metrics, nil
 	}
 
 	cpuUsage, err := calcCPUUsage(currentValues, now, savedItem)
 	if err != nil {
-		log.Fatalln("calcCPUUsage: ", err)
+		return nil, fmt.Errorf("calcCPUUsage: %w", err)
 	}
+	setCPUUsage(metrics, cpuUsage)
 
 	loadavg5, err := fetchLoadavg5()
 	if err != nil {
-		log.Fatalln("fetchLoadavg5: ", err)
+		return nil, fmt.Errorf("fetchLoadavg5: %w", err)
 	}
-	loadPerCPUCount := loadavg5 / (float64(len(cpuUsage)))
+	metrics["loadavg_per_core.loadavg5"] = loadavg5 / (float64(len(cpuUsage)))
 
-	outputCPUUsage(cpuUsage, now)
-	outputLoadavgPerCore(loadPerCPUCount, now)
+	return metrics, nil
 }
 
-func generateTempfilePath() string {
-	dir := os.Getenv("MACKEREL_PLUGIN_WORKDIR")
-	if dir == "" {
-		dir = os.TempDir()
-	}
-	return filepath.Join(dir, "mackerel-plugin-multicore")
+var tempfileSanitizeReg = regexp.MustCompile(`[^-_.A-Za-z0-9]`)
+
+// generateTempfilePath returns the path of the state file of CPU usage for the prefix,
+// so that the plugins with different prefixes don't share it.
+func generateTempfilePath(prefix string) string {
+	return filepath.Join(pluginutil.PluginWorkDir(), "mackerel-plugin-"+tempfileSanitizeReg.ReplaceAllString(prefix, "_"))
 }
 
 // Do the plugin
 func Do() {
-	var tempFileName string
+	optPrefix := flag.String("metric-key-prefix", "multicore", "Metric key prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optSoftirqs := flag.Bool("softirqs", false, "Collect per-core softirq counts from /proc/softirqs")
+	optFrequency := flag.Bool("frequency", false, "Collect per-core current frequency from cpufreq")
+	optThrottle := flag.Bool("thermal-throttle", false, "Collect per-core thermal throttle counts")
 	flag.Parse()
 
-	tempFileName = *optTempfile
-	if tempFileName == "" {
-		tempFileName = generateTempfilePath()
+	m := MulticorePlugin{
+		Prefix:    *optPrefix,
+		Tempfile:  *optTempfile,
+		Softirqs:  *optSoftirqs,
+		Frequency: *optFrequency,
+		Throttle:  *optThrottle,
 	}
-
-	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
-		outputDefinitions()
-	} else {
-		outputMulticore(tempFileName)
+	helper := mp.NewMackerelPlugin(m)
+	if m.Tempfile != "" {
+		// the counters diffed by the helper are saved next to our own state file
+		helper.Tempfile = m.Tempfile + "-counters"
 	}
+	helper.Run()
 }
@@ -1,6 +1,8 @@
 package mpmulticore
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -105,3 +107,110 @@ func TestParseProcStatsOldKernel(t *testing.T) {
 		t.Errorf("parseProcStat: guest should be nil, but '%d'", *stat["cpu0"].Guest)
 	}
 }
+
+func TestParseSoftirqs(t *testing.T) {
+	stab := `                    CPU0       CPU1
+          HI:          1          0
+       TIMER:    3411270    2815417
+      NET_TX:        135         82
+      NET_RX:     104280      52781
+       BLOCK:      15466      11053
+    IRQ_POLL:          0          0
+     TASKLET:         35         18
+       SCHED:    2497386    2287301
+     HRTIMER:         51         30
+         RCU:    1863290    1802112`
+
+	stat, err := parseSoftirqs(strings.NewReader(stab))
+	if err != nil {
+		t.Fatalf("parseSoftirqs: %s", err)
+	}
+	if len(stat) != 2 {
+		t.Errorf("parseSoftirqs: size should be 2, but '%d'", len(stat))
+	}
+	if stat["cpu0"]["net_rx"] != 104280 {
+		t.Errorf("parseSoftirqs: net_rx should be 104280, but '%d'", stat["cpu0"]["net_rx"])
+	}
+	if stat["cpu1"]["timer"] != 2815417 {
+		t.Errorf("parseSoftirqs: timer should be 2815417, but '%d'", stat["cpu1"]["timer"])
+	}
+}
+
+func TestGraphDefinition(t *testing.T) {
+	m := MulticorePlugin{}
+	if n := len(m.GraphDefinition()); n != 2 {
+		t.Errorf("GraphDefinition: size should be 2, but '%d'", n)
+	}
+	if p := m.MetricKeyPrefix(); p != "multicore" {
+		t.Errorf("MetricKeyPrefix: should be multicore, but '%s'", p)
+	}
+
+	m = MulticorePlugin{Softirqs: true, Frequency: true, Throttle: true}
+	graphdef := m.GraphDefinition()
+	for _, key := range []string{"cpu.#", "loadavg_per_core", "softirqs.#", "frequency.#", "thermal_throttle.#"} {
+		if _, ok := graphdef[key]; !ok {
+			t.Errorf("GraphDefinition: %s should be defined", key)
+		}
+	}
+}
+
+func TestGenerateTempfilePath(t *testing.T) {
+	if p := filepath.Base(generateTempfilePath("multicore")); p != "mackerel-plugin-multicore" {
+		t.Errorf("generateTempfilePath: should be mackerel-plugin-multicore, but '%s'", p)
+	}
+	if p := filepath.Base(generateTempfilePath("web/multicore")); p != "mackerel-plugin-web_multicore" {
+		t.Errorf("generateTempfilePath: should be mackerel-plugin-web_multicore, but '%s'", p)
+	}
+}
+
+func TestSetCPUUsage(t *testing.T) {
+	user := 12.5
+	metrics := make(map[string]float64)
+	setCPUUsage(metrics, []cpuPercentages{{CPUName: "cpu0", User: &user}})
+	setCounters(metrics, "softirqs", map[string]map[string]uint64{"cpu1": {"net_rx": 42}})
+
+	if len(metrics) != 2 {
+		t.Errorf("metrics: size should be 2, but '%d'", len(metrics))
+	}
+	if metrics["cpu.cpu0.user"] != 12.5 {
+		t.Errorf("setCPUUsage: user should be 12.5, but '%f'", metrics["cpu.cpu0.user"])
+	}
+	if metrics["softirqs.cpu1.net_rx"] != 42 {
+		t.Errorf("setCounters: net_rx should be 42, but '%f'", metrics["softirqs.cpu1.net_rx"])
+	}
+}
+
+func TestCollectFrequencyAndThrottle(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cpu0/cpufreq/scaling_cur_freq":                "2400000\n",
+		"cpu1/cpufreq/scaling_cur_freq":                "800000\n",
+		"cpu0/thermal_throttle/core_throttle_count":    "3\n",
+		"cpu0/thermal_throttle/package_throttle_count": "5\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	freqs, err := collectFrequency(dir)
+	if err != nil {
+		t.Fatalf("collectFrequency: %s", err)
+	}
+	if freqs["cpu0"] != 2400 || freqs["cpu1"] != 800 {
+		t.Errorf("collectFrequency: unexpected result %v", freqs)
+	}
+
+	throttle, err := collectThrottle(dir)
+	if err != nil {
+		t.Fatalf("collectThrottle: %s", err)
+	}
+	if throttle["cpu0"]["core"] != 3 || throttle["cpu0"]["package"] != 5 {
+		t.Errorf("collectThrottle: unexpected result %v", throttle)
+	}
+}
@@ -0,0 +1,95 @@
+package mpnginx
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// connection counters of nginx, which are reset when nginx is restarted or reloaded
+var counterKeys = []string{"accepts", "handled", "requests"}
+
+type counterState struct {
+	LastTime time.Time          `json:"last_time"`
+	Counters map[string]float64 `json:"counters"`
+}
+
+func saveCounters(path string, counters map[string]float64, now time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(counterState{LastTime: now, Counters: counters})
+}
+
+func loadCounters(path string) (*counterState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var s counterState
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// setDerivedMetrics adds the number of dropped connections (accepts - handled) to stat.
+// If stateFile is set, it also compares the counters with the previous run to
+// calculate requests per connection and to detect counter resets.
+// When the counters are reset, they are removed from stat so that no negative diff is posted.
+func setDerivedMetrics(stat map[string]any, stateFile string, now time.Time) error {
+	counters := make(map[string]float64, len(counterKeys))
+	for _, k := range counterKeys {
+		if v, ok := stat[k].(float64); ok {
+			counters[k] = v
+		}
+	}
+	accepts, hasAccepts := counters["accepts"]
+	handled, hasHandled := counters["handled"]
+	if hasAccepts && hasHandled {
+		stat["dropped"] = accepts - handled
+	}
+	if stateFile == "" {
+		return nil
+	}
+
+	last, err := loadCounters(stateFile)
+	if err != nil {
+		// the state file is broken; overwrite it with the current counters
+		log.Println("loadCounters (ignore):", err)
+	}
+	if err := saveCounters(stateFile, counters, now); err != nil {
+		return err
+	}
+	if last == nil || now.Sub(last.LastTime) > 10*time.Minute {
+		return nil
+	}
+
+	for k, v := range counters {
+		if lastValue, ok := last.Counters[k]; ok && v < lastValue {
+			log.Printf("counters seem to be reset (%s: %.0f -> %.0f)", k, lastValue, v)
+			for _, k := range counterKeys {
+				delete(stat, k)
+			}
+			delete(stat, "dropped")
+			return nil
+		}
+	}
+
+	requests, hasRequests := counters["requests"]
+	lastRequests, hasLastRequests := last.Counters["requests"]
+	lastHandled, hasLastHandled := last.Counters["handled"]
+	if hasRequests && hasLastRequests && hasHandled && hasLastHandled && handled > lastHandled {
+		stat["requests_per_connection"] = (requests - lastRequests) / (handled - lastHandled)
+	}
+	return nil
+}
@@ -2,18 +2,22 @@ package mpnginx
 
 import (
 	"bufio"
-	"crypto/tls"
+	"crypto/sha1"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-
-	"errors"
-	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/golib/pluginutil"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 )
 
 var graphdef = map[string]mp.Graphs{
@@ -42,24 +46,30 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "waiting", Label: "Waiting", Diff: false},
 		},
 	},
-}
-
-type stringSlice []string
-
-func (s *stringSlice) Set(v string) error {
-	*s = append(*s, v)
-	return nil
-}
-
-func (s *stringSlice) String() string {
-	return fmt.Sprintf("%v", *s)
+	"nginx.dropped": {
+		Label: "Nginx Dropped Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "dropped", Label: "Dropped connections", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.requests_per_connection": {
+		Label: "Nginx Requests per Connection",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "requests_per_connection", Label: "Requests per connection", Diff: false},
+		},
+	},
 }
 
 // NginxPlugin mackerel plugin for Nginx
 type NginxPlugin struct {
-	URI           string
-	Header        stringSlice
-	TLSSkipVerify bool
+	URI string
+	// Format is the format of the status: "stub_status" (default), "plus" or "vts"
+	Format string
+	// StateFile keeps the counters of the previous run to detect resets; disabled if empty
+	StateFile string
+	httpclient.Options
 }
 
 // % wget -qO- http://localhost:8080/nginx_status
@@ -70,109 +80,91 @@ type NginxPlugin struct {
 
 // FetchMetrics interface for mackerelplugin
 func (n NginxPlugin) FetchMetrics() (map[string]any, error) {
-	req, err := http.NewRequest("GET", n.URI, nil)
+	n.UserAgent = "mackerel-plugin-nginx"
+	client, err := n.Options.NewClient()
 	if err != nil {
 		return nil, err
 	}
-	for _, h := range n.Header {
-		kv := strings.SplitN(h, ":", 2)
-		var k, v string
-		k = strings.TrimSpace(kv[0])
-		if len(kv) == 2 {
-			v = strings.TrimSpace(kv[1])
-		}
-		if http.CanonicalHeaderKey(k) == "Host" {
-			req.Host = v
-		} else {
-			req.Header.Set(k, v)
-		}
-	}
 
-	// set default User-Agent unless specified by n.Header
-	if _, ok := req.Header["User-Agent"]; !ok {
-		req.Header.Set("User-Agent", "mackerel-plugin-nginx")
+	stat, err := n.fetchStats(client)
+	if err != nil {
+		return nil, err
+	}
+	if err := setDerivedMetrics(stat, n.StateFile, time.Now()); err != nil {
+		return nil, err
 	}
+	return stat, nil
+}
 
-	client := http.Client{}
-	if n.TLSSkipVerify {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+func (n NginxPlugin) fetchStats(client *httpclient.Client) (map[string]any, error) {
+	if n.Format == "plus" {
+		s, err := n.fetchPlusStats(client)
+		if err != nil {
+			return nil, err
 		}
-		client.Transport = tr
+		return parsePlusStats(s), nil
 	}
-	resp, err := client.Do(req)
+
+	resp, err := client.Get(n.URI)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if n.Format == "vts" {
+		return parseVTSStats(resp.Body)
+	}
 	return n.parseStats(resp.Body)
 }
 
+var (
+	activeConnectionsRe = regexp.MustCompile(`^Active connections:\s*([0-9]+)`)
+	countersRe          = regexp.MustCompile(`^([0-9]+)\s+([0-9]+)\s+([0-9]+)`)
+	queueRe             = regexp.MustCompile(`Reading:\s*([0-9]+)\s+Writing:\s*([0-9]+)\s+Waiting:\s*([0-9]+)`)
+)
+
+// parseStats parses the output of stub_status.
+// Lines are matched by their content rather than their position, so that additional lines and columns
+// of variants such as Tengine (request_time column) or OpenResty are ignored.
 func (n NginxPlugin) parseStats(body io.Reader) (map[string]any, error) {
 	stat := make(map[string]any)
 
-	r := bufio.NewReader(body)
-	line, _, err := r.ReadLine()
-	if err != nil {
-		return nil, errors.New("cannot get values")
-	}
-	re := regexp.MustCompile("Active connections: ([0-9]+)")
-	res := re.FindStringSubmatch(string(line))
-	if res == nil || len(res) != 2 {
-		return nil, errors.New("cannot get values")
-	}
-	stat["connections"], err = strconv.ParseFloat(res[1], 64)
-	if err != nil {
-		return nil, errors.New("cannot get values")
-	}
-
-	_, _, err = r.ReadLine()
-	if err != nil {
-		return nil, errors.New("cannot get values")
-	}
-
-	line, _, err = r.ReadLine()
-	if err != nil {
-		return nil, errors.New("cannot get values")
-	}
-	re = regexp.MustCompile("([0-9]+) ([0-9]+) ([0-9]+)")
-	res = re.FindStringSubmatch(string(line))
-	if res == nil || len(res) != 4 {
-		return nil, errors.New("cannot get values")
-	}
-	stat["accepts"], err = strconv.ParseFloat(res[1], 64)
-	if err != nil {
-		return nil, errors.New("cannot get values")
-	}
-	stat["handled"], err = strconv.ParseFloat(res[2], 64)
-	if err != nil {
-		return nil, errors.New("cannot get values")
+	var inCounters bool
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if res := activeConnectionsRe.FindStringSubmatch(line); res != nil {
+			stat["connections"], _ = strconv.ParseFloat(res[1], 64)
+			continue
+		}
+		// "server accepts handled requests" is followed by the line of the counters
+		if strings.HasPrefix(line, "server accepts handled requests") {
+			inCounters = true
+			continue
+		}
+		if inCounters {
+			inCounters = false
+			if res := countersRe.FindStringSubmatch(line); res != nil {
+				stat["accepts"], _ = strconv.ParseFloat(res[1], 64)
+				stat["handled"], _ = strconv.ParseFloat(res[2], 64)
+				stat["requests"], _ = strconv.ParseFloat(res[3], 64)
+			}
+			continue
+		}
+		if res := queueRe.FindStringSubmatch(line); res != nil {
+			stat["reading"], _ = strconv.ParseFloat(res[1], 64)
+			stat["writing"], _ = strconv.ParseFloat(res[2], 64)
+			stat["waiting"], _ = strconv.ParseFloat(res[3], 64)
+		}
 	}
-	stat["requests"], err = strconv.ParseFloat(res[3], 64)
-	if err != nil {
-		return nil, errors.New("cannot get values")
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	line, _, err = r.ReadLine()
-	if err != nil {
+	if _, ok := stat["connections"]; !ok {
 		return nil, errors.New("cannot get values")
 	}
-	re = regexp.MustCompile("Reading: ([0-9]+) Writing: ([0-9]+) Waiting: ([0-9]+)")
-	res = re.FindStringSubmatch(string(line))
-	if res == nil || len(res) != 4 {
-		return nil, errors.New("cannot get values")
-	}
-	stat["reading"], err = strconv.ParseFloat(res[1], 64)
-	if err != nil {
-		return nil, errors.New("cannot get values")
-	}
-	stat["writing"], err = strconv.ParseFloat(res[2], 64)
-	if err != nil {
-		return nil, errors.New("cannot get values")
-	}
-	stat["waiting"], err = strconv.ParseFloat(res[3], 64)
-	if err != nil {
+	if _, ok := stat["accepts"]; !ok {
 		return nil, errors.New("cannot get values")
 	}
 	return stat, nil
@@ -180,9 +172,22 @@ func (n NginxPlugin) parseStats(body io.Reader) (map[string]any, error) {
 
 // GraphDefinition interface for mackerelplugin
 func (n NginxPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	if n.Format != "plus" && n.Format != "vts" {
+		return graphdef
+	}
+	graphs := make(map[string]mp.Graphs, len(graphdef)+len(zoneGraphdef))
+	for k, g := range graphdef {
+		graphs[k] = g
+	}
+	for k, g := range zoneGraphdef {
+		graphs[k] = g
+	}
+	return graphs
 }
 
+// DefaultPlusPath is the default path of the nginx Plus API, whose version 9 is available since nginx Plus R30.
+const DefaultPlusPath = "/api/9"
+
 // Do the plugin
 func Do() {
 	optURI := flag.String("uri", "", "URI")
@@ -190,20 +195,40 @@ func Do() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "8080", "Port")
 	optPath := flag.String("path", "/nginx_status", "Path")
+	optFormat := flag.String("format", "stub_status", "Format of the status: stub_status, plus (nginx Plus API) or vts (nginx-module-vts JSON)")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
-	optHeader := &stringSlice{}
-	flag.Var(optHeader, "header", "Set http header (e.g. \"Host: servername\")")
-	optTLSSkipVerify := flag.Bool("tls-skip-verify", false, "Skip TLS certificate verification")
+	var nginx NginxPlugin
+	nginx.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	var nginx NginxPlugin
+	path := *optPath
+	if *optFormat == "plus" {
+		passed := false
+		flag.Visit(func(f *flag.Flag) { passed = passed || f.Name == "path" })
+		// the status page of stub_status isn't available with the nginx Plus API
+		if !passed {
+			path = DefaultPlusPath
+		}
+	}
 	if *optURI != "" {
 		nginx.URI = *optURI
 	} else {
-		nginx.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
+		nginx.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, path)
+	}
+
+	switch *optFormat {
+	case "stub_status", "plus", "vts":
+		nginx.Format = *optFormat
+	default:
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-nginx: invalid format: %s\n", *optFormat)
+		os.Exit(1)
+	}
+
+	if *optTempfile != "" {
+		nginx.StateFile = *optTempfile + ".counters"
+	} else {
+		nginx.StateFile = filepath.Join(pluginutil.PluginWorkDir(), fmt.Sprintf("mackerel-plugin-nginx-counters-%x", sha1.Sum([]byte(nginx.URI))))
 	}
-	nginx.TLSSkipVerify = *optTLSSkipVerify
-	nginx.Header = *optHeader
 
 	helper := mp.NewMackerelPlugin(nginx)
 	helper.Tempfile = *optTempfile
@@ -5,18 +5,21 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGraphDefinition(t *testing.T) {
 	var nginx NginxPlugin
 
 	graphdef := nginx.GraphDefinition()
-	if len(graphdef) != 3 {
-		t.Errorf("GetTempfilename: %d should be 3", len(graphdef))
+	if len(graphdef) != 5 {
+		t.Errorf("GetTempfilename: %d should be 5", len(graphdef))
 	}
 }
 
@@ -40,6 +43,70 @@ func TestParse(t *testing.T) {
 	assert.EqualValues(t, stat["accepts"], 1693613501)
 }
 
+func TestParseVariants(t *testing.T) {
+	cases := []struct {
+		name string
+		stub string
+	}{
+		{
+			name: "tengine",
+			stub: `Active connections: 123
+server accepts handled requests request_time
+ 1693613501 1693613490 7996986318 123456
+Reading: 66 Writing: 16 Waiting: 41
+`,
+		},
+		{
+			name: "extra lines",
+			stub: `Active connections: 123 
+server accepts handled requests
+ 1693613501 1693613490 7996986318 
+Reading: 66 Writing: 16 Waiting: 41 
+Lua shared dicts: 2
+`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var nginx NginxPlugin
+			stat, err := nginx.parseStats(bytes.NewBufferString(tc.stub))
+			require.NoError(t, err)
+			assert.EqualValues(t, 123, stat["connections"])
+			assert.EqualValues(t, 1693613490, stat["handled"])
+			assert.EqualValues(t, 7996986318, stat["requests"])
+			assert.EqualValues(t, 41, stat["waiting"])
+		})
+	}
+
+	var nginx NginxPlugin
+	_, err := nginx.parseStats(bytes.NewBufferString("<html>502 Bad Gateway</html>\n"))
+	assert.Error(t, err)
+}
+
+func TestSetDerivedMetrics(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "counters")
+	now := time.Now()
+
+	stat := map[string]any{"accepts": 1000.0, "handled": 990.0, "requests": 5000.0}
+	require.NoError(t, setDerivedMetrics(stat, stateFile, now))
+	assert.EqualValues(t, 10, stat["dropped"])
+	assert.NotContains(t, stat, "requests_per_connection")
+
+	stat = map[string]any{"accepts": 1100.0, "handled": 1090.0, "requests": 5500.0}
+	require.NoError(t, setDerivedMetrics(stat, stateFile, now.Add(time.Minute)))
+	assert.EqualValues(t, 5, stat["requests_per_connection"])
+
+	// nginx has been reloaded
+	stat = map[string]any{"connections": 3.0, "accepts": 10.0, "handled": 10.0, "requests": 20.0}
+	require.NoError(t, setDerivedMetrics(stat, stateFile, now.Add(2*time.Minute)))
+	assert.EqualValues(t, map[string]any{"connections": 3.0}, stat)
+
+	stat = map[string]any{"accepts": 20.0, "handled": 20.0, "requests": 40.0}
+	require.NoError(t, setDerivedMetrics(stat, stateFile, now.Add(3*time.Minute)))
+	assert.EqualValues(t, 2, stat["requests_per_connection"])
+	assert.EqualValues(t, 0, stat["dropped"])
+}
+
 func TestHTTP(t *testing.T) {
 	sv := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
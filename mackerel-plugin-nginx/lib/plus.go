@@ -0,0 +1,180 @@
+package mpnginx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
+)
+
+// nginx Plus API
+// See also. https://nginx.org/en/docs/http/ngx_http_api_module.html
+
+type plusConnections struct {
+	Accepted float64 `json:"accepted"`
+	Dropped  float64 `json:"dropped"`
+	Active   float64 `json:"active"`
+	Idle     float64 `json:"idle"`
+}
+
+type plusHTTPRequests struct {
+	Total   float64 `json:"total"`
+	Current float64 `json:"current"`
+}
+
+type plusServerZone struct {
+	Processing float64        `json:"processing"`
+	Requests   float64        `json:"requests"`
+	Responses  responseCounts `json:"responses"`
+	Received   float64        `json:"received"`
+	Sent       float64        `json:"sent"`
+}
+
+type plusPeer struct {
+	Server       string         `json:"server"`
+	State        string         `json:"state"`
+	Active       float64        `json:"active"`
+	Requests     float64        `json:"requests"`
+	Responses    responseCounts `json:"responses"`
+	Fails        float64        `json:"fails"`
+	Unavail      float64        `json:"unavail"`
+	ResponseTime *float64       `json:"response_time"`
+}
+
+type plusUpstream struct {
+	Peers []plusPeer `json:"peers"`
+}
+
+type plusCacheCounter struct {
+	Responses float64 `json:"responses"`
+}
+
+type plusCache struct {
+	Size        float64          `json:"size"`
+	MaxSize     float64          `json:"max_size"`
+	Hit         plusCacheCounter `json:"hit"`
+	Stale       plusCacheCounter `json:"stale"`
+	Updating    plusCacheCounter `json:"updating"`
+	Revalidated plusCacheCounter `json:"revalidated"`
+	Miss        plusCacheCounter `json:"miss"`
+	Expired     plusCacheCounter `json:"expired"`
+	Bypass      plusCacheCounter `json:"bypass"`
+}
+
+type plusStats struct {
+	Connections plusConnections
+	Requests    plusHTTPRequests
+	ServerZones map[string]plusServerZone
+	Upstreams   map[string]plusUpstream
+	Caches      map[string]plusCache
+	hasRequests bool
+}
+
+// fetchPlusStats fetches the endpoints of the nginx Plus API. n.URI is the versioned base URI such as "http://localhost:8080/api/9".
+func (n NginxPlugin) fetchPlusStats(client *httpclient.Client) (*plusStats, error) {
+	var s plusStats
+	base := strings.TrimSuffix(n.URI, "/")
+	if err := getJSON(client, base+"/connections", &s.Connections); err != nil {
+		return nil, err
+	}
+	// nginx Plus may be configured without http zones at all; only the connections are mandatory.
+	// The absent endpoints respond 404, and the other errors are not ignored.
+	optional := []struct {
+		path string
+		v    any
+	}{
+		{"/http/requests", &s.Requests},
+		{"/http/server_zones", &s.ServerZones},
+		{"/http/upstreams", &s.Upstreams},
+		{"/http/caches", &s.Caches},
+	}
+	for _, o := range optional {
+		err := getJSON(client, base+o.path, o.v)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if o.path == "/http/requests" {
+			s.hasRequests = true
+		}
+	}
+	return &s, nil
+}
+
+var errNotFound = errors.New("not found")
+
+func getJSON(client *httpclient.Client, uri string, v any) error {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errNotFound, uri)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status error: %d, URI: %s", resp.StatusCode, uri)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func parsePlusStats(s *plusStats) map[string]any {
+	stat := make(map[string]any)
+
+	stat["connections"] = s.Connections.Active
+	stat["waiting"] = s.Connections.Idle
+	stat["accepts"] = s.Connections.Accepted
+	stat["handled"] = s.Connections.Accepted - s.Connections.Dropped
+	if s.hasRequests {
+		stat["requests"] = s.Requests.Total
+	}
+
+	for name, z := range s.ServerZones {
+		name = normalizeName(name)
+		stat["nginx.server_zone.requests."+name+".requests"] = z.Requests
+		z.Responses.set(stat, "nginx.server_zone.responses."+name)
+		stat["nginx.server_zone.traffic."+name+".received"] = z.Received
+		stat["nginx.server_zone.traffic."+name+".sent"] = z.Sent
+	}
+
+	for upstream, u := range s.Upstreams {
+		for _, p := range u.Peers {
+			name := peerName(upstream, p.Server)
+			if v, ok := upstreamStates[p.State]; ok {
+				stat["nginx.upstream.state."+name+".state"] = v
+			}
+			stat["nginx.upstream.connections."+name+".active"] = p.Active
+			stat["nginx.upstream.requests."+name+".requests"] = p.Requests
+			p.Responses.set(stat, "nginx.upstream.responses."+name)
+			stat["nginx.upstream.failures."+name+".fails"] = p.Fails
+			stat["nginx.upstream.failures."+name+".unavail"] = p.Unavail
+			// response_time is absent until the peer has served any request
+			if p.ResponseTime != nil {
+				stat["nginx.upstream.response_time."+name+".response_time"] = *p.ResponseTime
+			}
+		}
+	}
+
+	for name, c := range s.Caches {
+		name = normalizeName(name)
+		stat["nginx.cache.size."+name+".size"] = c.Size
+		if c.MaxSize > 0 {
+			stat["nginx.cache.size."+name+".max_size"] = c.MaxSize
+		}
+		cacheCounts{
+			Hit:         c.Hit.Responses,
+			Stale:       c.Stale.Responses,
+			Updating:    c.Updating.Responses,
+			Revalidated: c.Revalidated.Responses,
+			Miss:        c.Miss.Responses,
+			Expired:     c.Expired.Responses,
+			Bypass:      c.Bypass.Responses,
+		}.set(stat, name)
+	}
+	return stat
+}
@@ -0,0 +1,132 @@
+package mpnginx
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var plusResponses = map[string]string{
+	"/api/9/connections":   `{"accepted":4968119,"dropped":12,"active":5,"idle":117}`,
+	"/api/9/http/requests": `{"total":10624511,"current":4}`,
+	"/api/9/http/server_zones": `{
+  "hg.nginx.org": {
+    "processing": 0, "requests": 175276, "discarded": 0, "received": 44010243, "sent": 4187226312,
+    "responses": {"1xx": 0, "2xx": 162948, "3xx": 9919, "4xx": 2383, "5xx": 26, "total": 175276}
+  }
+}`,
+	"/api/9/http/upstreams": `{
+  "trac-backend": {
+    "zone": "trac-backend", "keepalive": 0, "zombies": 0,
+    "peers": [
+      {"id": 0, "server": "10.0.0.1:8080", "name": "10.0.0.1:8080", "backup": false, "weight": 1, "state": "up",
+       "active": 2, "requests": 1290, "header_time": 73, "response_time": 78,
+       "responses": {"1xx": 0, "2xx": 1200, "3xx": 80, "4xx": 9, "5xx": 1, "total": 1290},
+       "sent": 100, "received": 200, "fails": 3, "unavail": 1},
+      {"id": 1, "server": "10.0.0.2:8080", "name": "10.0.0.2:8080", "backup": true, "weight": 1, "state": "unhealthy",
+       "active": 0, "requests": 0,
+       "responses": {"1xx": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0, "total": 0},
+       "sent": 0, "received": 0, "fails": 0, "unavail": 0}
+    ]
+  }
+}`,
+	"/api/9/http/caches": `{
+  "http_cache": {
+    "size": 530915328, "max_size": 536870912, "cold": false,
+    "hit": {"responses": 254032, "bytes": 6685627875},
+    "stale": {"responses": 0, "bytes": 0},
+    "updating": {"responses": 0, "bytes": 0},
+    "revalidated": {"responses": 0, "bytes": 0},
+    "miss": {"responses": 100000, "bytes": 20000},
+    "expired": {"responses": 45968, "bytes": 1000},
+    "bypass": {"responses": 0, "bytes": 0}
+  }
+}`,
+}
+
+func TestFetchPlusStats(t *testing.T) {
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := plusResponses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer sv.Close()
+
+	nginx := NginxPlugin{URI: sv.URL + "/api/9/", Format: "plus"}
+	stat, err := nginx.FetchMetrics()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 5, stat["connections"])
+	assert.EqualValues(t, 117, stat["waiting"])
+	assert.EqualValues(t, 4968119, stat["accepts"])
+	assert.EqualValues(t, 4968107, stat["handled"])
+	assert.EqualValues(t, 10624511, stat["requests"])
+
+	assert.EqualValues(t, 175276, stat["nginx.server_zone.requests.hg_nginx_org.requests"])
+	assert.EqualValues(t, 162948, stat["nginx.server_zone.responses.hg_nginx_org.2xx"])
+	assert.EqualValues(t, 26, stat["nginx.server_zone.responses.hg_nginx_org.5xx"])
+	assert.EqualValues(t, 4187226312, stat["nginx.server_zone.traffic.hg_nginx_org.sent"])
+
+	assert.EqualValues(t, 1, stat["nginx.upstream.state.trac-backend_10_0_0_1_8080.state"])
+	assert.EqualValues(t, 5, stat["nginx.upstream.state.trac-backend_10_0_0_2_8080.state"])
+	assert.EqualValues(t, 2, stat["nginx.upstream.connections.trac-backend_10_0_0_1_8080.active"])
+	assert.EqualValues(t, 1290, stat["nginx.upstream.requests.trac-backend_10_0_0_1_8080.requests"])
+	assert.EqualValues(t, 80, stat["nginx.upstream.responses.trac-backend_10_0_0_1_8080.3xx"])
+	assert.EqualValues(t, 78, stat["nginx.upstream.response_time.trac-backend_10_0_0_1_8080.response_time"])
+	assert.EqualValues(t, 3, stat["nginx.upstream.failures.trac-backend_10_0_0_1_8080.fails"])
+	assert.EqualValues(t, 1, stat["nginx.upstream.failures.trac-backend_10_0_0_1_8080.unavail"])
+	assert.NotContains(t, stat, "nginx.upstream.response_time.trac-backend_10_0_0_2_8080.response_time")
+
+	assert.EqualValues(t, 530915328, stat["nginx.cache.size.http_cache.size"])
+	assert.EqualValues(t, 536870912, stat["nginx.cache.size.http_cache.max_size"])
+	assert.EqualValues(t, 254032, stat["nginx.cache.responses.http_cache.hit"])
+	assert.EqualValues(t, 63.508, stat["nginx.cache.hit_ratio.http_cache.hit_ratio"])
+}
+
+func TestFetchPlusStatsWithoutHTTPZones(t *testing.T) {
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/9/connections" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, plusResponses[r.URL.Path])
+	}))
+	defer sv.Close()
+
+	nginx := NginxPlugin{URI: sv.URL + "/api/9", Format: "plus"}
+	stat, err := nginx.FetchMetrics()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, stat["connections"])
+	assert.NotContains(t, stat, "requests")
+}
+
+func TestFetchPlusStatsError(t *testing.T) {
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/9/http/upstreams" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, plusResponses[r.URL.Path])
+	}))
+	defer sv.Close()
+
+	nginx := NginxPlugin{URI: sv.URL + "/api/9", Format: "plus"}
+	_, err := nginx.FetchMetrics()
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestGraphDefinitionWithZones(t *testing.T) {
+	nginx := NginxPlugin{Format: "plus"}
+	graphdef := nginx.GraphDefinition()
+	assert.Len(t, graphdef, 17)
+	assert.Contains(t, graphdef, "nginx.connections")
+	assert.Contains(t, graphdef, "nginx.server_zone.responses.#")
+	assert.Contains(t, graphdef, "nginx.cache.hit_ratio.#")
+}
@@ -0,0 +1,106 @@
+package mpnginx
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// nginx-module-vts
+// See also. https://github.com/vozlt/nginx-module-vts#json
+
+type vtsConnections struct {
+	Active   float64 `json:"active"`
+	Reading  float64 `json:"reading"`
+	Writing  float64 `json:"writing"`
+	Waiting  float64 `json:"waiting"`
+	Accepted float64 `json:"accepted"`
+	Handled  float64 `json:"handled"`
+	Requests float64 `json:"requests"`
+}
+
+type vtsServerZone struct {
+	RequestCounter float64        `json:"requestCounter"`
+	InBytes        float64        `json:"inBytes"`
+	OutBytes       float64        `json:"outBytes"`
+	Responses      responseCounts `json:"responses"`
+}
+
+type vtsUpstreamServer struct {
+	Server         string         `json:"server"`
+	RequestCounter float64        `json:"requestCounter"`
+	Responses      responseCounts `json:"responses"`
+	ResponseMsec   float64        `json:"responseMsec"`
+	Down           bool           `json:"down"`
+}
+
+type vtsCacheResponses struct {
+	Hit         float64 `json:"hit"`
+	Stale       float64 `json:"stale"`
+	Updating    float64 `json:"updating"`
+	Revalidated float64 `json:"revalidated"`
+	Miss        float64 `json:"miss"`
+	Expired     float64 `json:"expired"`
+	Bypass      float64 `json:"bypass"`
+}
+
+type vtsCacheZone struct {
+	MaxSize   float64           `json:"maxSize"`
+	UsedSize  float64           `json:"usedSize"`
+	Responses vtsCacheResponses `json:"responses"`
+}
+
+type vtsStats struct {
+	Connections   vtsConnections                 `json:"connections"`
+	ServerZones   map[string]vtsServerZone       `json:"serverZones"`
+	UpstreamZones map[string][]vtsUpstreamServer `json:"upstreamZones"`
+	CacheZones    map[string]vtsCacheZone        `json:"cacheZones"`
+}
+
+// parseVTSStats parses the output of `vhost_traffic_status_display_format json`.
+func parseVTSStats(body io.Reader) (map[string]any, error) {
+	var s vtsStats
+	if err := json.NewDecoder(body).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]any)
+	stat["connections"] = s.Connections.Active
+	stat["reading"] = s.Connections.Reading
+	stat["writing"] = s.Connections.Writing
+	stat["waiting"] = s.Connections.Waiting
+	stat["accepts"] = s.Connections.Accepted
+	stat["handled"] = s.Connections.Handled
+	stat["requests"] = s.Connections.Requests
+
+	for name, z := range s.ServerZones {
+		name = normalizeName(name)
+		stat["nginx.server_zone.requests."+name+".requests"] = z.RequestCounter
+		z.Responses.set(stat, "nginx.server_zone.responses."+name)
+		stat["nginx.server_zone.traffic."+name+".received"] = z.InBytes
+		stat["nginx.server_zone.traffic."+name+".sent"] = z.OutBytes
+	}
+
+	for upstream, servers := range s.UpstreamZones {
+		for _, p := range servers {
+			name := peerName(upstream, p.Server)
+			if p.Down {
+				stat["nginx.upstream.state."+name+".state"] = upstreamStates["down"]
+			} else {
+				stat["nginx.upstream.state."+name+".state"] = upstreamStates["up"]
+			}
+			stat["nginx.upstream.requests."+name+".requests"] = p.RequestCounter
+			p.Responses.set(stat, "nginx.upstream.responses."+name)
+			stat["nginx.upstream.response_time."+name+".response_time"] = p.ResponseMsec
+		}
+	}
+
+	for name, c := range s.CacheZones {
+		name = normalizeName(name)
+		stat["nginx.cache.size."+name+".size"] = c.UsedSize
+		if c.MaxSize > 0 {
+			stat["nginx.cache.size."+name+".max_size"] = c.MaxSize
+		}
+		cacheCounts(c.Responses).set(stat, name)
+	}
+	return stat, nil
+}
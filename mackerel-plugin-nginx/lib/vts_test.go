@@ -0,0 +1,74 @@
+package mpnginx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var vtsStub = `{
+  "hostName": "example.com",
+  "nginxVersion": "1.25.3",
+  "connections": {"active": 3, "reading": 0, "writing": 1, "waiting": 2, "accepted": 120, "handled": 118, "requests": 600},
+  "sharedZones": {"name": "ngx_http_vhost_traffic_status", "maxSize": 1048575, "usedSize": 3510, "usedNode": 3},
+  "serverZones": {
+    "example.com": {
+      "requestCounter": 500, "inBytes": 1000, "outBytes": 20000,
+      "responses": {"1xx": 0, "2xx": 450, "3xx": 20, "4xx": 25, "5xx": 5, "miss": 10, "bypass": 0, "expired": 0, "stale": 0, "updating": 0, "revalidated": 0, "hit": 30, "scarce": 0},
+      "requestMsec": 12
+    },
+    "*": {
+      "requestCounter": 600, "inBytes": 1200, "outBytes": 24000,
+      "responses": {"1xx": 0, "2xx": 540, "3xx": 24, "4xx": 30, "5xx": 6},
+      "requestMsec": 11
+    }
+  },
+  "upstreamZones": {
+    "backend": [
+      {"server": "127.0.0.1:8081", "requestCounter": 400, "inBytes": 800, "outBytes": 16000,
+       "responses": {"1xx": 0, "2xx": 390, "3xx": 0, "4xx": 8, "5xx": 2},
+       "requestMsec": 9, "responseMsec": 7, "weight": 1, "maxFails": 1, "failTimeout": 10, "backup": false, "down": false},
+      {"server": "127.0.0.1:8082", "requestCounter": 0, "inBytes": 0, "outBytes": 0,
+       "responses": {"1xx": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0},
+       "requestMsec": 0, "responseMsec": 0, "weight": 1, "maxFails": 1, "failTimeout": 10, "backup": false, "down": true}
+    ]
+  },
+  "cacheZones": {
+    "static": {
+      "maxSize": 1048576, "usedSize": 2048, "inBytes": 100, "outBytes": 200,
+      "responses": {"miss": 10, "bypass": 0, "expired": 0, "stale": 0, "updating": 0, "revalidated": 0, "hit": 30, "scarce": 0}
+    }
+  }
+}`
+
+func TestParseVTSStats(t *testing.T) {
+	stat, err := parseVTSStats(strings.NewReader(vtsStub))
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 3, stat["connections"])
+	assert.EqualValues(t, 2, stat["waiting"])
+	assert.EqualValues(t, 118, stat["handled"])
+	assert.EqualValues(t, 600, stat["requests"])
+
+	assert.EqualValues(t, 500, stat["nginx.server_zone.requests.example_com.requests"])
+	assert.EqualValues(t, 25, stat["nginx.server_zone.responses.example_com.4xx"])
+	assert.EqualValues(t, 1000, stat["nginx.server_zone.traffic.example_com.received"])
+	assert.EqualValues(t, 600, stat["nginx.server_zone.requests.total.requests"])
+
+	assert.EqualValues(t, 1, stat["nginx.upstream.state.backend_127_0_0_1_8081.state"])
+	assert.EqualValues(t, 0, stat["nginx.upstream.state.backend_127_0_0_1_8082.state"])
+	assert.EqualValues(t, 400, stat["nginx.upstream.requests.backend_127_0_0_1_8081.requests"])
+	assert.EqualValues(t, 2, stat["nginx.upstream.responses.backend_127_0_0_1_8081.5xx"])
+	assert.EqualValues(t, 7, stat["nginx.upstream.response_time.backend_127_0_0_1_8081.response_time"])
+
+	assert.EqualValues(t, 2048, stat["nginx.cache.size.static.size"])
+	assert.EqualValues(t, 30, stat["nginx.cache.responses.static.hit"])
+	assert.EqualValues(t, 75, stat["nginx.cache.hit_ratio.static.hit_ratio"])
+}
+
+func TestParseVTSStatsInvalid(t *testing.T) {
+	_, err := parseVTSStats(strings.NewReader("Active connections: 1\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package mpnginx
+
+import (
+	"regexp"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// zoneGraphdef is the graph definitions of per server zone, upstream peer and cache metrics,
+// which are available in the nginx Plus API and the VTS module formats.
+var zoneGraphdef = map[string]mp.Graphs{
+	"nginx.server_zone.requests.#": {
+		Label: "Nginx Server Zone Requests",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "requests", Label: "Requests", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.server_zone.responses.#": {
+		Label:   "Nginx Server Zone Responses",
+		Unit:    "integer",
+		Metrics: responseMetrics,
+	},
+	"nginx.server_zone.traffic.#": {
+		Label: "Nginx Server Zone Traffic",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "received", Label: "Received", Diff: true, Type: "uint64"},
+			{Name: "sent", Label: "Sent", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.upstream.state.#": {
+		Label: "Nginx Upstream Peer State",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "state", Label: "State"},
+		},
+	},
+	"nginx.upstream.connections.#": {
+		Label: "Nginx Upstream Peer Active Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "active", Label: "Active"},
+		},
+	},
+	"nginx.upstream.requests.#": {
+		Label: "Nginx Upstream Peer Requests",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "requests", Label: "Requests", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.upstream.responses.#": {
+		Label:   "Nginx Upstream Peer Responses",
+		Unit:    "integer",
+		Metrics: responseMetrics,
+	},
+	"nginx.upstream.response_time.#": {
+		Label: "Nginx Upstream Peer Response Time (ms)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "response_time", Label: "Response time"},
+		},
+	},
+	"nginx.upstream.failures.#": {
+		Label: "Nginx Upstream Peer Failures",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "fails", Label: "Failed", Diff: true, Type: "uint64"},
+			{Name: "unavail", Label: "Unavailable", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.cache.hit_ratio.#": {
+		Label: "Nginx Cache Hit Ratio",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "hit_ratio", Label: "Hit ratio"},
+		},
+	},
+	"nginx.cache.responses.#": {
+		Label: "Nginx Cache Responses",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "hit", Label: "Hit", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "stale", Label: "Stale", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "updating", Label: "Updating", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "revalidated", Label: "Revalidated", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "miss", Label: "Miss", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "expired", Label: "Expired", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "bypass", Label: "Bypass", Diff: true, Stacked: true, Type: "uint64"},
+		},
+	},
+	"nginx.cache.size.#": {
+		Label: "Nginx Cache Size",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "size", Label: "Used"},
+			{Name: "max_size", Label: "Max"},
+		},
+	},
+}
+
+var responseMetrics = []mp.Metrics{
+	{Name: "1xx", Label: "1xx", Diff: true, Stacked: true, Type: "uint64"},
+	{Name: "2xx", Label: "2xx", Diff: true, Stacked: true, Type: "uint64"},
+	{Name: "3xx", Label: "3xx", Diff: true, Stacked: true, Type: "uint64"},
+	{Name: "4xx", Label: "4xx", Diff: true, Stacked: true, Type: "uint64"},
+	{Name: "5xx", Label: "5xx", Diff: true, Stacked: true, Type: "uint64"},
+}
+
+// responseCounts is the number of responses by status class.
+// Both of the nginx Plus API and the VTS module use the same keys.
+type responseCounts struct {
+	Status1xx float64 `json:"1xx"`
+	Status2xx float64 `json:"2xx"`
+	Status3xx float64 `json:"3xx"`
+	Status4xx float64 `json:"4xx"`
+	Status5xx float64 `json:"5xx"`
+}
+
+func (r responseCounts) set(stat map[string]any, key string) {
+	stat[key+".1xx"] = r.Status1xx
+	stat[key+".2xx"] = r.Status2xx
+	stat[key+".3xx"] = r.Status3xx
+	stat[key+".4xx"] = r.Status4xx
+	stat[key+".5xx"] = r.Status5xx
+}
+
+// cacheCounts is the number of responses by cache status.
+type cacheCounts struct {
+	Hit, Stale, Updating, Revalidated, Miss, Expired, Bypass float64
+}
+
+func (c cacheCounts) set(stat map[string]any, name string) {
+	key := "nginx.cache.responses." + name
+	stat[key+".hit"] = c.Hit
+	stat[key+".stale"] = c.Stale
+	stat[key+".updating"] = c.Updating
+	stat[key+".revalidated"] = c.Revalidated
+	stat[key+".miss"] = c.Miss
+	stat[key+".expired"] = c.Expired
+	stat[key+".bypass"] = c.Bypass
+
+	// responses served from the cache, out of all responses since nginx started
+	total := c.Hit + c.Stale + c.Updating + c.Revalidated + c.Miss + c.Expired + c.Bypass
+	if total > 0 {
+		stat["nginx.cache.hit_ratio."+name+".hit_ratio"] = (c.Hit + c.Stale + c.Updating + c.Revalidated) * 100 / total
+	}
+}
+
+// upstreamStates maps states of upstream peers to the value of the state metric.
+var upstreamStates = map[string]float64{
+	"down":      0,
+	"up":        1,
+	"draining":  2,
+	"unavail":   3,
+	"checking":  4,
+	"unhealthy": 5,
+}
+
+var invalidNameChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// normalizeName converts a zone, upstream or server name to be usable in a metric name.
+func normalizeName(name string) string {
+	if name == "*" {
+		return "total"
+	}
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// peerName returns the metric name of an upstream peer, e.g. "backend_10_0_0_1_80".
+func peerName(upstream, server string) string {
+	return normalizeName(upstream) + "_" + normalizeName(server)
+}
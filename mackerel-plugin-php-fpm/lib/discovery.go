@@ -0,0 +1,218 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultPoolConfig is the default pattern of pool configuration files to discover pools.
+const DefaultPoolConfig = "/etc/php/*/fpm/pool.d/*.conf"
+
+// poolConfig is the directives of a pool in php-fpm configuration files.
+type poolConfig struct {
+	Name         string
+	Listen       string
+	StatusPath   string
+	StatusListen string
+}
+
+// discoverPools reads the pool configuration files matching pattern,
+// and returns the pools which enable the status page.
+// The pools of the same name, such as [www] of each PHP version, are qualified by their directories, e.g. "8_2_www".
+func discoverPools(pattern string) ([]Pool, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var (
+		pools     []Pool
+		poolFiles []string
+	)
+	for _, file := range files {
+		configs, err := readPoolConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range configs {
+			pool, err := c.pool()
+			if err != nil {
+				log.Printf("skip pool %s in %s: %s", c.Name, file, err)
+				continue
+			}
+			pools = append(pools, pool)
+			poolFiles = append(poolFiles, file)
+		}
+	}
+
+	dups := make(map[string][]int)
+	for i, pool := range pools {
+		dups[pool.Name] = append(dups[pool.Name], i)
+	}
+	for name, indexes := range dups {
+		if len(indexes) < 2 {
+			continue
+		}
+		var files []string
+		for _, i := range indexes {
+			files = append(files, poolFiles[i])
+		}
+		qualifiers := distinctDirs(files)
+		for j, i := range indexes {
+			if qualifiers[j] == "" {
+				return nil, fmt.Errorf("duplicate pool %s in %s", name, strings.Join(files, ", "))
+			}
+			pools[i].Name = normalizeName(qualifiers[j]) + "_" + name
+		}
+	}
+	names := make(map[string]string)
+	for i, pool := range pools {
+		if file, ok := names[pool.Name]; ok {
+			return nil, fmt.Errorf("duplicate pool %s in %s and %s", pool.Name, file, poolFiles[i])
+		}
+		names[pool.Name] = poolFiles[i]
+	}
+	return pools, nil
+}
+
+// distinctDirs returns the first directory of each file which differs among files,
+// e.g. "8.2" of /etc/php/8.2/fpm/pool.d/www.conf and /etc/php/8.3/fpm/pool.d/www.conf.
+// It returns "" for the files in the same directory.
+func distinctDirs(files []string) []string {
+	var dirs [][]string
+	for _, file := range files {
+		dirs = append(dirs, strings.Split(filepath.Dir(file), string(filepath.Separator)))
+	}
+	qualifiers := make([]string, len(files))
+	for i, d := range dirs {
+		for k, name := range d {
+			differs := false
+			for j, other := range dirs {
+				if j != i && (k >= len(other) || other[k] != name) {
+					differs = true
+					break
+				}
+			}
+			if differs {
+				qualifiers[i] = name
+				break
+			}
+		}
+	}
+	return qualifiers
+}
+
+func readPoolConfigFile(file string) ([]poolConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parsePoolConfig(f)
+}
+
+// parsePoolConfig parses the pool sections of a php-fpm configuration file.
+// See also. https://www.php.net/manual/en/install.fpm.configuration.php
+func parsePoolConfig(r io.Reader) ([]poolConfig, error) {
+	var (
+		configs []poolConfig
+		c       *poolConfig
+	)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "global" {
+				c = nil
+				continue
+			}
+			configs = append(configs, poolConfig{Name: name})
+			c = &configs[len(configs)-1]
+			continue
+		}
+		if c == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		// $pool is replaced with the name of the pool in every value
+		value = strings.ReplaceAll(value, "$pool", c.Name)
+		switch strings.TrimSpace(key) {
+		case "listen":
+			c.Listen = value
+		case "pm.status_path":
+			c.StatusPath = value
+		case "pm.status_listen":
+			c.StatusListen = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// pool returns the Pool to fetch the status page of the pool.
+func (c poolConfig) pool() (Pool, error) {
+	if c.StatusPath == "" {
+		return Pool{}, fmt.Errorf("pm.status_path is not set")
+	}
+	listen := c.Listen
+	// pm.status_listen (PHP 8.0+) serves the status page on another socket
+	if c.StatusListen != "" {
+		listen = c.StatusListen
+	}
+	if listen == "" {
+		return Pool{}, fmt.Errorf("listen is not set")
+	}
+
+	pool := Pool{
+		Name: normalizeName(c.Name),
+		URL:  "http://localhost" + c.StatusPath + "?json",
+	}
+	if err := pool.Socket.Set(listenAddress(listen)); err != nil {
+		return Pool{}, err
+	}
+	return pool, nil
+}
+
+// listenAddress converts the value of the listen directive to be able to connect to.
+// The directive accepts a path, "port", "address:port" and "[address]:port",
+// and the address can be a wildcard.
+func listenAddress(listen string) string {
+	if strings.Contains(listen, "/") {
+		return listen
+	}
+	if _, err := strconv.Atoi(listen); err == nil {
+		return net.JoinHostPort("127.0.0.1", listen)
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	switch host {
+	case "", "0.0.0.0", "*":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePoolConfig(t *testing.T) {
+	conf := `; Start a new pool named 'www'.
+[global]
+pid = /run/php/php8.2-fpm.pid
+
+[www]
+user = www-data
+listen = /run/php/php8.2-fpm-$pool.sock
+;pm.status_path = /old-status
+pm.status_path = /status
+
+[api]
+listen = "127.0.0.1:9001"
+pm.status_path = /api-status
+pm.status_listen = 127.0.0.1:9101
+
+[batch]
+listen = 9002
+`
+	configs, err := parsePoolConfig(strings.NewReader(conf))
+	require.NoError(t, err)
+	assert.Equal(t, []poolConfig{
+		{Name: "www", Listen: "/run/php/php8.2-fpm-www.sock", StatusPath: "/status"},
+		{Name: "api", Listen: "127.0.0.1:9001", StatusPath: "/api-status", StatusListen: "127.0.0.1:9101"},
+		{Name: "batch", Listen: "9002"},
+	}, configs)
+}
+
+func TestListenAddress(t *testing.T) {
+	tests := map[string]string{
+		"/run/php/php-fpm.sock": "/run/php/php-fpm.sock",
+		"run/php-fpm.sock":      "run/php-fpm.sock",
+		"9000":                  "127.0.0.1:9000",
+		"127.0.0.1:9000":        "127.0.0.1:9000",
+		"0.0.0.0:9000":          "127.0.0.1:9000",
+		"[::]:9000":             "[::1]:9000",
+		"[2001:db8::1]:9000":    "[2001:db8::1]:9000",
+		"php.example.com:9000":  "php.example.com:9000",
+	}
+	for listen, want := range tests {
+		assert.Equal(t, want, listenAddress(listen), listen)
+	}
+}
+
+func TestDiscoverPools(t *testing.T) {
+	dir := t.TempDir()
+	for version, conf := range map[string]string{
+		"7.4": "[legacy]\nlisten = /run/php/php7.4-fpm.sock\npm.status_path = /status\n",
+		"8.2": "[www]\nlisten = 127.0.0.1:9000\npm.status_path = /fpm-status\n\n[nostatus]\nlisten = 127.0.0.1:9001\n",
+	} {
+		poolDir := filepath.Join(dir, version, "fpm", "pool.d")
+		require.NoError(t, os.MkdirAll(poolDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(poolDir, "www.conf"), []byte(conf), 0644))
+	}
+
+	pools, err := discoverPools(filepath.Join(dir, "*", "fpm", "pool.d", "*.conf"))
+	require.NoError(t, err)
+	require.Len(t, pools, 2)
+
+	assert.Equal(t, "legacy", pools[0].Name)
+	assert.Equal(t, "http://localhost/status?json", pools[0].URL)
+	assert.Equal(t, "unix", pools[0].Socket.Network)
+	assert.Equal(t, "/run/php/php7.4-fpm.sock", pools[0].Socket.Address)
+
+	assert.Equal(t, "www", pools[1].Name)
+	assert.Equal(t, "http://localhost/fpm-status?json", pools[1].URL)
+	assert.Equal(t, "tcp", pools[1].Socket.Network)
+	assert.Equal(t, "127.0.0.1:9000", pools[1].Socket.Address)
+}
+
+func TestDiscoverPoolsOfVersions(t *testing.T) {
+	dir := t.TempDir()
+	for _, version := range []string{"8.2", "8.3"} {
+		poolDir := filepath.Join(dir, version, "fpm", "pool.d")
+		require.NoError(t, os.MkdirAll(poolDir, 0755))
+		conf := fmt.Sprintf("[www]\nlisten = /run/php/php%s-fpm.sock\npm.status_path = /status\n", version)
+		require.NoError(t, os.WriteFile(filepath.Join(poolDir, "www.conf"), []byte(conf), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "8.3", "fpm", "pool.d", "api.conf"), []byte("[api]\nlisten = /run/php/api.sock\npm.status_path = /status\n"), 0644))
+
+	pools, err := discoverPools(filepath.Join(dir, "*", "fpm", "pool.d", "*.conf"))
+	require.NoError(t, err)
+	require.Len(t, pools, 3)
+	assert.Equal(t, "8_2_www", pools[0].Name)
+	assert.Equal(t, "/run/php/php8.2-fpm.sock", pools[0].Socket.Address)
+	assert.Equal(t, "api", pools[1].Name)
+	assert.Equal(t, "8_3_www", pools[2].Name)
+	assert.Equal(t, "/run/php/php8.3-fpm.sock", pools[2].Socket.Address)
+
+	// the pools of the same name in the same directory can't be distinguished
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "8.3", "fpm", "pool.d", "www2.conf"), []byte("[www]\nlisten = /run/php/www2.sock\npm.status_path = /status\n"), 0644))
+	_, err = discoverPools(filepath.Join(dir, "*", "fpm", "pool.d", "*.conf"))
+	assert.Error(t, err)
+}
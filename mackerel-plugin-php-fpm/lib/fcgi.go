@@ -3,37 +3,54 @@
 package mpphpfpm
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/textproto"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+)
+
+// FastCGI protocol
+// See also. https://fastcgi-archives.github.io/FastCGI_Specification.html
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+	fcgiKeepConn  = 1
+
+	fcgiRequestComplete = 0
 
-	"github.com/tomasen/fcgi_client"
+	fcgiHeaderSize       = 8
+	fcgiMaxContentLength = 65535
 )
 
 // FastCGITransport is an implementation of RoundTripper that supports FastCGI.
+// It keeps the connection open with FCGI_KEEP_CONN and reuses it for the next request,
+// so that a run polling the same socket many times connects to it only once.
 type FastCGITransport struct {
 	Network string
 	Address string
-}
 
-func (*FastCGITransport) timeout(req *http.Request) time.Duration {
-	t, ok := req.Context().Deadline()
-	if !ok {
-		return 0 // no timeout
-	}
-	return time.Until(t)
+	mu   sync.Mutex
+	idle net.Conn
 }
 
 // RoundTrip implements the RoundTripper interface.
 func (t *FastCGITransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	c, err := fcgiclient.DialTimeout(t.Network, t.Address, t.timeout(req))
-	if err != nil {
-		return nil, err
-	}
-	defer c.Close()
-
 	params := make(map[string]string)
 	params["REQUEST_METHOD"] = req.Method
 	if req.ContentLength >= 0 {
@@ -58,19 +75,219 @@ func (t *FastCGITransport) RoundTrip(req *http.Request) (*http.Response, error)
 	if ua := req.Header.Get("User-Agent"); ua != "" {
 		params["USER_AGENT"] = ua
 	}
-	resp, err := c.Request(params, req.Body)
+
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	ctx := req.Context()
+	c, reused, err := t.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := t.do(ctx, c, params, body)
+	if err != nil && reused && ctx.Err() == nil {
+		// the server may have closed the idle connection
+		c, err = t.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stdout, err = t.do(ctx, c, params, body)
+	}
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	t.putIdle(c)
+	return readCGIResponse(req, stdout)
+}
+
+// CloseIdleConnections closes the connection kept for the next request.
+// It is called by http.Client.CloseIdleConnections.
+func (t *FastCGITransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil {
+		t.idle.Close()
+		t.idle = nil
+	}
+}
+
+// conn returns the idle connection, or a new one.
+func (t *FastCGITransport) conn(ctx context.Context) (net.Conn, bool, error) {
+	t.mu.Lock()
+	c := t.idle
+	t.idle = nil
+	t.mu.Unlock()
+	if c != nil {
+		return c, true, nil
+	}
+	c, err := t.dial(ctx)
+	return c, false, err
+}
+
+func (t *FastCGITransport) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, t.Network, t.Address)
+}
+
+// putIdle keeps c for the next request, or closes c if another connection is already kept.
+func (t *FastCGITransport) putIdle(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil {
+		c.Close()
+		return
+	}
+	t.idle = c
+}
+
+// do sends a request over c and returns its stdout. c is closed on errors.
+func (t *FastCGITransport) do(ctx context.Context, c net.Conn, params map[string]string, body []byte) ([]byte, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.SetDeadline(deadline); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// abort the request when the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Unix(1, 0))
+	})
+	stdout, err := fcgiRequest(c, params, body)
+	if !stop() || err != nil {
+		c.Close()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		c.Close()
 		return nil, err
 	}
-	body := resp.Body
-	defer body.Close()
+	return stdout, nil
+}
+
+// fcgiRequest sends a responder request with FCGI_KEEP_CONN and reads the records up to FCGI_END_REQUEST.
+func fcgiRequest(rw io.ReadWriter, params map[string]string, body []byte) ([]byte, error) {
+	const requestID = 1
+	var w bytes.Buffer
+	writeFCGIRecord(&w, fcgiBeginRequest, requestID, []byte{0, fcgiResponder, fcgiKeepConn, 0, 0, 0, 0, 0})
+	var pairs bytes.Buffer
+	for k, v := range params {
+		writeFCGISize(&pairs, len(k))
+		writeFCGISize(&pairs, len(v))
+		pairs.WriteString(k)
+		pairs.WriteString(v)
+	}
+	writeFCGIStream(&w, fcgiParams, requestID, pairs.Bytes())
+	writeFCGIStream(&w, fcgiStdin, requestID, body)
+	if _, err := rw.Write(w.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	for {
+		var h [fcgiHeaderSize]byte
+		if _, err := io.ReadFull(rw, h[:]); err != nil {
+			return nil, err
+		}
+		if h[0] != 1 {
+			return nil, fmt.Errorf("invalid FastCGI version: %d", h[0])
+		}
+		content := make([]byte, int(binary.BigEndian.Uint16(h[4:6]))+int(h[6]))
+		if _, err := io.ReadFull(rw, content); err != nil {
+			return nil, err
+		}
+		content = content[:binary.BigEndian.Uint16(h[4:6])]
+		if id := binary.BigEndian.Uint16(h[2:4]); id != requestID {
+			continue
+		}
+		switch h[1] {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			// PHP-FPM writes the errors of the scripts to its own log
+		case fcgiEndRequest:
+			if len(content) < 5 {
+				return nil, errors.New("invalid FastCGI end request")
+			}
+			if status := content[4]; status != fcgiRequestComplete {
+				return nil, fmt.Errorf("FastCGI request is rejected: protocol status %d", status)
+			}
+			return stdout.Bytes(), nil
+		}
+	}
+}
+
+func writeFCGIRecord(w *bytes.Buffer, recType uint8, requestID uint16, content []byte) {
+	padding := -len(content) & 7
+	h := [fcgiHeaderSize]byte{1, recType}
+	binary.BigEndian.PutUint16(h[2:4], requestID)
+	binary.BigEndian.PutUint16(h[4:6], uint16(len(content)))
+	h[6] = uint8(padding)
+	w.Write(h[:])
+	w.Write(content)
+	w.Write(make([]byte, padding))
+}
+
+// writeFCGIStream writes content as the records of a stream, terminated by an empty record.
+func writeFCGIStream(w *bytes.Buffer, recType uint8, requestID uint16, content []byte) {
+	for len(content) > 0 {
+		n := min(len(content), fcgiMaxContentLength)
+		writeFCGIRecord(w, recType, requestID, content[:n])
+		content = content[n:]
+	}
+	writeFCGIRecord(w, recType, requestID, nil)
+}
+
+func writeFCGISize(w *bytes.Buffer, n int) {
+	if n < 128 {
+		w.WriteByte(byte(n))
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+	w.Write(b[:])
+}
 
-	// Var c can disconnect before end of reading of resp.Body.
-	// So contents of resp.Body should copy in memory.
-	var buf bytes.Buffer
-	if _, err := buf.ReadFrom(body); err != nil {
+// readCGIResponse parses the CGI response, whose status is given by the "Status" header, 200 by default.
+func readCGIResponse(req *http.Request, stdout []byte) (*http.Response, error) {
+	r := bufio.NewReader(bytes.NewReader(stdout))
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil && !(errors.Is(err, io.EOF) && len(header) > 0) {
+		return nil, fmt.Errorf("malformed CGI response: %w", err)
+	}
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     http.Header(header),
+		Request:    req,
+	}
+	if s := resp.Header.Get("Status"); s != "" {
+		code, _, _ := strings.Cut(s, " ")
+		resp.StatusCode, err = strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("malformed CGI status: %q", s)
+		}
+		resp.Status = s
+		resp.Header.Del("Status")
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
 		return nil, err
 	}
-	resp.Body = io.NopCloser(&buf)
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
 	return resp, nil
 }
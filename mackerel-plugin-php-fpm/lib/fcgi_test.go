@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/fcgi"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -155,3 +156,95 @@ func TestFCGITransportDialTimeout(t *testing.T) {
 		resp.Body.Close()
 	}
 }
+
+func TestFCGITransportRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts, err := NewFastCGIServer("tcp", "127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	if err != nil {
+		assert.FailNow(t, "failed to launch FastCGI server", err)
+	}
+	defer ts.Close()
+	defer close(done)
+
+	c := http.Client{
+		Transport: &FastCGITransport{
+			Network: "tcp",
+			Address: ts.Address,
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		assert.FailNow(t, "failed to create a request", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req = req.WithContext(ctx)
+	resp, err := c.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	if resp != nil {
+		resp.Body.Close()
+	}
+}
+
+// countingListener counts the accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return c, err
+}
+
+func TestFCGITransportKeepConn(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, "failed to listen", err)
+	}
+	cl := &countingListener{Listener: l}
+	defer cl.Close()
+	go fcgi.Serve(cl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no Status header is written for 200 as PHP-FPM does
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+
+	tr := &FastCGITransport{Network: "tcp", Address: l.Addr().String()}
+	c := http.Client{Transport: tr}
+	for _, q := range []string{"json", "json&full", "xml"} {
+		resp, err := c.Get("http://localhost/status?" + q)
+		if !assert.NoError(t, err) {
+			return
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, q, string(b))
+	}
+	assert.EqualValues(t, 1, cl.accepted.Load())
+
+	// the connection closed by the server is redialed
+	c.CloseIdleConnections()
+	tr.idle, err = net.Dial("tcp", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	tr.idle.Close()
+	resp, err := c.Get("http://localhost/status?json")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestSocketFlagTransport(t *testing.T) {
+	var a, b SocketFlag
+	assert.NoError(t, a.Set("/run/php-fpm/www.sock"))
+	assert.NoError(t, b.Set("unix:///run/php-fpm/www.sock"))
+	assert.Same(t, a.Transport(), b.Transport())
+}
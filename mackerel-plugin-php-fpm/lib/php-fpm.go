@@ -3,7 +3,6 @@
 package mpphpfpm
 
 import (
-	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,10 +10,13 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 )
 
 // PhpFpmPlugin mackerel plugin
@@ -22,8 +24,10 @@ type PhpFpmPlugin struct {
 	URL         string
 	Prefix      string
 	LabelPrefix string
-	Timeout     uint
 	Socket      SocketFlag
+	Pools       []Pool
+	Full        bool
+	httpclient.Options
 }
 
 // SocketFlag represents -socket flag.
@@ -101,14 +105,29 @@ func parseHostOrErr(s string, retErr error) (*url.URL, error) {
 	}, nil
 }
 
+// fastCGITransports are the transports of the sockets, shared within the run
+// so that each socket is connected only once.
+var (
+	fastCGITransportsMu sync.Mutex
+	fastCGITransports   = make(map[[2]string]*FastCGITransport)
+)
+
 // Transport returns http.RoundTripper corresponding to the flag.
 func (p *SocketFlag) Transport() http.RoundTripper {
 	switch p.Network {
 	case "tcp", "unix":
-		return &FastCGITransport{
-			Network: p.Network,
-			Address: p.Address,
+		fastCGITransportsMu.Lock()
+		defer fastCGITransportsMu.Unlock()
+		key := [2]string{p.Network, p.Address}
+		t, ok := fastCGITransports[key]
+		if !ok {
+			t = &FastCGITransport{
+				Network: p.Network,
+				Address: p.Address,
+			}
+			fastCGITransports[key] = t
 		}
+		return t
 	default:
 		return nil // http.DefaultTransport
 	}
@@ -131,6 +150,21 @@ type PhpFpmStatus struct {
 	MaxChildrenReached uint64 `json:"max children reached"`
 	SlowRequests       uint64 `json:"slow requests"`
 	MemoryPeak         uint64 `json:"memory peak"`
+
+	// Processes is only available with the full status (?full&json).
+	Processes []PhpFpmProcess `json:"processes"`
+}
+
+// PhpFpmProcess is a process of the full status.
+type PhpFpmProcess struct {
+	Pid               uint64  `json:"pid"`
+	State             string  `json:"state"`
+	Requests          uint64  `json:"requests"`
+	RequestDuration   uint64  `json:"request duration"` // microseconds
+	RequestMethod     string  `json:"request method"`
+	RequestURI        string  `json:"request uri"`
+	LastRequestCPU    float64 `json:"last request cpu"`
+	LastRequestMemory uint64  `json:"last request memory"`
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -140,7 +174,10 @@ func (p PhpFpmPlugin) MetricKeyPrefix() string {
 
 // GraphDefinition interface for mackerelplugin
 func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
-	return map[string]mp.Graphs{
+	if len(p.Pools) > 0 {
+		return p.poolGraphDefinition()
+	}
+	graphdef := map[string]mp.Graphs{
 		"processes": {
 			Label: p.LabelPrefix + " Processes",
 			Unit:  "integer",
@@ -195,15 +232,40 @@ func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 	}
+	if p.Full {
+		graphdef["request_duration"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Request Duration (ms)",
+			Unit:    "float",
+			Metrics: requestDurationMetrics,
+		}
+		graphdef["request_cpu"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Last Request CPU",
+			Unit:    "percentage",
+			Metrics: requestCPUMetrics,
+		}
+		graphdef["process_memory"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Last Request Memory",
+			Unit:    "bytes",
+			Metrics: processMemoryMetrics,
+		}
+	}
+	return graphdef
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p PhpFpmPlugin) FetchMetrics() (map[string]any, error) {
+	if len(p.Pools) > 0 {
+		return p.fetchPoolMetrics()
+	}
 	status, err := getStatus(p)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to fetch PHP-FPM metrics: %s", err) // nolint
 	}
+	return statusMetrics(status, p.Full), nil
+}
 
+// statusMetrics converts status to the metrics of the single pool mode.
+func statusMetrics(status *PhpFpmStatus, full bool) map[string]any {
 	result := map[string]any{
 		"total_processes":      status.TotalProcesses,
 		"active_processes":     status.ActiveProcesses,
@@ -220,30 +282,32 @@ func (p PhpFpmPlugin) FetchMetrics() (map[string]any, error) {
 	if status.MemoryPeak > 0 {
 		result["memory_peak"] = status.MemoryPeak
 	}
-
-	return result, nil
+	if full {
+		for k, v := range processMetrics(status.Processes) {
+			result[k] = v
+		}
+	}
+	return result
 }
 
 func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
-	url := p.URL
-	timeout := time.Duration(time.Duration(p.Timeout) * time.Second)
-	client := http.Client{
-		Timeout:   timeout,
-		Transport: p.Socket.Transport(),
-	}
+	return fetchStatus(p.Options, p.URL, p.Socket, p.Full)
+}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func fetchStatus(opts httpclient.Options, statusURL string, socket SocketFlag, full bool) (*PhpFpmStatus, error) {
+	opts.UserAgent = "mackerel-plugin-php-fpm"
+	if t := socket.Transport(); t != nil {
+		opts.Transport = t
+	}
+	client, err := opts.NewClient()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "mackerel-plugin-php-fpm")
-	if timeout > 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		req = req.WithContext(ctx)
+	if full {
+		statusURL = fullStatusURL(statusURL)
 	}
 
-	res, err := client.Do(req)
+	res, err := client.Get(statusURL)
 	if err != nil {
 		return nil, err
 	}
@@ -262,24 +326,59 @@ func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
 	return status, nil
 }
 
+// fullStatusURL adds the "full" parameter to the status page URL.
+func fullStatusURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if _, ok := u.Query()["full"]; ok {
+		return s
+	}
+	if u.RawQuery == "" {
+		u.RawQuery = "full"
+	} else {
+		u.RawQuery = "full&" + u.RawQuery
+	}
+	return u.String()
+}
+
 // Do the plugin
 func Do() {
 	optURL := flag.String("url", "http://localhost/status?json", "PHP-FPM status page URL")
 	optPrefix := flag.String("metric-key-prefix", "php-fpm", "Metric key prefix")
 	optLabelPrefix := flag.String("metric-label-prefix", "PHP-FPM", "Metric label prefix")
-	optTimeout := flag.Uint("timeout", 5, "Timeout")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	var socketFlag SocketFlag
 	flag.Var(&socketFlag, "socket", "Unix domain socket `path or URL`")
+	var poolFlag PoolFlag
+	flag.Var(&poolFlag, "pool", "Pool to monitor as `[name=]URL or socket`; can be specified multiple times")
+	optFull := flag.Bool("full", false, "Fetch the full status to get per-process metrics")
+	optDiscover := flag.Bool("discover", false, "Discover pools from the pool configuration files")
+	optPoolConfig := flag.String("pool-config", DefaultPoolConfig, "Pattern of the pool configuration files for -discover")
+	var p PhpFpmPlugin
+	p.Timeout = 5 * time.Second
+	p.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	p := PhpFpmPlugin{
-		URL:         *optURL,
-		Prefix:      *optPrefix,
-		LabelPrefix: *optLabelPrefix,
-		Timeout:     *optTimeout,
-		Socket:      socketFlag,
+	p.URL = *optURL
+	p.Prefix = *optPrefix
+	p.LabelPrefix = *optLabelPrefix
+	p.Socket = socketFlag
+	p.Pools = poolFlag
+	if *optDiscover {
+		pools, err := discoverPools(*optPoolConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-php-fpm: %s\n", err)
+			os.Exit(1)
+		}
+		if len(pools) == 0 {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-php-fpm: no pools with pm.status_path found in %s\n", *optPoolConfig)
+			os.Exit(1)
+		}
+		p.Pools = append(p.Pools, pools...)
 	}
+	p.Full = *optFull
 	helper := mp.NewMackerelPlugin(p)
 	helper.Tempfile = *optTempfile
 
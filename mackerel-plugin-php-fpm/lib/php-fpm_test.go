@@ -4,8 +4,10 @@ package mpphpfpm
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -37,7 +39,7 @@ func TestGetStatus(t *testing.T) {
 	p := PhpFpmPlugin{
 		URL:     "http://httpmock/status",
 		Prefix:  "php-fpm",
-		Timeout: 5,
+		Options: httpclient.Options{Timeout: 5 * time.Second},
 	}
 	status, err := getStatus(p)
 
@@ -82,7 +84,7 @@ func TestGetStatus_MemoryPeak(t *testing.T) {
 	p := PhpFpmPlugin{
 		URL:     "http://httpmock/status",
 		Prefix:  "php-fpm",
-		Timeout: 5,
+		Options: httpclient.Options{Timeout: 5 * time.Second},
 	}
 	status, err := getStatus(p)
 
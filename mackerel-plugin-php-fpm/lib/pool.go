@@ -0,0 +1,284 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// Pool is a pool of PHP-FPM to be monitored.
+type Pool struct {
+	// Name is the name of the pool in metric names.
+	// If it is empty, the "pool" field of the status is used.
+	Name string
+
+	// URL is the status page URL. If it is empty, PhpFpmPlugin.URL is used.
+	// When Socket is set, only its path and query are meaningful.
+	URL string
+
+	// Socket is the FastCGI socket of the pool.
+	Socket SocketFlag
+}
+
+func (pool Pool) String() string {
+	target := pool.Socket.String()
+	if target == "" {
+		target = pool.URL
+	}
+	if pool.Name != "" {
+		return pool.Name + "=" + target
+	}
+	return target
+}
+
+// PoolFlag represents -pool flags.
+type PoolFlag []Pool
+
+func (f *PoolFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	s := make([]string, 0, len(*f))
+	for _, pool := range *f {
+		s = append(s, pool.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// Set implements flag.Value interface.
+// The value is "[name=]target" where target is a status page URL over HTTP(S), or a FastCGI socket.
+func (f *PoolFlag) Set(s string) error {
+	var pool Pool
+	target := s
+	if name, rest, ok := strings.Cut(s, "="); ok && !strings.Contains(name, "/") && !strings.Contains(name, ":") {
+		pool.Name = normalizeName(name)
+		target = rest
+	}
+	if target == "" {
+		return fmt.Errorf("empty pool: %s", s)
+	}
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		pool.URL = target
+	} else if err := pool.Socket.Set(target); err != nil {
+		return err
+	}
+	*f = append(*f, pool)
+	return nil
+}
+
+var invalidNameChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// normalizeName converts a pool name to be usable in a metric name.
+func normalizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// poolGraphs maps the metrics of a pool to the graphs in the multiple pools mode.
+// Metrics sharing a prefix, such as listen_queue and listen_queue_len, have to be
+// in separate graphs because the wildcard graphs match metric names by prefix.
+var poolGraphs = map[string]string{
+	"total_processes":          "processes",
+	"active_processes":         "processes",
+	"idle_processes":           "processes",
+	"max_active_processes":     "max_active_processes",
+	"max_children_reached":     "max_children_reached",
+	"listen_queue":             "listen_queue",
+	"max_listen_queue":         "listen_queue",
+	"listen_queue_len":         "listen_queue_len",
+	"slow_requests":            "slow_requests",
+	"memory_peak":              "memory_peak",
+	"slowest_request_duration": "request_duration",
+	"avg_request_duration":     "request_duration",
+	"avg_request_cpu":          "request_cpu",
+	"max_request_cpu":          "request_cpu",
+	"total_memory":             "process_memory",
+	"max_memory":               "process_memory",
+}
+
+var requestDurationMetrics = []mp.Metrics{
+	{Name: "slowest_request_duration", Label: "Slowest Current Request"},
+	{Name: "avg_request_duration", Label: "Average"},
+}
+
+var requestCPUMetrics = []mp.Metrics{
+	{Name: "avg_request_cpu", Label: "Average"},
+	{Name: "max_request_cpu", Label: "Max"},
+}
+
+var processMemoryMetrics = []mp.Metrics{
+	{Name: "total_memory", Label: "Total", Type: "uint64"},
+	{Name: "max_memory", Label: "Max", Type: "uint64"},
+}
+
+func (p PhpFpmPlugin) poolGraphDefinition() map[string]mp.Graphs {
+	graphdef := map[string]mp.Graphs{
+		"pool.processes.#": {
+			Label: p.LabelPrefix + " Pool Processes",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "total_processes", Label: "Total Processes", Type: "uint64"},
+				{Name: "active_processes", Label: "Active Processes", Type: "uint64"},
+				{Name: "idle_processes", Label: "Idle Processes", Type: "uint64"},
+			},
+		},
+		"pool.max_active_processes.#": {
+			Label: p.LabelPrefix + " Pool Max Active Processes",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "max_active_processes", Label: "Max Active Processes", Type: "uint64"},
+			},
+		},
+		"pool.max_children_reached.#": {
+			Label: p.LabelPrefix + " Pool Max Children Reached",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "max_children_reached", Label: "Max Children Reached", Type: "uint64"},
+			},
+		},
+		"pool.listen_queue.#": {
+			Label: p.LabelPrefix + " Pool Listen Queue",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "listen_queue", Label: "Listen Queue", Type: "uint64"},
+				{Name: "max_listen_queue", Label: "Max Listen Queue", Type: "uint64"},
+			},
+		},
+		"pool.listen_queue_len.#": {
+			Label: p.LabelPrefix + " Pool Listen Queue Len",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "listen_queue_len", Label: "Listen Queue Len", Type: "uint64"},
+			},
+		},
+		"pool.slow_requests.#": {
+			Label: p.LabelPrefix + " Pool Slow Requests",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "slow_requests", Label: "Slow Requests", Diff: true, Type: "uint64"},
+			},
+		},
+		"pool.memory_peak.#": {
+			Label: p.LabelPrefix + " Pool Memory Peak",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "memory_peak", Label: "Memory Peak", Type: "uint64"},
+			},
+		},
+	}
+	if p.Full {
+		graphdef["pool.request_duration.#"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Pool Request Duration (ms)",
+			Unit:    "float",
+			Metrics: requestDurationMetrics,
+		}
+		graphdef["pool.request_cpu.#"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Pool Last Request CPU",
+			Unit:    "percentage",
+			Metrics: requestCPUMetrics,
+		}
+		graphdef["pool.process_memory.#"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Pool Last Request Memory",
+			Unit:    "bytes",
+			Metrics: processMemoryMetrics,
+		}
+	}
+	return graphdef
+}
+
+// fetchPoolMetrics fetches the status of all pools concurrently.
+// Pools which fail to be fetched are skipped unless all of them fail.
+func (p PhpFpmPlugin) fetchPoolMetrics() (map[string]any, error) {
+	statuses := make([]*PhpFpmStatus, len(p.Pools))
+	errs := make([]error, len(p.Pools))
+	var wg sync.WaitGroup
+	for i, pool := range p.Pools {
+		wg.Add(1)
+		go func(i int, pool Pool) {
+			defer wg.Done()
+			statusURL := pool.URL
+			if statusURL == "" {
+				statusURL = p.URL
+			}
+			statuses[i], errs[i] = fetchStatus(p.Options, statusURL, pool.Socket, p.Full)
+		}(i, pool)
+	}
+	wg.Wait()
+
+	result := make(map[string]any)
+	var lastErr error
+	for i, status := range statuses {
+		if errs[i] != nil {
+			log.Printf("Failed to fetch PHP-FPM status of %s: %s", p.Pools[i], errs[i])
+			lastErr = errs[i]
+			continue
+		}
+		name := p.Pools[i].Name
+		if name == "" {
+			name = normalizeName(status.Pool)
+		}
+		for k, v := range statusMetrics(status, p.Full) {
+			if graph, ok := poolGraphs[k]; ok {
+				result["pool."+graph+"."+name+"."+k] = v
+			}
+		}
+	}
+	if len(result) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("Failed to fetch PHP-FPM metrics: %s", lastErr) // nolint
+	}
+	return result, nil
+}
+
+// processMetrics aggregates the processes of the full status.
+// Idle processes report the duration, CPU and memory of the last request,
+// while the others report the duration of the current request so far.
+func processMetrics(procs []PhpFpmProcess) map[string]any {
+	result := make(map[string]any)
+	if len(procs) == 0 {
+		return result
+	}
+
+	var (
+		slowest, totalDuration uint64
+		totalCPU, maxCPU       float64
+		totalMemory, maxMemory uint64
+		requests, finished     int
+	)
+	for _, proc := range procs {
+		if proc.State != "Idle" {
+			requests++
+			totalDuration += proc.RequestDuration
+			slowest = max(slowest, proc.RequestDuration)
+			continue
+		}
+		if proc.Requests == 0 {
+			// the process has not served any request yet
+			continue
+		}
+		requests++
+		finished++
+		totalDuration += proc.RequestDuration
+		totalCPU += proc.LastRequestCPU
+		maxCPU = max(maxCPU, proc.LastRequestCPU)
+		totalMemory += proc.LastRequestMemory
+		maxMemory = max(maxMemory, proc.LastRequestMemory)
+	}
+
+	// durations are reported in microseconds
+	result["slowest_request_duration"] = float64(slowest) / 1000
+	if requests > 0 {
+		result["avg_request_duration"] = float64(totalDuration) / float64(requests) / 1000
+	}
+	if finished > 0 {
+		result["avg_request_cpu"] = totalCPU / float64(finished)
+		result["max_request_cpu"] = maxCPU
+		result["total_memory"] = totalMemory
+		result["max_memory"] = maxMemory
+	}
+	return result
+}
@@ -0,0 +1,180 @@
+//go:build linux
+
+package mpphpfpm
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolFlag_Set(t *testing.T) {
+	var f PoolFlag
+	require.NoError(t, f.Set("http://localhost/status?json"))
+	require.NoError(t, f.Set("api=https://localhost/api-status?json"))
+	require.NoError(t, f.Set("web.site=unix:///run/php/www.sock"))
+	require.NoError(t, f.Set("localhost:9001"))
+	assert.Error(t, f.Set("empty="))
+	assert.Error(t, f.Set("unknown=aaa://test/"))
+
+	assert.Equal(t, PoolFlag{
+		{URL: "http://localhost/status?json"},
+		{Name: "api", URL: "https://localhost/api-status?json"},
+		{Name: "web_site", Socket: f[2].Socket},
+		{Socket: f[3].Socket},
+	}, f)
+	assert.Equal(t, "unix", f[2].Socket.Network)
+	assert.Equal(t, "/run/php/www.sock", f[2].Socket.Address)
+	assert.Equal(t, "tcp", f[3].Socket.Network)
+	assert.Equal(t, "localhost:9001", f[3].Socket.Address)
+}
+
+func TestFullStatusURL(t *testing.T) {
+	assert.Equal(t, "http://localhost/status?full&json", fullStatusURL("http://localhost/status?json"))
+	assert.Equal(t, "http://localhost/status?full", fullStatusURL("http://localhost/status"))
+	assert.Equal(t, "http://localhost/status?json&full", fullStatusURL("http://localhost/status?json&full"))
+}
+
+const fullStatusJSON = `{
+  "pool":"www",
+  "process manager":"dynamic",
+  "accepted conn":664,
+  "listen queue":0,
+  "max listen queue":1,
+  "listen queue len":128,
+  "idle processes":2,
+  "active processes":2,
+  "total processes":5,
+  "max active processes":3,
+  "max children reached":0,
+  "slow requests":4,
+  "processes":[
+    {"pid":101,"state":"Idle","requests":10,"request duration":1500,"request method":"GET","request uri":"/index.php","last request cpu":10.5,"last request memory":2097152},
+    {"pid":102,"state":"Idle","requests":20,"request duration":2500,"request method":"POST","request uri":"/api.php","last request cpu":30.5,"last request memory":4194304},
+    {"pid":103,"state":"Running","requests":5,"request duration":120000,"request method":"GET","request uri":"/slow.php","last request cpu":0,"last request memory":0},
+    {"pid":104,"state":"Reading headers","requests":3,"request duration":20000,"request method":"-","request uri":"-","last request cpu":0,"last request memory":0},
+    {"pid":105,"state":"Idle","requests":0,"request duration":0,"request method":"-","request uri":"-","last request cpu":0,"last request memory":0}
+  ]
+}`
+
+func TestProcessMetrics(t *testing.T) {
+	var procs []PhpFpmProcess
+	assert.Empty(t, processMetrics(procs))
+
+	procs = []PhpFpmProcess{
+		{State: "Idle", Requests: 10, RequestDuration: 1500, LastRequestCPU: 10.5, LastRequestMemory: 2097152},
+		{State: "Idle", Requests: 20, RequestDuration: 2500, LastRequestCPU: 30.5, LastRequestMemory: 4194304},
+		{State: "Running", Requests: 5, RequestDuration: 120000},
+		{State: "Reading headers", Requests: 3, RequestDuration: 20000},
+		{State: "Idle"},
+	}
+	assert.Equal(t, map[string]any{
+		"slowest_request_duration": 120.0,
+		"avg_request_duration":     36.0,
+		"avg_request_cpu":          20.5,
+		"max_request_cpu":          30.5,
+		"total_memory":             uint64(6291456),
+		"max_memory":               uint64(4194304),
+	}, processMetrics(procs))
+}
+
+func TestFetchMetrics_Full(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "http://httpmock/status?full&json",
+		httpmock.NewStringResponder(200, fullStatusJSON))
+
+	p := PhpFpmPlugin{
+		URL:     "http://httpmock/status?json",
+		Full:    true,
+		Options: httpclient.Options{Timeout: 5 * time.Second},
+	}
+	stat, err := p.FetchMetrics()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, stat["total_processes"])
+	assert.EqualValues(t, 120.0, stat["slowest_request_duration"])
+	assert.EqualValues(t, 6291456, stat["total_memory"])
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "request_duration")
+	assert.Contains(t, graphdef, "request_cpu")
+	assert.Contains(t, graphdef, "process_memory")
+}
+
+func TestFetchPoolMetrics(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "http://httpmock/www?full&json",
+		httpmock.NewStringResponder(200, fullStatusJSON))
+	httpmock.RegisterResponder("GET", "http://httpmock/api?full&json",
+		httpmock.NewStringResponder(200, `{"pool":"api","total processes":3,"slow requests":1,"processes":[]}`))
+	httpmock.RegisterResponder("GET", "http://httpmock/down?full&json",
+		httpmock.NewStringResponder(500, "Internal Server Error"))
+
+	p := PhpFpmPlugin{
+		Pools: []Pool{
+			{URL: "http://httpmock/www?json"},
+			{Name: "backend", URL: "http://httpmock/api?json"},
+			{URL: "http://httpmock/down?json"},
+		},
+		Full:    true,
+		Options: httpclient.Options{Timeout: 5 * time.Second},
+	}
+	stat, err := p.FetchMetrics()
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 5, stat["pool.processes.www.total_processes"])
+	assert.EqualValues(t, 128, stat["pool.listen_queue_len.www.listen_queue_len"])
+	assert.EqualValues(t, 4, stat["pool.slow_requests.www.slow_requests"])
+	assert.EqualValues(t, 120.0, stat["pool.request_duration.www.slowest_request_duration"])
+	assert.EqualValues(t, 36.0, stat["pool.request_duration.www.avg_request_duration"])
+	assert.EqualValues(t, 20.5, stat["pool.request_cpu.www.avg_request_cpu"])
+	assert.EqualValues(t, 6291456, stat["pool.process_memory.www.total_memory"])
+	assert.EqualValues(t, 3, stat["pool.processes.backend.total_processes"])
+	assert.NotContains(t, stat, "pool.processes.api.total_processes")
+	assert.NotContains(t, stat, "pool.request_cpu.backend.avg_request_cpu")
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "pool.processes.#")
+	assert.Contains(t, graphdef, "pool.request_duration.#")
+	assert.NotContains(t, graphdef, "processes")
+
+	p.Pools = p.Pools[2:]
+	_, err = p.FetchMetrics()
+	assert.Error(t, err)
+}
+
+func TestFetchPoolMetrics_Socket(t *testing.T) {
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"pool":%q,"active processes":1,"total processes":2}`, name)
+		}
+	}
+	dir := t.TempDir()
+	www, err := NewFastCGIServer("unix", filepath.Join(dir, "www.sock"), handler("www"))
+	require.NoError(t, err)
+	defer www.Close()
+	api, err := NewFastCGIServer("tcp", "127.0.0.1:0", handler("api"))
+	require.NoError(t, err)
+	defer api.Close()
+
+	var f PoolFlag
+	require.NoError(t, f.Set("unix://"+www.Address))
+	require.NoError(t, f.Set(api.Address))
+	p := PhpFpmPlugin{
+		URL:     www.URL,
+		Pools:   f,
+		Options: httpclient.Options{Timeout: 5 * time.Second},
+	}
+	stat, err := p.FetchMetrics()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stat["pool.processes.www.active_processes"])
+	assert.EqualValues(t, 2, stat["pool.processes.api.total_processes"])
+}
@@ -5,10 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"strconv"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -18,6 +18,7 @@ type PlackPlugin struct {
 	URI         string
 	Prefix      string
 	LabelPrefix string
+	httpclient.Options
 }
 
 // {
@@ -77,13 +78,12 @@ type PlackServerStatus struct {
 
 // FetchMetrics interface for mackerelplugin
 func (p PlackPlugin) FetchMetrics() (map[string]any, error) {
-	req, err := http.NewRequest(http.MethodGet, p.URI, nil)
+	p.UserAgent = "mackerel-plugin-plack"
+	client, err := p.Options.NewClient()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "mackerel-plugin-plack")
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Get(p.URI)
 	if err != nil {
 		return nil, err
 	}
@@ -173,9 +173,11 @@ func Do() {
 	optPrefix := flag.String("metric-key-prefix", "plack", "Prefix")
 	optLabelPrefix := flag.String("metric-label-prefix", "", "Label Prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	var plack PlackPlugin
+	plack.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	plack := PlackPlugin{URI: *optURI, Prefix: *optPrefix, LabelPrefix: *optLabelPrefix}
+	plack.URI, plack.Prefix, plack.LabelPrefix = *optURI, *optPrefix, *optLabelPrefix
 	if plack.URI == "" {
 		plack.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
 	}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"net/http"
 	"net/url"
 	"os"
 	"strconv"
@@ -13,6 +12,7 @@ import (
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/httpclient"
 )
 
 var (
@@ -48,6 +48,10 @@ type SolrPlugin struct {
 	Prefix   string
 	Stats    map[string](map[string]float64)
 	Tempfile string
+	httpclient.Options
+
+	// client is built on the first request and shared by all the requests of the run
+	client *httpclient.Client
 }
 
 func (s *SolrPlugin) greaterThanOrEqualToMajorVersion(minVer int) bool {
@@ -59,14 +63,18 @@ func (s *SolrPlugin) greaterThanOrEqualToMajorVersion(minVer int) bool {
 	return currentVer >= minVer
 }
 
-func fetchJSONData(url string) (map[string]any, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+func (s *SolrPlugin) fetchJSONData(url string) (map[string]any, error) {
+	if s.client == nil {
+		options := s.Options
+		options.UserAgent = "mackerel-plugin-solr"
+		client, err := options.NewClient()
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
 	}
-	req.Header.Set("User-Agent", "mackerel-plugin-solr")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.client.Get(url)
 	if err != nil {
 		logger.Errorf("Failed to %s", err)
 		return nil, err
@@ -140,7 +148,7 @@ func (s *SolrPlugin) loadStatsMbeanHandler(core string, cat string) error {
 	for _, path := range handlerPaths {
 		fmt.Fprintf(&uri, "&key=%s", url.QueryEscape(path))
 	}
-	stats, err := fetchJSONData(uri.String())
+	stats, err := s.fetchJSONData(uri.String())
 	if err != nil {
 		return err
 	}
@@ -155,7 +163,7 @@ func (s *SolrPlugin) loadStatsMbeanHandler(core string, cat string) error {
 }
 
 func (s *SolrPlugin) loadStatsMbeanCache(core string) error {
-	stats, err := fetchJSONData(s.BaseURL + "/" + core + "/admin/mbeans?stats=true&wt=json&cat=CACHE&key=filterCache&key=perSegFilter&key=queryResultCache&key=documentCache&key=fieldValueCache")
+	stats, err := s.fetchJSONData(s.BaseURL + "/" + core + "/admin/mbeans?stats=true&wt=json&cat=CACHE&key=filterCache&key=perSegFilter&key=queryResultCache&key=documentCache&key=fieldValueCache")
 	if err != nil {
 		return err
 	}
@@ -164,7 +172,7 @@ func (s *SolrPlugin) loadStatsMbeanCache(core string) error {
 }
 
 func (s *SolrPlugin) loadVersion() error {
-	stats, err := fetchJSONData(s.BaseURL + "/admin/info/system?wt=json")
+	stats, err := s.fetchJSONData(s.BaseURL + "/admin/info/system?wt=json")
 	if err != nil {
 		return err
 	}
@@ -183,7 +191,7 @@ func (s *SolrPlugin) loadVersion() error {
 func (s *SolrPlugin) loadStats() error {
 	s.Stats = map[string](map[string]float64){}
 
-	stats, err := fetchJSONData(s.BaseURL + "/admin/cores?wt=json")
+	stats, err := s.fetchJSONData(s.BaseURL + "/admin/cores?wt=json")
 	if err != nil {
 		return err
 	}
@@ -314,17 +322,24 @@ func (s SolrPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
+	optProtocol := flag.String("protocol", "http", "Protocol, http or https")
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "8983", "Port")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	var solr SolrPlugin
+	solr.Options.Register(flag.CommandLine)
 	flag.Parse()
 
-	solr := SolrPlugin{
-		Protocol: "http",
-		Host:     *optHost,
-		Port:     *optPort,
-		Prefix:   "solr",
+	switch *optProtocol {
+	case "http", "https":
+		solr.Protocol = *optProtocol
+	default:
+		logger.Errorf("Unknown protocol: %s", *optProtocol)
+		os.Exit(1)
 	}
+	solr.Host = *optHost
+	solr.Port = *optPort
+	solr.Prefix = "solr"
 
 	solr.BaseURL = fmt.Sprintf("%s://%s:%s/solr", solr.Protocol, solr.Host, solr.Port)
 	err := solr.loadVersion()
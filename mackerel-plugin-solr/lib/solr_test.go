@@ -133,7 +133,10 @@ func TestFetchMetrics(t *testing.T) {
 
 	for _, version := range SolrVersions {
 		solr := setupSolr(ts.URL, version)
+		client := solr.client
 		solr.loadStats()
+		// the client is shared among the requests
+		assert.Same(t, client, solr.client)
 		stat, err := solr.FetchMetrics()
 		if err != nil {
 			t.Fatal(err)